@@ -27,4 +27,7 @@ var Cmd = &cobra.Command{
 
 func init() {
 	Cmd.AddCommand(InitConfigCmd)
+	Cmd.AddCommand(SchemaCmd)
+	Cmd.AddCommand(ValidateCmd)
+	Cmd.AddCommand(ConvertCmd)
 }
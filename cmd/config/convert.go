@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+var ConvertCmd = &cobra.Command{
+	Use:     "convert [file]",
+	Short:   "Convert an nginx or Traefik dynamic configuration to a Goma configuration",
+	Example: "goma config convert --from nginx /etc/nginx/nginx.conf -o goma.yml",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			logger.Fatal(`"convert" requires a configuration file`)
+		}
+		from, _ := cmd.Flags().GetString("from")
+		buf, err := os.ReadFile(args[0])
+		if err != nil {
+			logger.Fatal("Error reading configuration: %v", err)
+		}
+		conf, warnings, err := pkg.ConvertConfig(from, buf)
+		if err != nil {
+			logger.Fatal("Error converting configuration: %v", err)
+		}
+		// Warnings are written to the standard error, the configuration can be redirected
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "WARN: %s\n", warning)
+		}
+		yamlData, err := yaml.Marshal(conf)
+		if err != nil {
+			logger.Fatal("Error serializing configuration: %v", err)
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Print(string(yamlData))
+			return
+		}
+		if err := os.WriteFile(output, yamlData, 0644); err != nil {
+			logger.Fatal("Unable to write config file %s", err)
+		}
+		logger.Info("Configuration converted to %s, %d warnings", output, len(warnings))
+	},
+}
+
+func init() {
+	ConvertCmd.Flags().StringP("from", "", pkg.ConvertFromNginx, "source configuration format, nginx or traefik")
+	ConvertCmd.Flags().StringP("output", "o", "", "config file output")
+}
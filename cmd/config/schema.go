@@ -0,0 +1,42 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var SchemaCmd = &cobra.Command{
+	Use:     "schema",
+	Short:   "Print the JSON Schema of the configuration file",
+	Example: "goma config schema -o goma.schema.json",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			logger.Fatal(`"schema" accepts no argument %q`, args)
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(pkg.ConfigSchema()); err != nil {
+			logger.Fatal("Error encoding schema: %v", err)
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Print(buf.String())
+			return
+		}
+		if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+			logger.Fatal("Error writing schema: %v", err)
+		}
+		logger.Info("Schema written to %s", output)
+	},
+}
+
+func init() {
+	SchemaCmd.Flags().StringP("output", "o", "", "schema file output")
+}
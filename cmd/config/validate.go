@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var ValidateCmd = &cobra.Command{
+	Use:     "validate",
+	Short:   "Validate the configuration file",
+	Example: "goma config validate --config goma.yml",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			logger.Fatal(`"validate" accepts no argument %q`, args)
+		}
+		configFile, _ := cmd.Flags().GetString("config")
+		if configFile == "" {
+			configFile = pkg.GetConfigPaths()
+		}
+		errors, err := pkg.ValidateConfig(configFile)
+		if err != nil {
+			logger.Fatal("Error validating configuration: %v", err)
+		}
+		if len(errors) != 0 {
+			for _, e := range errors {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", configFile)
+	},
+}
+
+func init() {
+	ValidateCmd.Flags().StringP("config", "", "", "Goma config file")
+}
@@ -0,0 +1,58 @@
+// Package cmd /
+/*****
+@author    Jonas Kaninda
+@license   MIT License <https://opensource.org/licenses/MIT>
+@Copyright © 2024 Jonas Kaninda
+**/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var HashPasswordCmd = &cobra.Command{
+	Use:     "hash-password",
+	Short:   "Generate a password hash for basic auth",
+	Example: "echo -n 'secret' | goma hash-password --username admin",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			logger.Fatal(`"hash-password" accepts no argument %q`, args)
+		}
+		algorithm, _ := cmd.Flags().GetString("algorithm")
+		username, _ := cmd.Flags().GetString("username")
+		password, _ := cmd.Flags().GetString("password")
+		if password == "" {
+			// Read the password from the standard input
+			reader := bufio.NewReader(os.Stdin)
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				logger.Fatal("Error reading password: %v", err)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+		if password == "" {
+			logger.Fatal("Password is required")
+		}
+		hash, err := middleware.HashPassword(algorithm, password)
+		if err != nil {
+			logger.Fatal("Error hashing password: %v", err)
+		}
+		if username != "" {
+			fmt.Printf("%s:%s\n", username, hash)
+			return
+		}
+		fmt.Println(hash)
+	},
+}
+
+func init() {
+	HashPasswordCmd.Flags().StringP("algorithm", "a", middleware.HashBcrypt, "Hash algorithm | bcrypt, argon2id, sha256, sha512")
+	HashPasswordCmd.Flags().StringP("username", "u", "", "Username, prints an htpasswd entry")
+	HashPasswordCmd.Flags().StringP("password", "p", "", "Password, read from the standard input when empty")
+}
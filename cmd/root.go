@@ -33,5 +33,6 @@ func Execute() {
 func init() {
 	rootCmd.AddCommand(ServerCmd)
 	rootCmd.AddCommand(config.Cmd)
+	rootCmd.AddCommand(HashPasswordCmd)
 
 }
@@ -0,0 +1,365 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"github.com/jkaninda/goma/util"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces secrets in the admin API config
+const redacted = "REDACTED"
+
+// adminHealthCheckTimeout is the backend health check timeout of the admin API, it's replaced in tests
+var adminHealthCheckTimeout = 5 * time.Second
+
+// AdminServer serves the admin API, runtime inspection and management
+type AdminServer struct {
+	runtime *gatewayRuntime
+	token   string
+	users   map[string]string
+}
+
+// NewAdminServer creates the admin API of the running gateway
+func NewAdminServer(runtime *gatewayRuntime) (*AdminServer, error) {
+	admin := runtime.current().gateway.Admin
+	if admin.Token == "" && len(admin.Users) == 0 {
+		return nil, errors.New("admin API requires a token or users")
+	}
+	users, err := middleware.ParseHtpasswd(admin.Users)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminServer{runtime: runtime, token: admin.Token, users: users}, nil
+}
+
+// ListenAndServe starts the admin API on the admin listen address
+func (admin *AdminServer) ListenAndServe() error {
+	gateway := admin.runtime.current().gateway
+	srv := &http.Server{
+		Addr:         gateway.Admin.ListenAddr,
+		WriteTimeout: time.Second * time.Duration(gateway.WriteTimeout),
+		ReadTimeout:  time.Second * time.Duration(gateway.ReadTimeout),
+		IdleTimeout:  time.Second * time.Duration(gateway.IdleTimeout),
+		Handler:      admin.Router(),
+	}
+	return srv.ListenAndServe()
+}
+
+// Router returns the admin API routes
+func (admin *AdminServer) Router() *mux.Router {
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(admin.authMiddleware)
+	api.HandleFunc("/config", admin.configHandler).Methods("GET")
+	api.HandleFunc("/routes", admin.routesHandler).Methods("GET")
+	api.HandleFunc("/backends", admin.backendsHandler).Methods("GET")
+	api.HandleFunc("/backends/drain", admin.drainHandler).Methods("POST")
+	api.HandleFunc("/backends/enable", admin.enableHandler).Methods("POST")
+	api.HandleFunc("/ratelimit", admin.rateLimitHandler).Methods("GET")
+	api.HandleFunc("/ratelimit/clients/{client}", admin.resetRateLimitHandler).Methods("DELETE")
+	api.HandleFunc("/mirrors", admin.mirrorsHandler).Methods("GET")
+	api.HandleFunc("/reload", admin.reloadHandler).Methods("POST")
+	return r
+}
+
+// authMiddleware checks the admin bearer token or basic auth credentials
+func (admin *AdminServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if admin.token != "" {
+			scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if ok && strings.EqualFold(scheme, "Bearer") && subtle.ConstantTimeCompare([]byte(token), []byte(admin.token)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if len(admin.users) != 0 {
+			if username, password, ok := r.BasicAuth(); ok {
+				if hash, exists := admin.users[username]; exists && middleware.VerifyPassword(hash, password) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="Goma Admin"`)
+		}
+		logger.Error("Admin API: unauthorized request from %s", r.RemoteAddr)
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	})
+}
+
+// configHandler returns the loaded configuration, secrets are redacted
+func (admin *AdminServer) configHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayServer := admin.runtime.current()
+	config, err := redactConfig(GatewayConfig{GatewayConfig: gatewayServer.gateway, Middlewares: gatewayServer.middlewares})
+	if err != nil {
+		logger.Error("Admin API: %v", err)
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// AdminRoute is a route returned by the admin API
+type AdminRoute struct {
+	Name        string                 `json:"name"`
+	Path        string                 `json:"path"`
+	Type        string                 `json:"type"`
+	Destination string                 `json:"destination"`
+	Middlewares []AdminRouteMiddleware `json:"middlewares"`
+}
+
+// AdminRouteMiddleware is a route path middlewares chain
+type AdminRouteMiddleware struct {
+	Path  string `json:"path"`
+	Chain string `json:"chain"`
+}
+
+// routesHandler returns the routes and their middlewares chains
+func (admin *AdminServer) routesHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayServer := admin.runtime.current()
+	routes := []AdminRoute{}
+	for _, route := range gatewayServer.gateway.Routes {
+		adminRoute := AdminRoute{
+			Name:        route.Name,
+			Path:        route.Path,
+			Type:        route.Type,
+			Destination: routeDestination(route),
+			Middlewares: []AdminRouteMiddleware{},
+		}
+		if adminRoute.Type == "" {
+			adminRoute.Type = RouteTypeProxy
+		}
+		for _, mid := range route.Middlewares {
+			adminRoute.Middlewares = append(adminRoute.Middlewares, AdminRouteMiddleware{
+				Path:  util.ParseURLPath(route.Path + mid.Path),
+				Chain: describeChain(mid, gatewayServer.middlewares),
+			})
+		}
+		routes = append(routes, adminRoute)
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// AdminBackend is a backend returned by the admin API
+type AdminBackend struct {
+	Destination string   `json:"destination"`
+	Routes      []string `json:"routes"`
+	Drained     bool     `json:"drained"`
+	// Status is healthy, unhealthy or unknown when the route has no health check
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// backendsHandler returns the proxy routes backends, their health and drain state
+func (admin *AdminServer) backendsHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayServer := admin.runtime.current()
+	backends := map[string]*AdminBackend{}
+	healthChecks := map[string]Route{}
+	add := func(destination string, route Route) {
+		key := backendKey(destination)
+		backend, ok := backends[key]
+		if !ok {
+			backend = &AdminBackend{Destination: key, Drained: IsBackendDrained(key), Status: "unknown"}
+			backends[key] = backend
+		}
+		backend.Routes = append(backend.Routes, route.Name)
+		if _, ok := healthChecks[key]; !ok && route.HealthCheck != "" {
+			healthChecks[key] = route
+		}
+	}
+	for _, route := range gatewayServer.gateway.Routes {
+		if route.Type != "" && route.Type != RouteTypeProxy {
+			continue
+		}
+		if route.Discovery.Type != "" {
+			for _, backend := range route.Discovery.discovered() {
+				add(backend.Destination, route)
+			}
+			continue
+		}
+		if len(route.Backends) != 0 {
+			for _, backend := range route.Backends {
+				add(backend.Destination, route)
+			}
+			continue
+		}
+		add(route.Destination, route)
+	}
+	var wg sync.WaitGroup
+	for key, route := range healthChecks {
+		wg.Add(1)
+		go func(backend *AdminBackend, route Route) {
+			defer wg.Done()
+			// A hung backend must not hang the admin API
+			ctx, cancel := context.WithTimeout(r.Context(), adminHealthCheckTimeout)
+			defer cancel()
+			if err := HealthCheckContext(ctx, backend.Destination+route.HealthCheck, route.UpstreamTLS); err != nil {
+				backend.Status = "unhealthy"
+				backend.Error = err.Error()
+				return
+			}
+			backend.Status = "healthy"
+		}(backends[key], route)
+	}
+	wg.Wait()
+	response := []AdminBackend{}
+	for _, backend := range backends {
+		response = append(response, *backend)
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Destination < response[j].Destination })
+	writeJSON(w, http.StatusOK, response)
+}
+
+// adminBackendRequest is the drain and enable request body
+type adminBackendRequest struct {
+	Destination string `json:"destination"`
+}
+
+// drainHandler stops sending requests to a backend
+func (admin *AdminServer) drainHandler(w http.ResponseWriter, r *http.Request) {
+	destination, ok := backendDestination(w, r)
+	if !ok {
+		return
+	}
+	DrainBackend(destination)
+	logger.Info("Admin API: backend %s drained", destination)
+	writeJSON(w, http.StatusOK, map[string]any{"destination": backendKey(destination), "drained": true})
+}
+
+// enableHandler sends requests to a drained backend again
+func (admin *AdminServer) enableHandler(w http.ResponseWriter, r *http.Request) {
+	destination, ok := backendDestination(w, r)
+	if !ok {
+		return
+	}
+	EnableBackend(destination)
+	logger.Info("Admin API: backend %s enabled", destination)
+	writeJSON(w, http.StatusOK, map[string]any{"destination": backendKey(destination), "drained": false})
+}
+
+// backendDestination reads the backend destination from the request body
+func backendDestination(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request adminBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Destination == "" {
+		RespondWithError(w, http.StatusBadRequest, "destination is required")
+		return "", false
+	}
+	return request.Destination, true
+}
+
+// AdminRateLimit contains the global rate limiter counters
+type AdminRateLimit struct {
+	Enabled bool                   `json:"enabled"`
+	Limit   int                    `json:"limit"`
+	Window  string                 `json:"window"`
+	Clients []AdminRateLimitClient `json:"clients"`
+}
+
+// AdminRateLimitClient is a client request counter
+type AdminRateLimitClient struct {
+	Client    string    `json:"client"`
+	Requests  int       `json:"requests"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// rateLimitHandler returns the global rate limiter counters
+func (admin *AdminServer) rateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	limiter := admin.runtime.current().rateLimiter
+	response := AdminRateLimit{Clients: []AdminRateLimitClient{}}
+	if limiter != nil {
+		response.Enabled = true
+		response.Limit = limiter.Requests
+		response.Window = limiter.Window.String()
+		for client, counter := range limiter.Clients() {
+			response.Clients = append(response.Clients, AdminRateLimitClient{Client: client, Requests: counter.RequestCount, ExpiresAt: counter.ExpiresAt})
+		}
+		sort.Slice(response.Clients, func(i, j int) bool { return response.Clients[i].Client < response.Clients[j].Client })
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// resetRateLimitHandler resets a client request counter
+func (admin *AdminServer) resetRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	limiter := admin.runtime.current().rateLimiter
+	client := mux.Vars(r)["client"]
+	if limiter == nil || !limiter.Reset(client) {
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("no rate limit counter for client %s", client))
+		return
+	}
+	logger.Info("Admin API: rate limit counter of %s reset", client)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mirrorsHandler returns the traffic mirroring metrics
+func (admin *AdminServer) mirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := GetMirrorMetrics()
+	if metrics == nil {
+		metrics = []MirrorMetricsSnapshot{}
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+// reloadHandler reloads the configuration file
+func (admin *AdminServer) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := admin.runtime.Reload(); err != nil {
+		logger.Error("Admin API: error reloading configuration: %v", err)
+		RespondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "message": "Configuration reloaded"})
+}
+
+// redactConfig converts the configuration to a generic value and redacts the secrets
+func redactConfig(config GatewayConfig) (any, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling config: %w", err)
+	}
+	var value map[string]any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %w", err)
+	}
+	return redactValue(value), nil
+}
+
+// redactValue replaces the values of secret keys, e.g. password, clientSecret, token, hash or users
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			name := strings.ToLower(key)
+			if item != nil && item != "" && (strings.Contains(name, "password") || strings.Contains(name, "secret") ||
+				strings.Contains(name, "token") || name == "hash" || name == "users") {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		return
+	}
+}
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const adminTestConfig = `
+gateway:
+  listenAddr: 0.0.0.0:8080
+  rateLimiter: 100
+  admin:
+    listenAddr: 127.0.0.1:9090
+    token: admin-token
+  routes:
+    - name: store
+      path: /store
+      rewrite: /
+      destination: BACKEND
+      healthCheck: /health
+      middlewares:
+        - path: /admin
+          rules:
+            - basic-auth
+middlewares:
+  - name: basic-auth
+    type: basic
+    rule:
+      username: admin
+      password: admin-password
+`
+
+func TestAdminServer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("store"))
+	}))
+	defer backend.Close()
+	configFile := filepath.Join(t.TempDir(), "goma.yml")
+	if err := os.WriteFile(configFile, []byte(strings.Replace(adminTestConfig, "BACKEND", backend.URL, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gatewayServer, err := GatewayServer{}.New(configFile)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	handler := newGatewayRuntime(gatewayServer)
+	admin, err := NewAdminServer(handler)
+	if err != nil {
+		t.Fatalf("Error creating admin server: %v", err)
+	}
+	router := admin.Router()
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	proxy := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/store/items", nil)
+		r.RemoteAddr = "192.0.2.10:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if rec := call(http.MethodGet, "/api/v1/config", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	rec := call(http.MethodGet, "/api/v1/config", "admin-token", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "admin-password") || strings.Contains(rec.Body.String(), "admin-token") {
+		t.Errorf("expected redacted config, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/v1/routes", "admin-token", "")
+	if !strings.Contains(rec.Body.String(), `"chain":"basic-auth"`) {
+		t.Errorf("expected route middlewares chain, got %s", rec.Body.String())
+	}
+	var backends []AdminBackend
+	rec = call(http.MethodGet, "/api/v1/backends", "admin-token", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &backends); err != nil || len(backends) != 1 || backends[0].Status != "healthy" {
+		t.Errorf("expected a healthy backend, got %s", rec.Body.String())
+	}
+
+	// Drain and enable the backend
+	if rec := call(http.MethodPost, "/api/v1/backends/drain", "admin-token", `{"destination":"`+backend.URL+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if code := proxy(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected drained backend status code %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	call(http.MethodPost, "/api/v1/backends/enable", "admin-token", `{"destination":"`+backend.URL+`"}`)
+	if code := proxy(); code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	// Rate limit counters
+	rec = call(http.MethodGet, "/api/v1/ratelimit", "admin-token", "")
+	if !strings.Contains(rec.Body.String(), `"client":"192.0.2.10"`) {
+		t.Errorf("expected client counter, got %s", rec.Body.String())
+	}
+	if rec := call(http.MethodDelete, "/api/v1/ratelimit/clients/192.0.2.10", "admin-token", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/api/v1/ratelimit/clients/192.0.2.10", "admin-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Reload a new route
+	config := strings.Replace(adminTestConfig, "BACKEND", backend.URL, 1)
+	config = strings.Replace(config, "  routes:\n", "  routes:\n    - name: cart\n      path: /cart\n      destination: "+backend.URL+"\n", 1)
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := call(http.MethodPost, "/api/v1/reload", "admin-token", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	r := httptest.NewRequest(http.MethodGet, "/cart/items", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "store" {
+		t.Errorf("expected the reloaded route, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodGet, "/api/v1/routes", "admin-token", ""); !strings.Contains(rec.Body.String(), `"name":"cart"`) {
+		t.Errorf("expected the reloaded routes, got %s", rec.Body.String())
+	}
+}
+
+func TestNewAdminServerRequiresCredentials(t *testing.T) {
+	_, err := NewAdminServer(newGatewayRuntime(&GatewayServer{gateway: Gateway{Admin: Admin{ListenAddr: "127.0.0.1:9090"}}}))
+	if err == nil {
+		t.Error("expected an error without token and users")
+	}
+}
+
+func TestAdminBackendsHealthCheckTimeout(t *testing.T) {
+	adminHealthCheckTimeout = 100 * time.Millisecond
+	defer func() { adminHealthCheckTimeout = 5 * time.Second }()
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	admin, err := NewAdminServer(newGatewayRuntime(&GatewayServer{gateway: Gateway{Admin: Admin{Token: "admin-token"}, Routes: []Route{
+		{Name: "hung", Path: "/hung", Destination: hung.URL, HealthCheck: "/health"},
+		{Name: "healthy", Path: "/healthy", Destination: healthy.URL, HealthCheck: "/health"},
+	}}}))
+	if err != nil {
+		t.Fatalf("Error creating admin server: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/backends", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	admin.Router().ServeHTTP(rec, r)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the health checks to time out, took %v", elapsed)
+	}
+	var backends []AdminBackend
+	if err := json.Unmarshal(rec.Body.Bytes(), &backends); err != nil {
+		t.Fatalf("Error decoding backends: %v", err)
+	}
+	status := map[string]string{}
+	for _, backend := range backends {
+		status[backend.Destination] = backend.Status
+	}
+	if status[hung.URL] != "unhealthy" || status[healthy.URL] != "healthy" {
+		t.Errorf("unexpected backend status: %v", status)
+	}
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
 	"github.com/jkaninda/goma/util"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -14,9 +15,24 @@ var cfg *Gateway
 type Config struct {
 	file string
 }
+
+// BasicRule defines basic authentication users
 type BasicRule struct {
 	Username string `yaml:"username"`
+	// Password defines the user password, plaintext or hash.
+	//
+	// bcrypt, argon2, {SHA}, {SHA256} and {SHA512} hashes are supported, see goma hash-password
 	Password string `yaml:"password"`
+	// Users contains htpasswd entries, username:hash
+	Users []string `yaml:"users"`
+	// HtpasswdFile defines an htpasswd file containing additional users
+	HtpasswdFile string `yaml:"htpasswdFile"`
+	// Realm defines the authentication realm, default is Restricted
+	Realm string `yaml:"realm"`
+	// UserHeader defines the backend request header receiving the authenticated username
+	//
+	// e.g. X-Auth-User
+	UserHeader string `yaml:"userHeader"`
 }
 
 type Cors struct {
@@ -60,6 +76,167 @@ type JWTRuler struct {
 	//
 	//e.g: Header X-Auth-UserId to query userId
 	Params map[string]string `yaml:"params"`
+	// ForwardHeaders contains the request headers sent to the authentication service, all headers are sent when empty.
+	//
+	// X-Forwarded-Method, X-Forwarded-Uri, X-Forwarded-Host, X-Forwarded-Proto and X-Forwarded-For are always sent.
+	ForwardHeaders []string `yaml:"forwardHeaders"`
+	// ResponseHeaders contains the authentication response headers relayed to the client
+	// when the request is denied or redirected, default WWW-Authenticate, Location and Set-Cookie.
+	ResponseHeaders []string `yaml:"responseHeaders"`
+	// Timeout defines the authentication request timeout in seconds, default 10
+	Timeout int `yaml:"timeout"`
+	// CacheTTL caches the authentication decisions per token in seconds, disabled by default
+	CacheTTL int `yaml:"cacheTTL"`
+}
+
+// AccessRule allows or denies requests by client IP
+type AccessRule struct {
+	// Allow contains allowed IPs and CIDR ranges, all IPs are allowed when empty
+	//
+	// e.g. 10.0.0.0/8, 2001:db8::/32, 192.168.1.10
+	Allow []string `yaml:"allow"`
+	// Deny contains denied IPs and CIDR ranges, it takes precedence over Allow
+	Deny []string `yaml:"deny"`
+}
+
+// APIKeyRule authenticates requests with static API keys
+type APIKeyRule struct {
+	// Header defines the request header containing the key, default is X-API-Key
+	Header string `yaml:"header"`
+	// Query defines the query parameter containing the key
+	Query string `yaml:"query"`
+	// Cookie defines the cookie containing the key
+	Cookie string `yaml:"cookie"`
+	// Keys contains the API keys
+	Keys []APIKey `yaml:"keys"`
+	// KeysFile defines a YAML file containing the API keys, it's reloaded on change.
+	//
+	// e.g. keys: [{consumer: partner, hash: 'sha256:...'}]
+	KeysFile string `yaml:"keysFile"`
+	// Headers maps the key metadata to backend request headers, consumer and tier are set by default
+	//
+	// e.g. consumer: X-Consumer-Name
+	Headers map[string]string `yaml:"headers"`
+}
+
+// APIKey defines an API key and its consumer metadata
+type APIKey struct {
+	// Consumer defines the consumer name
+	Consumer string `yaml:"consumer"`
+	// Hash defines the key hash, sha256:<hex> or sha512:<hex>, a plain hex hash is a SHA-256
+	Hash string `yaml:"hash"`
+	// Routes contains the allowed route names or paths, all routes are allowed when empty
+	Routes []string `yaml:"routes"`
+	// Tier defines the consumer rate-limit tier
+	Tier string `yaml:"tier"`
+	// Metadata contains additional consumer metadata
+	Metadata map[string]string `yaml:"metadata"`
+}
+
+// OAuth2IntrospectRule validates bearer tokens with an OAuth2 token introspection endpoint, RFC 7662
+type OAuth2IntrospectRule struct {
+	// URL defines the introspection endpoint
+	URL string `yaml:"url"`
+	// ClientID defines the client credentials used to call the introspection endpoint
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// AuthMethod defines how client credentials are sent | client_secret_basic (default), client_secret_post
+	AuthMethod string `yaml:"authMethod"`
+	// RequiredScopes contains the scopes the token must have
+	RequiredScopes []string `yaml:"requiredScopes"`
+	// Audiences contains the accepted audiences, the token must have one of them
+	Audiences []string `yaml:"audiences"`
+	// CacheTTL defines how long active tokens are cached in seconds, bounded by the token expiry, default is 60
+	CacheTTL int `yaml:"cacheTTL"`
+	// NegativeCacheTTL defines how long inactive tokens are cached in seconds, default is 10
+	NegativeCacheTTL int `yaml:"negativeCacheTTL"`
+	// Timeout defines the introspection request timeout in seconds, default is 10
+	Timeout int `yaml:"timeout"`
+	// Headers maps introspection response fields to backend request headers
+	//
+	// e.g. sub: X-Auth-Subject
+	Headers map[string]string `yaml:"headers"`
+}
+
+// OIDCRule authenticates browsers with the OpenID Connect authorization code flow and PKCE
+type OIDCRule struct {
+	// Issuer defines the OpenID provider URL, used for discovery
+	Issuer string `yaml:"issuer"`
+	// ClientID defines the client credentials
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectURL defines the callback URL, its path must be under the protected path
+	//
+	// e.g. https://dashboard.example.com/admin/oauth2/callback
+	RedirectURL string `yaml:"redirectUrl"`
+	// Scopes contains the requested scopes, default is openid, email and profile
+	Scopes []string `yaml:"scopes"`
+	// CookieName defines the session cookie name, default is goma_session
+	CookieName string `yaml:"cookieName"`
+	// CookieSecret defines the session cookie encryption secret, at least 32 characters
+	CookieSecret string `yaml:"cookieSecret"`
+	// SessionTTL defines the session duration in seconds, default is 86400
+	SessionTTL int `yaml:"sessionTTL"`
+	// LogoutPath defines the path clearing the session, it must be under the protected path
+	LogoutPath string `yaml:"logoutPath"`
+	// PostLogoutRedirectURL defines where users are redirected after logout
+	PostLogoutRedirectURL string `yaml:"postLogoutRedirectUrl"`
+	// AllowedDomains contains the allowed email domains, all domains are allowed when empty
+	AllowedDomains []string `yaml:"allowedDomains"`
+	// AllowedGroups contains the allowed groups, the user must be in one of them
+	AllowedGroups []string `yaml:"allowedGroups"`
+	// GroupsClaim defines the groups claim name, default is groups
+	GroupsClaim string `yaml:"groupsClaim"`
+	// Headers maps ID token claims to backend request headers, default are sub and email
+	//
+	// e.g. email: X-Auth-Email
+	Headers map[string]string `yaml:"headers"`
+}
+
+// SignatureRule verifies HMAC request signatures, e.g. webhooks
+type SignatureRule struct {
+	// Secret defines the HMAC shared secret
+	Secret string `yaml:"secret"`
+	// Algorithm defines the HMAC hash, sha256 (default) or sha512
+	Algorithm string `yaml:"algorithm"`
+	// Header defines the signature header, default is X-Signature
+	//
+	// e.g. X-Hub-Signature-256, Stripe-Signature
+	Header string `yaml:"header"`
+	// Format defines the signature header format, plain (default) or stripe
+	//
+	// plain: the header contains the signature, optionally with a prefix
+	//
+	// stripe: the header contains the timestamp and the signatures, t=1492774577,v1=5257a869...
+	Format string `yaml:"format"`
+	// Prefix is removed from the signature, e.g. sha256=
+	Prefix string `yaml:"prefix"`
+	// Encoding defines the signature encoding, hex (default) or base64
+	Encoding string `yaml:"encoding"`
+	// TimestampHeader defines the header containing the request unix timestamp
+	TimestampHeader string `yaml:"timestampHeader"`
+	// Payload defines the signed payload, placeholders are {body}, {timestamp}, {method} and {path}
+	//
+	// Default is {body}, or {timestamp}.{body} with a timestamp
+	Payload string `yaml:"payload"`
+	// Tolerance defines the allowed timestamp age in seconds, default is 300
+	Tolerance int `yaml:"tolerance"`
+	// MaxBodySize defines the maximum body size in bytes, default is 10485760
+	MaxBodySize int64 `yaml:"maxBodySize"`
+}
+
+// MTLSRule restricts a route to verified client certificates
+type MTLSRule struct {
+	// Subjects contains the allowed certificate subjects, the common name or the full subject.
+	// All verified certificates are allowed when empty
+	//
+	// e.g. partner-a, CN=partner-a,O=Partner A
+	Subjects []string `yaml:"subjects"`
+	// Headers maps the certificate fields to backend request headers,
+	// fields are subject, issuer, sans, serial and fingerprint
+	//
+	// Default are subject: X-Client-Cert-Subject, sans: X-Client-Cert-SANs and fingerprint: X-Client-Cert-Fingerprint
+	Headers map[string]string `yaml:"headers"`
 }
 
 // Middleware defined the route middleware
@@ -68,7 +245,7 @@ type Middleware struct {
 	Name string `yaml:"name"`
 	// Type contains authentication types
 	//
-	// basic, jwt, auth0, rateLimit
+	// basic, jwt, forwardAuth, auth0, rateLimit, access, apiKey, oauth2Introspect, oidc, signature, mtls
 	Type string `yaml:"type"`
 	// Rule contains rule type of
 	Rule interface{} `yaml:"rule"`
@@ -81,20 +258,218 @@ type RouteMiddleware struct {
 	Path string `yaml:"path"`
 	//Rules defines which specific middleware applies to a route path
 	Rules []string `yaml:"rules"`
+	// Mode defines how the rules are combined, allOf (default) or anyOf
+	//
+	// allOf applies every rule in declared order, anyOf allows the request when one of the rules allows it
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// RewriteRule defines a regex based path rewrite
+type RewriteRule struct {
+	// Pattern is a regular expression matched against the request path
+	//
+	// e.g. ^/users/(\d+)/orders
+	Pattern string `yaml:"pattern"`
+	// Replacement is the new path, it supports regex capture groups ($1, ${name})
+	// and route path variables ({id}), environment variables are not interpolated.
+	//
+	// e.g. /orders?user=$1
+	Replacement string `yaml:"replacement" interpolate:"false"`
+}
+
+// Redirect defines a redirect route, no backend is called
+type Redirect struct {
+	// Scheme replaces the request scheme, e.g. https
+	Scheme string `yaml:"scheme"`
+	// Host replaces the request host, e.g. www.example.com
+	Host string `yaml:"host"`
+	// Path replaces the route path prefix, it supports route path variables ({id}).
+	//
+	// When Pattern is defined, Path is the replacement and supports capture groups ($1, ${name}),
+	// environment variables are not interpolated.
+	Path string `yaml:"path" interpolate:"false"`
+	// Pattern is a regular expression matched against the request path
+	//
+	// e.g. ^/blog/(\d+)
+	Pattern string `yaml:"pattern"`
+	// Code defines the redirect status code, 301, 302, 303, 307 or 308.
+	//
+	// Default is 302, or 301 when HTTPS is enabled
+	Code int `yaml:"code"`
+	// DropQuery removes the request query string from the redirect URL
+	DropQuery bool `yaml:"dropQuery"`
+	// HTTPS permanently redirects the request to HTTPS, keeping its host and path
+	HTTPS bool `yaml:"https"`
+}
+
+// Static defines a static route, files are served from a local directory
+type Static struct {
+	// Dir defines the local directory to serve
+	Dir string `yaml:"dir"`
+	// Index defines the directory index file, default is index.html
+	Index string `yaml:"index"`
+	// Browse lists the directory content when the index file does not exist
+	Browse bool `yaml:"browse"`
+	// SPA serves the index file when the requested file does not exist, for single-page applications
+	SPA bool `yaml:"spa"`
+	// Precompressed serves .br and .gz files when they exist and the client accepts them
+	Precompressed bool `yaml:"precompressed"`
+}
+
+// Mock defines a mock route, configured responses are returned without calling a backend
+type Mock struct {
+	// Responses defines the mock responses, the first matching response is returned
+	Responses []MockResponse `yaml:"responses"`
+}
+
+// MockResponse defines a mock response
+type MockResponse struct {
+	// Path defines the response path, relative to the route path, it supports route path variables.
+	//
+	// e.g. /users/{id}, all paths are matched when empty
+	Path string `yaml:"path"`
+	// Methods defines the response HTTP methods, all methods are matched when empty
+	Methods []string `yaml:"methods"`
+	// Status defines the response status code, default is 200
+	Status int `yaml:"status"`
+	// Headers defines the response headers
+	Headers map[string]string `yaml:"headers"`
+	// Body defines the response body, it's a Go template.
+	//
+	// e.g. {"id": "{{ .Vars.id }}", "page": "{{ .Query.page }}"}
+	Body string `yaml:"body"`
+	// BodyFile defines a file containing the response body, it's used when Body is empty
+	BodyFile string `yaml:"bodyFile"`
+	// Latency defines an artificial latency in milliseconds
+	Latency int `yaml:"latency"`
+	// ErrorRate defines the percentage of requests failing with ErrorStatus
+	ErrorRate int `yaml:"errorRate"`
+	// ErrorStatus defines the injected error status code, default is 500
+	ErrorStatus int `yaml:"errorStatus"`
+}
+
+// Mirror defines traffic mirroring, a copy of the requests is sent to a secondary backend.
+//
+// Mirror responses are discarded and never delay the client response.
+type Mirror struct {
+	// URL defines the mirror backend URL
+	URL string `yaml:"url"`
+	// Percentage defines the percentage of mirrored requests, default is 100
+	Percentage int `yaml:"percentage"`
+	// MaxBodySize defines the maximum request body size in bytes, default is 1048576.
+	//
+	// Requests with a larger body are not mirrored
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// Timeout defines the mirror request timeout in seconds, default is 10
+	Timeout int `yaml:"timeout"`
+}
+
+// Backend defines a weighted destination of a route, e.g. stable and canary versions
+type Backend struct {
+	// Name defines the backend name, it's visible in access logs
+	Name string `yaml:"name"`
+	// Destination Defines backend URL
+	Destination string `yaml:"destination"`
+	// Weight defines the percentage of requests sent to the backend
+	Weight int `yaml:"weight"`
+	// Match defines requests always sent to the backend, whatever the weight
+	Match BackendMatch `yaml:"match"`
+}
+
+// BackendMatch matches requests by headers and cookies, all values must match
+type BackendMatch struct {
+	// Headers e.g. X-Canary: 'true'
+	Headers map[string]string `yaml:"headers"`
+	// Cookies e.g. canary: 'true'
+	Cookies map[string]string `yaml:"cookies"`
+}
+
+// Discovery defines how the route backends are discovered, the backends are refreshed in the background
+type Discovery struct {
+	// Type defines the discovery type, dns, srv, file or consul
+	//
+	// dns creates a backend per A/AAAA record, srv uses the targets of the lowest priority with their weights,
+	// file reads a YAML or JSON list of backends, consul watches the healthy instances of a service
+	Type string `yaml:"type"`
+	// Name defines the DNS name, e.g. users.internal or _http._tcp.users.internal
+	Name string `yaml:"name"`
+	// Service defines the Consul service name, only healthy instances are used
+	Service string `yaml:"service"`
+	// Tag filters the Consul service instances by tag
+	Tag string `yaml:"tag"`
+	// Port defines the backends port of dns discovery
+	Port int `yaml:"port"`
+	// Scheme defines the backends scheme, default is http
+	Scheme string `yaml:"scheme"`
+	// File defines the targets file of file discovery
+	File string `yaml:"file"`
+	// RefreshInterval defines the refresh interval in seconds, default is 30 for DNS and 5 for files.
+	// DNS records TTL is not honoured, the Go resolver doesn't expose it.
+	// Consul changes are watched, it's the retry interval on errors, default is 5
+	RefreshInterval int `yaml:"refreshInterval"`
+}
+
+// Sticky keeps clients on the same backend
+type Sticky struct {
+	// Cookie defines the cookie name storing the assigned backend
+	Cookie string `yaml:"cookie"`
+	// Header defines a request header whose value is used to assign the backend, e.g. X-User-Id
+	Header string `yaml:"header"`
+}
+
+// UpstreamTLS defines the TLS settings used to connect to backends
+type UpstreamTLS struct {
+	// CAFile contains the CA bundle used to verify backend certificates, PEM encoded.
+	// The system CAs are used when empty
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile contain the client certificate and private key sent to backends, mutual TLS
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ServerName overrides the server name (SNI) sent and verified, default is the backend host
+	ServerName string `yaml:"serverName"`
+	// InsecureSkipVerify disables the backend certificate verification, for development only
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
 }
 
 // Route defines gateway route
 type Route struct {
 	// Name defines route name
 	Name string `yaml:"name"`
+	// Type defines the route type
+	//
+	// proxy (default), redirect, static, mock
+	Type string `yaml:"type"`
 	// Path defines route path
 	Path string `yaml:"path"`
+	// Hosts restricts the route to requests with one of the hosts, wildcards like *.example.com are supported
+	Hosts []string `yaml:"hosts,omitempty"`
 	// Rewrite rewrites route path to desired path
 	//
 	// E.g. /cart to / => It will rewrite /cart path to /
+	//
+	// Route path variables can be used, e.g. /v1/customers/{id}
 	Rewrite string `yaml:"rewrite"`
+	// RewriteRules defines regex rewrites, the first matching rule is applied
+	// and takes precedence over Rewrite
+	RewriteRules []RewriteRule `yaml:"rewriteRules"`
 	// Destination Defines backend URL
 	Destination string `yaml:"destination"`
+	// Backends defines weighted destinations, used instead of Destination
+	Backends []Backend `yaml:"backends,omitempty"`
+	// Discovery resolves the backends from DNS records or a targets file, used instead of destination
+	Discovery Discovery `yaml:"discovery,omitempty"`
+	// Sticky defines sticky backend assignment
+	Sticky Sticky `yaml:"sticky,omitempty"`
+	// Redirect defines the redirect of a redirect route
+	Redirect Redirect `yaml:"redirect,omitempty"`
+	// Static defines the directory of a static route
+	Static Static `yaml:"static,omitempty"`
+	// Mock defines the responses of a mock route
+	Mock Mock `yaml:"mock,omitempty"`
+	// Mirror defines the route traffic mirroring
+	Mirror Mirror `yaml:"mirror,omitempty"`
+	// UpstreamTLS defines the TLS settings of HTTPS backends
+	UpstreamTLS UpstreamTLS `yaml:"upstreamTls,omitempty"`
 	// Cors contains the route cors headers
 	Cors Cors `yaml:"cors"`
 	// DisableHeaderXForward Disable X-forwarded header.
@@ -111,6 +486,129 @@ type Route struct {
 	Middlewares []RouteMiddleware `yaml:"middlewares"`
 }
 
+// TLS defines the gateway HTTPS listener
+type TLS struct {
+	// ListenAddr defines the HTTPS listen address, default is 0.0.0.0:443
+	ListenAddr string `yaml:"listenAddr"`
+	// CertFile and KeyFile contain the server certificate and private key, PEM encoded
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientAuth configures the client certificates authentication
+	ClientAuth ClientAuth `yaml:"clientAuth,omitempty"`
+}
+
+// ClientAuth defines the client certificates authentication, mutual TLS
+type ClientAuth struct {
+	// CAFile contains the CA bundle used to verify client certificates, PEM encoded
+	CAFile string `yaml:"caFile"`
+	// CRLFile contains the CA certificate revocation list, PEM or DER encoded
+	CRLFile string `yaml:"crlFile"`
+	// Required requires a client certificate on all hosts
+	Required bool `yaml:"required"`
+	// Hosts requiring a client certificate, e.g. partner.example.com, *.b2b.example.com
+	//
+	// On other hosts, the client certificate is optional and verified when sent, use the mtls middleware to require it on routes.
+	Hosts []string `yaml:"hosts"`
+}
+
+// Admin defines the admin API
+type Admin struct {
+	// ListenAddr defines the admin API listen address, the admin API is disabled when empty
+	//
+	// e.g. 127.0.0.1:9090
+	ListenAddr string `yaml:"listenAddr"`
+	// Token defines the bearer token authenticating admin requests
+	Token string `yaml:"token"`
+	// Users contains htpasswd entries authenticating admin requests with basic auth
+	Users []string `yaml:"users"`
+}
+
+// ExtraConfig defines a directory of route and middleware files, e.g. one file per team
+type ExtraConfig struct {
+	// Directory contains .yml, .yaml and .json files with routes and middlewares, loaded in file name order.
+	// A relative directory is relative to the main configuration file
+	//
+	// e.g. conf.d
+	Directory string `yaml:"directory"`
+	// Watch reloads the configuration when files are added, changed or removed
+	Watch bool `yaml:"watch"`
+	// WatchInterval defines the files check interval in seconds, default is 5
+	WatchInterval int `yaml:"watchInterval"`
+}
+
+// ExtraRouteConfig is a file of the extra configuration directory
+type ExtraRouteConfig struct {
+	Routes      []Route      `yaml:"routes"`
+	Middlewares []Middleware `yaml:"middlewares"`
+}
+
+// Providers defines the dynamic sources of routes, their routes are added after the configuration file routes
+type Providers struct {
+	// Kubernetes builds routes from Ingress and Gateway API HTTPRoute objects
+	Kubernetes KubernetesProvider `yaml:"kubernetes,omitempty"`
+	// Docker builds routes from the labels of running containers
+	Docker DockerProvider `yaml:"docker,omitempty"`
+	// Consul resolves the backends of the routes with consul discovery, and registers the gateway
+	Consul ConsulProvider `yaml:"consul,omitempty"`
+}
+
+// ConsulProvider defines the Consul agent connection
+type ConsulProvider struct {
+	// Address defines the Consul agent address, default is http://127.0.0.1:8500
+	Address string `yaml:"address"`
+	// Token defines the Consul ACL token
+	Token string `yaml:"token"`
+	// Datacenter defines the services datacenter, default is the agent datacenter
+	Datacenter string `yaml:"datacenter"`
+	// Register registers the gateway in the Consul catalog
+	Register ConsulRegistration `yaml:"register,omitempty"`
+}
+
+// ConsulRegistration defines the gateway service registered in Consul, with a TCP health check
+type ConsulRegistration struct {
+	Enabled bool `yaml:"enabled"`
+	// Name defines the service name, default is goma
+	Name string `yaml:"name"`
+	// ID defines the service ID, default is the name and the hostname
+	ID string `yaml:"id"`
+	// Address defines the service address, default is the hostname
+	Address string `yaml:"address"`
+	// Port defines the service port, default is the listen address port
+	Port int `yaml:"port"`
+	// Tags defines the service tags
+	Tags []string `yaml:"tags"`
+}
+
+// DockerProvider defines the Docker Engine API connection
+type DockerProvider struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint defines the Docker Engine API, default is unix:///var/run/docker.sock
+	//
+	// e.g. tcp://docker:2375
+	Endpoint string `yaml:"endpoint"`
+	// Network defines the network of the containers addresses, default is the first container network
+	Network string `yaml:"network"`
+}
+
+// KubernetesProvider defines the Kubernetes API server connection and the watched objects
+type KubernetesProvider struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint defines the API server URL, default is the in-cluster API server
+	Endpoint string `yaml:"endpoint"`
+	// TokenFile defines the bearer token file, default is the service account token
+	TokenFile string `yaml:"tokenFile"`
+	// CAFile defines the API server CA file, default is the service account CA
+	CAFile string `yaml:"caFile"`
+	// Namespaces restricts the watched namespaces, default is all namespaces
+	Namespaces []string `yaml:"namespaces"`
+	// IngressClass defines the handled Ingress class, default is goma
+	IngressClass string `yaml:"ingressClass"`
+	// GatewayAPI enables Gateway API HTTPRoute objects
+	GatewayAPI bool `yaml:"gatewayApi"`
+	// GatewayName restricts HTTPRoute objects to the ones attached to the gateway
+	GatewayName string `yaml:"gatewayName"`
+}
+
 // Gateway contains Goma Proxy Gateway's configs
 type Gateway struct {
 	// ListenAddr Defines the server listenAddr
@@ -126,10 +624,23 @@ type Gateway struct {
 	// RateLimiter Defines number of request peer minute
 	RateLimiter                  int    `yaml:"rateLimiter" env:"GOMA_RATE_LIMITER, overwrite"`
 	AccessLog                    string `yaml:"accessLog" env:"GOMA_ACCESS_LOG, overwrite"`
-	ErrorLog                     string `yaml:"errorLog" env:"GOMA_ERROR_LOG=, overwrite"`
+	ErrorLog                     string `yaml:"errorLog" env:"GOMA_ERROR_LOG, overwrite"`
 	DisableRouteHealthCheckError bool   `yaml:"disableRouteHealthCheckError"`
 	//Disable dispelling routes on start
 	DisableDisplayRouteOnStart bool `yaml:"disableDisplayRouteOnStart"`
+	// TrustedProxies contains the IPs and CIDR ranges of trusted proxies.
+	//
+	// The client IP is resolved from Forwarded, X-Forwarded-For and X-Real-IP headers
+	// only when the request comes from a trusted proxy
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// TLS defines the HTTPS listener, it's enabled when the certificate is defined
+	TLS TLS `yaml:"tls,omitempty"`
+	// Admin defines the admin API, served on a separate listen address
+	Admin Admin `yaml:"admin,omitempty"`
+	// ExtraConfig loads additional routes and middlewares from a directory
+	ExtraConfig ExtraConfig `yaml:"extraConfig,omitempty"`
+	// Providers defines the dynamic sources of routes
+	Providers Providers `yaml:"providers,omitempty"`
 	// Cors contains the proxy global cors
 	Cors Cors `yaml:"cors"`
 	// Routes defines the proxy routes
@@ -147,24 +658,25 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 type GatewayServer struct {
+	configFile  string
 	gateway     Gateway
 	middlewares []Middleware
+	// rateLimiter is the global rate limiter, created on Initialize
+	rateLimiter *middleware.RateLimiter
+	// instances keeps the stateful middlewares across reloads, nil when the routes are initialized once
+	instances *middlewareInstances
 }
 
 // New reads config file and returns Gateway
 func (GatewayServer) New(configFile string) (*GatewayServer, error) {
 	if util.FileExists(configFile) {
-		buf, err := os.ReadFile(configFile)
-		if err != nil {
-			return nil, err
-		}
 		util.SetEnv("GOMA_CONFIG_FILE", configFile)
-		c := &GatewayConfig{}
-		err = yaml.Unmarshal(buf, c)
+		c, err := loadConfig(configFile)
 		if err != nil {
-			return nil, fmt.Errorf("in file %q: %w", configFile, err)
+			return nil, err
 		}
 		return &GatewayServer{
+			configFile:  configFile,
 			gateway:     c.GatewayConfig,
 			middlewares: c.Middlewares,
 		}, nil
@@ -173,18 +685,15 @@ func (GatewayServer) New(configFile string) (*GatewayServer, error) {
 	logger.Info("Generating new configuration file...")
 	initConfig(ConfigFile)
 	util.SetEnv("GOMA_CONFIG_FILE", ConfigFile)
-	buf, err := os.ReadFile(ConfigFile)
+	// The default configuration is loaded like a configuration file, with the environment overrides
+	c, err := loadConfig(ConfigFile)
 	if err != nil {
 		return nil, err
 	}
-	c := &GatewayConfig{}
-	err = yaml.Unmarshal(buf, c)
-	if err != nil {
-		return nil, fmt.Errorf("in file %q: %w", ConfigFile, err)
-	}
 	logger.Info("Generating new configuration file...done")
 	logger.Info("Starting server with default configuration")
 	return &GatewayServer{
+		configFile:  ConfigFile,
 		gateway:     c.GatewayConfig,
 		middlewares: c.Middlewares,
 	}, nil
@@ -296,13 +805,8 @@ func Get() *Gateway {
 }
 func (Gateway) Setup(conf string) *Gateway {
 	if util.FileExists(conf) {
-		buf, err := os.ReadFile(conf)
-		if err != nil {
-			return &Gateway{}
-		}
 		util.SetEnv("GOMA_CONFIG_FILE", conf)
-		c := &GatewayConfig{}
-		err = yaml.Unmarshal(buf, c)
+		c, err := loadConfig(conf)
 		if err != nil {
 			logger.Fatal("Error loading configuration %v", err.Error())
 		}
@@ -341,3 +845,87 @@ func ToBasicAuth(input interface{}) (BasicRule, error) {
 	}
 	return *basicAuth, nil
 }
+
+func ToAccessRule(input interface{}) (AccessRule, error) {
+	accessRule := new(AccessRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return AccessRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, accessRule)
+	if err != nil {
+		return AccessRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *accessRule, nil
+}
+
+func ToAPIKeyRule(input interface{}) (APIKeyRule, error) {
+	apiKeyRule := new(APIKeyRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return APIKeyRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, apiKeyRule)
+	if err != nil {
+		return APIKeyRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *apiKeyRule, nil
+}
+
+func ToOAuth2IntrospectRule(input interface{}) (OAuth2IntrospectRule, error) {
+	introspectRule := new(OAuth2IntrospectRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return OAuth2IntrospectRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, introspectRule)
+	if err != nil {
+		return OAuth2IntrospectRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *introspectRule, nil
+}
+
+func ToOIDCRule(input interface{}) (OIDCRule, error) {
+	oidcRule := new(OIDCRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return OIDCRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, oidcRule)
+	if err != nil {
+		return OIDCRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *oidcRule, nil
+}
+
+func ToSignatureRule(input interface{}) (SignatureRule, error) {
+	signatureRule := new(SignatureRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return SignatureRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, signatureRule)
+	if err != nil {
+		return SignatureRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *signatureRule, nil
+}
+
+func ToMTLSRule(input interface{}) (MTLSRule, error) {
+	mTLSRule := new(MTLSRule)
+	var bytes []byte
+	bytes, err := yaml.Marshal(input)
+	if err != nil {
+		return MTLSRule{}, fmt.Errorf("error marshalling yaml: %v", err)
+	}
+	err = yaml.Unmarshal(bytes, mTLSRule)
+	if err != nil {
+		return MTLSRule{}, fmt.Errorf("error unmarshalling yaml: %v", err)
+	}
+	return *mTLSRule, nil
+}
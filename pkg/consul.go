@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultConsulAddress = "http://127.0.0.1:8500"
+	// consulWait is the maximum duration of a Consul blocking query
+	consulWait = "5m"
+)
+
+// consulMinInterval is the minimum interval between Consul queries returning an unchanged or reset index,
+// it's read when a discovery starts and replaced in tests
+var consulMinInterval = time.Second
+
+// consulSettings contains the Consul agent connection of the running configuration
+var consulSettings atomic.Pointer[ConsulProvider]
+
+// address returns the Consul agent URL
+func (c ConsulProvider) address() string {
+	if c.Address == "" {
+		return defaultConsulAddress
+	}
+	if !strings.Contains(c.Address, "://") {
+		return "http://" + strings.TrimSuffix(c.Address, "/")
+	}
+	return strings.TrimSuffix(c.Address, "/")
+}
+
+// do sends a request to the Consul agent
+func (c ConsulProvider) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.address()+path+"?"+query.Encode(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status code %d: %s", path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// consulServiceEntry is an entry of the Consul health service endpoint, only the fields used by goma are decoded
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string `json:"ID"`
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// consulBackends returns the passing instances of the service, the query blocks until the service changes
+func (b *backendDiscovery) consulBackends(ctx context.Context, scheme string) ([]Backend, error) {
+	settings := ConsulProvider{}
+	if s := consulSettings.Load(); s != nil {
+		settings = *s
+	}
+	query := url.Values{"passing": {"true"}, "wait": {consulWait}}
+	if b.consulIndex > 0 {
+		query.Set("index", strconv.FormatUint(b.consulIndex, 10))
+	}
+	if b.discovery.Tag != "" {
+		query.Set("tag", b.discovery.Tag)
+	}
+	if settings.Datacenter != "" {
+		query.Set("dc", settings.Datacenter)
+	}
+	resp, err := settings.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(b.discovery.Service), query, nil)
+	if err != nil {
+		b.consulIndex = 0
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		b.consulIndex = 0
+		return nil, fmt.Errorf("error decoding service instances: %w", err)
+	}
+	// The index is reset when it goes backwards, e.g. after a Consul restart.
+	// Zero is not a blocking index, the query would return immediately
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index < b.consulIndex || index == 0 {
+		index = 1
+	}
+	b.consulIndex = index
+	backends := make([]Backend, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		host := net.JoinHostPort(address, strconv.Itoa(entry.Service.Port))
+		backends = append(backends, Backend{Name: entry.Service.ID, Destination: scheme + "://" + host, Weight: 1})
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Destination < backends[j].Destination })
+	return backends, nil
+}
+
+// registerConsul registers the gateway service with a TCP health check, critical services are removed after a minute
+func registerConsul(gateway Gateway) error {
+	consul := gateway.Providers.Consul
+	registration := consul.Register
+	if registration.Name == "" {
+		registration.Name = "goma"
+	}
+	if registration.Address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("error reading hostname: %w", err)
+		}
+		registration.Address = hostname
+	}
+	if registration.Port == 0 {
+		_, port, err := net.SplitHostPort(gateway.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("error reading listen address port: %w", err)
+		}
+		registration.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("error reading listen address port: %w", err)
+		}
+	}
+	if registration.ID == "" {
+		registration.ID = registration.Name + "-" + registration.Address
+	}
+	service := map[string]interface{}{
+		"ID":      registration.ID,
+		"Name":    registration.Name,
+		"Address": registration.Address,
+		"Port":    registration.Port,
+		"Tags":    registration.Tags,
+		"Check": map[string]string{
+			"TCP":                            net.JoinHostPort(registration.Address, strconv.Itoa(registration.Port)),
+			"Interval":                       "10s",
+			"DeregisterCriticalServiceAfter": "1m",
+		},
+	}
+	resp, err := consul.do(context.Background(), http.MethodPut, "/v1/agent/service/register", nil, service)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	logger.Info("Registered service %s in Consul as %s", registration.Name, registration.ID)
+	return nil
+}
@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul is a Consul agent stand-in serving the health service endpoint with blocking queries
+type fakeConsul struct {
+	mu         sync.Mutex
+	index      uint64
+	instances  []string
+	changed    chan struct{}
+	registered map[string]interface{}
+}
+
+func newFakeConsul(instances ...string) *fakeConsul {
+	return &fakeConsul{index: 1, instances: instances, changed: make(chan struct{})}
+}
+
+func (f *fakeConsul) set(instances ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances = instances
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != "consul-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/agent/service/register" && r.Method == http.MethodPut:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewDecoder(r.Body).Decode(&f.registered)
+	case r.URL.Path == "/v1/health/service/store":
+		if r.URL.Query().Get("passing") != "true" || r.URL.Query().Get("dc") != "dc1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		index, changed := f.index, f.changed
+		f.mu.Unlock()
+		// Block until the service changes
+		if r.URL.Query().Get("index") == strconv.FormatUint(index, 10) {
+			select {
+			case <-changed:
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var entries []map[string]interface{}
+		for i, instance := range f.instances {
+			host, port, _ := strings.Cut(instance, ":")
+			p, _ := strconv.Atoi(port)
+			entries = append(entries, map[string]interface{}{
+				"Node":    map[string]string{"Address": host},
+				"Service": map[string]interface{}{"ID": fmt.Sprintf("store-%d", i+1), "Port": p},
+			})
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		_ = json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	blue, green := newBackend("blue"), newBackend("green")
+	defer blue.Close()
+	defer green.Close()
+	fake := newFakeConsul(strings.TrimPrefix(blue.URL, "http://"))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	gatewayServer := &GatewayServer{gateway: Gateway{
+		ListenAddr: "0.0.0.0:8080",
+		Providers: Providers{Consul: ConsulProvider{Address: server.URL, Token: "consul-token", Datacenter: "dc1",
+			Register: ConsulRegistration{Enabled: true, Address: "10.0.0.10", Tags: []string{"gateway"}}}},
+		Routes: []Route{{Name: "store", Path: "/store", Discovery: Discovery{Type: DiscoveryTypeConsul, Service: "store"}}},
+	}}
+	handler := gatewayServer.Initialize()
+	request := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/store/items", nil))
+		return rec.Body.String()
+	}
+	if body := request(); body != "blue" {
+		t.Fatalf("expected the Consul instance, got %q", body)
+	}
+
+	// Blocking queries return the new instances
+	fake.set(strings.TrimPrefix(green.URL, "http://"))
+	deadline := time.Now().Add(5 * time.Second)
+	for request() != "green" {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the updated instances")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// No passing instance
+	fake.set()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/store/items", nil))
+		if rec.Code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the removed instances")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := registerConsul(gatewayServer.gateway); err != nil {
+		t.Fatalf("Error registering the gateway: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.registered["ID"] != "goma-10.0.0.10" || fake.registered["Port"] != float64(8080) {
+		t.Errorf("unexpected registration: %v", fake.registered)
+	}
+	if check, _ := fake.registered["Check"].(map[string]interface{}); check["TCP"] != "10.0.0.10:8080" {
+		t.Errorf("expected a TCP check, got %v", fake.registered["Check"])
+	}
+}
+
+func TestConsulDiscoveryIndexReset(t *testing.T) {
+	consulMinInterval = 100 * time.Millisecond
+	defer func() { consulMinInterval = time.Second }()
+	var mu sync.Mutex
+	var indexes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == "/v1/health/service/reset" {
+			indexes = append(indexes, r.URL.Query().Get("index"))
+		}
+		mu.Unlock()
+		// A zero index must not make the discovery send queries in a loop
+		w.Header().Set("X-Consul-Index", "0")
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	consulSettings.Store(&ConsulProvider{Address: server.URL})
+	discovery, err := Discovery{Type: DiscoveryTypeConsul, Service: "reset"}.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-discovery.ready
+	time.Sleep(350 * time.Millisecond)
+	stopDiscoveries(nil)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(indexes) < 2 || len(indexes) > 6 {
+		t.Errorf("expected the queries to be delayed, got %d queries", len(indexes))
+	}
+	if len(indexes) == 0 {
+		t.Fatal("expected Consul queries")
+	}
+	if indexes[len(indexes)-1] != "1" {
+		t.Errorf("expected the zero index to be sent as 1, got %v", indexes)
+	}
+}
@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	ConvertFromNginx   = "nginx"
+	ConvertFromTraefik = "traefik"
+)
+
+// ConvertConfig converts an nginx configuration or a Traefik dynamic configuration, YAML or JSON, to a goma configuration.
+//
+// It returns warnings for the settings that could not be translated
+func ConvertConfig(from string, buf []byte) (*GatewayConfig, []string, error) {
+	c := &converter{
+		config: &GatewayConfig{GatewayConfig: Gateway{
+			ListenAddr:   "0.0.0.0:80",
+			WriteTimeout: 15,
+			ReadTimeout:  15,
+			IdleTimeout:  60,
+		}},
+		names: map[string]bool{},
+	}
+	var err error
+	switch from {
+	case ConvertFromNginx:
+		err = c.nginx(buf)
+	case ConvertFromTraefik:
+		err = c.traefik(buf)
+	default:
+		return nil, nil, fmt.Errorf("unknown configuration format %q, expected %s or %s", from, ConvertFromNginx, ConvertFromTraefik)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	c.removeConflicts()
+	return c.config, c.warnings, nil
+}
+
+// converter builds a goma configuration and collects the conversion warnings
+type converter struct {
+	config   *GatewayConfig
+	warnings []string
+	// names contains the route names in use
+	names map[string]bool
+	// rateLimit is the lowest converted rate limit, in requests per minute
+	rateLimit int
+}
+
+// warnf adds a conversion warning, duplicated warnings are ignored
+func (c *converter) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	for _, w := range c.warnings {
+		if w == warning {
+			return
+		}
+	}
+	c.warnings = append(c.warnings, warning)
+}
+
+// addRoute adds a route, a number is appended to duplicated names
+func (c *converter) addRoute(route Route) {
+	name := route.Name
+	for i := 2; c.names[name]; i++ {
+		name = fmt.Sprintf("%s-%d", route.Name, i)
+	}
+	route.Name = name
+	c.names[name] = true
+	c.config.GatewayConfig.Routes = append(c.config.GatewayConfig.Routes, route)
+}
+
+// addMiddleware adds a middleware and returns its name.
+//
+// Middlewares with the same type and rule are added once, a number is appended to duplicated names
+func (c *converter) addMiddleware(m Middleware) string {
+	name := m.Name
+	for i := 2; ; i++ {
+		found := false
+		for _, existing := range c.config.Middlewares {
+			if existing.Name != name {
+				continue
+			}
+			if existing.Type == m.Type && reflect.DeepEqual(existing.Rule, m.Rule) {
+				return name
+			}
+			found = true
+		}
+		if !found {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", m.Name, i)
+	}
+	m.Name = name
+	c.config.Middlewares = append(c.config.Middlewares, m)
+	return name
+}
+
+// denyRoute replaces a route by a mock route returning 403, e.g. the route access control could not be translated
+// and the route must not be public
+func (c *converter) denyRoute(route *Route, reason string) {
+	c.warnf("%s, the route returns 403", reason)
+	*route = Route{Name: route.Name, Path: route.Path, Hosts: route.Hosts, Type: RouteTypeMock,
+		Mock: Mock{Responses: []MockResponse{{Status: http.StatusForbidden, Body: "Forbidden"}}}}
+}
+
+// setRateLimit sets the gateway rate limit, the lowest limit is kept
+func (c *converter) setRateLimit(requestsPerMinute int, source string) {
+	if requestsPerMinute <= 0 {
+		return
+	}
+	c.warnf("%s: goma rate limiting is global, per client IP, gateway.rateLimiter is set to the lowest limit", source)
+	if c.rateLimit == 0 || requestsPerMinute < c.rateLimit {
+		c.rateLimit = requestsPerMinute
+		c.config.GatewayConfig.RateLimiter = requestsPerMinute
+	}
+}
+
+// removeConflicts removes the routes matching the same path and hosts as a previous route,
+// redirect routes are removed first, e.g. nginx HTTP to HTTPS redirects, goma serves the same routes on HTTP and HTTPS
+func (c *converter) removeConflicts() {
+	routes := c.config.GatewayConfig.Routes
+	key := func(route Route) string {
+		hosts := append([]string(nil), route.Hosts...)
+		sort.Strings(hosts)
+		return strings.Join(hosts, ",") + " " + route.Path
+	}
+	kept := map[string]int{}
+	var result []Route
+	for _, route := range routes {
+		i, ok := kept[key(route)]
+		if !ok {
+			kept[key(route)] = len(result)
+			result = append(result, route)
+			continue
+		}
+		if result[i].Type == RouteTypeRedirect && route.Type != RouteTypeRedirect {
+			c.warnf("route %s: redirect removed, it matches the same path and hosts as route %s, goma serves the routes on HTTP and HTTPS",
+				result[i].Name, route.Name)
+			// The redirect name is kept, it's the first one
+			route.Name = result[i].Name
+			result[i] = route
+			continue
+		}
+		c.warnf("route %s: removed, it matches the same path and hosts as route %s", route.Name, result[i].Name)
+	}
+	c.config.GatewayConfig.Routes = result
+}
+
+// routeName returns a route name from names, e.g. hosts and paths, other characters than letters, digits and dots are replaced by dashes
+func routeName(parts ...string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		for _, r := range part {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.':
+				b.WriteRune(r)
+			default:
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune('-')
+	}
+	name := strings.Trim(b.String(), "-")
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	if name == "" {
+		return "default"
+	}
+	return name
+}
@@ -0,0 +1,279 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const nginxTestConfig = `
+events { worker_connections 1024; }
+http {
+  limit_req_zone $binary_remote_addr zone=api:10m rate=10r/s;
+  upstream store {
+    server 10.0.0.1:8080 weight=3;
+    server 10.0.0.2:8080;
+  }
+  server {
+    listen 80;
+    server_name example.com;
+    return 301 https://$host$request_uri;
+  }
+  server {
+    listen 443 ssl;
+    server_name example.com;
+    ssl_certificate /etc/ssl/example.crt;
+    ssl_certificate_key /etc/ssl/example.key;
+    location / {
+      root /var/www/html;
+      try_files $uri $uri/ /index.html;
+    }
+    location /api/ {
+      proxy_pass http://store/v1/;
+      proxy_set_header Host $host;
+      limit_req zone=api;
+      allow 10.0.0.0/8;
+      deny all;
+    }
+    location /admin {
+      auth_basic "Admin";
+      auth_basic_user_file /etc/nginx/.htpasswd;
+      rewrite ^/admin/old/(.*)$ /admin/new/$1 break;
+      proxy_pass http://admin:9000;
+    }
+    location = /health { return 200 "ok"; }
+    location ~ \.php$ { fastcgi_pass 127.0.0.1:9000; }
+    location /ws { proxy_pass http://$backend; }
+  }
+}
+`
+
+const traefikTestConfig = `
+http:
+  routers:
+    store:
+      rule: "Host(` + "`store.example.com`" + `) && PathPrefix(` + "`/api`" + `)"
+      service: store
+      middlewares: [secured, strip-api]
+    web:
+      rule: "Host(` + "`example.com`" + `) || Host(` + "`www.example.com`" + `)"
+      service: web@file
+      middlewares: [https]
+    dashboard:
+      rule: "Host(` + "`traefik.example.com`" + `)"
+      service: api@internal
+  services:
+    store:
+      weighted:
+        services:
+          - name: store-v1
+            weight: 3
+          - name: store-v2
+            weight: 1
+    store-v1:
+      loadBalancer:
+        servers:
+          - url: http://10.0.0.1:8080
+    store-v2:
+      loadBalancer:
+        servers:
+          - url: http://10.0.1.1:8080
+    web:
+      loadBalancer:
+        servers:
+          - url: http://web:80
+  middlewares:
+    secured:
+      chain:
+        middlewares: [auth, office, headers]
+    auth:
+      basicAuth:
+        usersFile: /etc/traefik/users
+    office:
+      ipAllowList:
+        sourceRange: [192.168.1.0/24]
+    headers:
+      headers:
+        customRequestHeaders:
+          X-Env: prod
+    strip-api:
+      stripPrefix:
+        prefixes: [/api]
+    https:
+      redirectScheme:
+        scheme: https
+        permanent: true
+`
+
+func TestConvertNginx(t *testing.T) {
+	c, warnings, err := ConvertConfig(ConvertFromNginx, []byte(nginxTestConfig))
+	if err != nil {
+		t.Fatalf("Error converting config: %v", err)
+	}
+	gateway := c.GatewayConfig
+	if gateway.TLS.ListenAddr != "0.0.0.0:443" || gateway.TLS.CertFile != "/etc/ssl/example.crt" || gateway.RateLimiter != 600 {
+		t.Errorf("unexpected gateway settings: %+v", gateway)
+	}
+	routes := map[string]Route{}
+	for _, route := range gateway.Routes {
+		routes[route.Name] = route
+	}
+	if len(routes) != 4 {
+		t.Fatalf("expected 4 routes, got %v", gateway.Routes)
+	}
+	// The HTTP to HTTPS redirect has the same path and host as the static route
+	if route := routes["example.com"]; route.Type != RouteTypeStatic || route.Static.Dir != "/var/www/html" || !route.Static.SPA {
+		t.Errorf("unexpected static route: %+v", route)
+	}
+	api := routes["example.com-api"]
+	if api.Rewrite != "/v1/" || fmt.Sprint(api.Backends) != fmt.Sprint([]Backend{
+		{Name: "10.0.0.1:8080", Destination: "http://10.0.0.1:8080", Weight: 3},
+		{Name: "10.0.0.2:8080", Destination: "http://10.0.0.2:8080", Weight: 1},
+	}) {
+		t.Errorf("unexpected api route: %+v", api)
+	}
+	admin := routes["example.com-admin"]
+	if admin.Destination != "http://admin:9000" || len(admin.RewriteRules) != 1 || admin.RewriteRules[0].Replacement != "/admin/new/$1" {
+		t.Errorf("unexpected admin route: %+v", admin)
+	}
+	if health := routes["example.com-health"]; health.Type != RouteTypeMock || health.Mock.Responses[0].Body != "ok" {
+		t.Errorf("unexpected health route: %+v", health)
+	}
+	if len(c.Middlewares) != 2 || c.Middlewares[0].Type != "access" || c.Middlewares[1].Rule.(BasicRule).HtpasswdFile != "/etc/nginx/.htpasswd" {
+		t.Errorf("unexpected middlewares: %+v", c.Middlewares)
+	}
+	if fmt.Sprint(api.Middlewares) != "[{/ [access] }]" || fmt.Sprint(admin.Middlewares) != "[{/ [basic-auth] }]" {
+		t.Errorf("unexpected route middlewares: %v, %v", api.Middlewares, admin.Middlewares)
+	}
+	for _, expected := range []string{"exact match", "regular expressions", "proxy_pass http://$backend", "redirect removed"} {
+		if !strings.Contains(strings.Join(warnings, "\n"), expected) {
+			t.Errorf("expected a warning containing %q, got %v", expected, warnings)
+		}
+	}
+
+	if _, _, err := ConvertConfig(ConvertFromNginx, []byte("server { listen 80 }")); err == nil {
+		t.Error("expected a parsing error")
+	}
+	c, warnings, err = ConvertConfig(ConvertFromNginx, []byte("server { location { proxy_pass http://app; } }"))
+	if err != nil || len(c.GatewayConfig.Routes) != 0 || len(warnings) != 1 {
+		t.Errorf("expected the empty location to be skipped, got %v, %v, %v", err, c, warnings)
+	}
+}
+
+func TestConvertTraefik(t *testing.T) {
+	c, warnings, err := ConvertConfig(ConvertFromTraefik, []byte(traefikTestConfig))
+	if err != nil {
+		t.Fatalf("Error converting config: %v", err)
+	}
+	routes := c.GatewayConfig.Routes
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %v", routes)
+	}
+	store := routes[0]
+	if store.Path != "/api" || fmt.Sprint(store.Hosts) != "[store.example.com]" || store.Rewrite != "/" {
+		t.Errorf("unexpected store route: %+v", store)
+	}
+	if fmt.Sprint(store.Backends) != fmt.Sprint([]Backend{
+		{Name: "10.0.0.1:8080", Destination: "http://10.0.0.1:8080", Weight: 3},
+		{Name: "10.0.1.1:8080", Destination: "http://10.0.1.1:8080", Weight: 1},
+	}) {
+		t.Errorf("unexpected store backends: %v", store.Backends)
+	}
+	if fmt.Sprint(store.Middlewares) != "[{/ [auth office] }]" || len(c.Middlewares) != 2 {
+		t.Errorf("unexpected middlewares: %v, %+v", store.Middlewares, c.Middlewares)
+	}
+	if web := routes[1]; web.Type != RouteTypeRedirect || !web.Redirect.HTTPS || web.Redirect.Code != 301 || len(web.Hosts) != 2 {
+		t.Errorf("unexpected web route: %+v", web)
+	}
+	for _, expected := range []string{"middleware headers: headers is not translated", "api@internal"} {
+		if !strings.Contains(strings.Join(warnings, "\n"), expected) {
+			t.Errorf("expected a warning containing %q, got %v", expected, warnings)
+		}
+	}
+}
+
+func TestConvertDeniedRoutes(t *testing.T) {
+	nginx := `
+server {
+  server_name example.com;
+  location /internal { deny all; proxy_pass http://internal; }
+  location /admin { auth_basic "Admin"; proxy_pass http://admin; }
+  location /public { proxy_pass http://public; }
+}
+`
+	traefik := `
+http:
+  routers:
+    docker:
+      rule: "PathPrefix(` + "`/docker`" + `)"
+      service: app
+      middlewares: [auth@docker]
+    digest:
+      rule: "PathPrefix(` + "`/digest`" + `)"
+      service: app
+      middlewares: [digest]
+    missing:
+      rule: "PathPrefix(` + "`/missing`" + `)"
+      service: app
+      middlewares: [missing]
+    public:
+      rule: "PathPrefix(` + "`/public`" + `)"
+      service: app
+      middlewares: [headers]
+  services:
+    app:
+      loadBalancer:
+        servers:
+          - url: http://app:8080
+  middlewares:
+    digest:
+      digestAuth:
+        users: ["test:traefik:a2688e031edb4be6a3797f3882655c05"]
+    headers:
+      headers:
+        customRequestHeaders:
+          X-Env: prod
+`
+	routes := map[string]int{ConvertFromNginx: 3, ConvertFromTraefik: 4}
+	for from, buf := range map[string]string{ConvertFromNginx: nginx, ConvertFromTraefik: traefik} {
+		c, _, err := ConvertConfig(from, []byte(buf))
+		if err != nil {
+			t.Fatalf("%s: error converting config: %v", from, err)
+		}
+		if len(c.GatewayConfig.Routes) != routes[from] {
+			t.Errorf("%s: expected %d routes, got %v", from, routes[from], c.GatewayConfig.Routes)
+		}
+		for _, route := range c.GatewayConfig.Routes {
+			denied := route.Type == RouteTypeMock && route.Mock.Responses[0].Status == 403
+			if denied == strings.HasPrefix(route.Path, "/public") {
+				t.Errorf("%s: unexpected route %s: %+v", from, route.Path, route)
+			}
+		}
+	}
+}
+
+func TestParseTraefikRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		hosts   string
+		path    string
+		wantErr bool
+	}{
+		{rule: "Host(`a.example.com`, `b.example.com`)", hosts: "[a.example.com b.example.com]", path: "/"},
+		{rule: "(Host(`a.example.com`) || Host(`b.example.com`)) && PathPrefix(`/api`)", wantErr: true},
+		{rule: "PathPrefix(`/api`) && Host(`a.example.com`)", hosts: "[a.example.com]", path: "/api"},
+		{rule: "Path(`/login`)", hosts: "[]", path: "/login"},
+		{rule: "Host(`a.example.com`) && !PathPrefix(`/admin`)", wantErr: true},
+		{rule: "Method(`GET`)", wantErr: true},
+	}
+	for _, tt := range tests {
+		hosts, path, _, err := parseTraefikRule(tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.rule, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && (fmt.Sprint(hosts) != tt.hosts || path != tt.path) {
+			t.Errorf("%s: unexpected hosts %v and path %s", tt.rule, hosts, path)
+		}
+	}
+}
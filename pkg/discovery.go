@@ -0,0 +1,309 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDNSRefreshInterval  = 30 * time.Second
+	defaultFileRefreshInterval = 5 * time.Second
+	defaultConsulRetryInterval = 5 * time.Second
+)
+
+// backendDiscoveries caches the running discoveries by settings, they are shared by routes and kept on reload
+// while a route uses them
+var (
+	backendDiscoveries   = map[Discovery]*backendDiscovery{}
+	backendDiscoveriesMu sync.Mutex
+)
+
+// backendDiscovery refreshes the backends of a discovery in the background
+type backendDiscovery struct {
+	discovery Discovery
+	backends  atomic.Pointer[[]Backend]
+	// resolver resolves the DNS names, it's replaced in tests
+	resolver interface {
+		LookupHost(ctx context.Context, host string) ([]string, error)
+		LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	}
+	// ready is closed after the first resolution, successful or not
+	ready chan struct{}
+	// consulIndex is the index of the last Consul blocking query
+	consulIndex uint64
+	// consulMinInterval is the minimum interval between Consul queries returning an unchanged or reset index
+	consulMinInterval time.Duration
+	// ctx is canceled when no route uses the discovery anymore
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// start returns the running discovery of the settings, it's started on the first call
+func (d Discovery) start() (*backendDiscovery, error) {
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	backendDiscoveriesMu.Lock()
+	defer backendDiscoveriesMu.Unlock()
+	if discovery, ok := backendDiscoveries[d]; ok {
+		return discovery, nil
+	}
+	discovery := &backendDiscovery{discovery: d, resolver: net.DefaultResolver, ready: make(chan struct{}), consulMinInterval: consulMinInterval}
+	discovery.ctx, discovery.cancel = context.WithCancel(context.Background())
+	backendDiscoveries[d] = discovery
+	go discovery.run()
+	return discovery, nil
+}
+
+// discovered returns the current backends of the discovery, nil when it's not started
+func (d Discovery) discovered() []Backend {
+	backendDiscoveriesMu.Lock()
+	discovery, ok := backendDiscoveries[d]
+	backendDiscoveriesMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return discovery.current()
+}
+
+func (d Discovery) validate() error {
+	switch d.Type {
+	case DiscoveryTypeDNS:
+		if d.Name == "" || d.Port == 0 {
+			return fmt.Errorf("dns discovery requires a name and a port")
+		}
+	case DiscoveryTypeSRV:
+		if d.Name == "" {
+			return fmt.Errorf("srv discovery requires a name")
+		}
+	case DiscoveryTypeFile:
+		if d.File == "" {
+			return fmt.Errorf("file discovery requires a file")
+		}
+	case DiscoveryTypeConsul:
+		if d.Service == "" {
+			return fmt.Errorf("consul discovery requires a service")
+		}
+	default:
+		return fmt.Errorf("unknown discovery type %q", d.Type)
+	}
+	return nil
+}
+
+// refreshInterval returns the discovery refresh interval
+func (d Discovery) refreshInterval() time.Duration {
+	if d.RefreshInterval > 0 {
+		return time.Duration(d.RefreshInterval) * time.Second
+	}
+	switch d.Type {
+	case DiscoveryTypeFile:
+		return defaultFileRefreshInterval
+	case DiscoveryTypeConsul:
+		return defaultConsulRetryInterval
+	}
+	return defaultDNSRefreshInterval
+}
+
+// stopDiscoveries stops the discoveries the routes don't use, e.g. after a reload
+func stopDiscoveries(routes []Route) {
+	used := map[Discovery]bool{}
+	for _, route := range routes {
+		used[route.Discovery] = true
+	}
+	backendDiscoveriesMu.Lock()
+	defer backendDiscoveriesMu.Unlock()
+	for d, discovery := range backendDiscoveries {
+		if !used[d] {
+			discovery.cancel()
+			delete(backendDiscoveries, d)
+		}
+	}
+}
+
+// current returns the last resolved backends
+func (b *backendDiscovery) current() []Backend {
+	if backends := b.backends.Load(); backends != nil {
+		return *backends
+	}
+	return nil
+}
+
+// run resolves the backends on each refresh interval, the last backends are kept on error.
+//
+// Consul queries block until the service changes, they are sent again immediately.
+// It returns when the discovery is stopped
+func (b *backendDiscovery) run() {
+	first := true
+	defer func() {
+		if first {
+			close(b.ready)
+		}
+	}()
+	for {
+		index := b.consulIndex
+		backends, err := b.resolve(b.ctx)
+		if b.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("%s discovery %s: %v", b.discovery.Type, b.discovery.target(), err)
+		} else if !reflect.DeepEqual(backends, b.current()) {
+			logger.Info("%s discovery %s: %d backends", b.discovery.Type, b.discovery.target(), len(backends))
+			b.backends.Store(&backends)
+		}
+		if first {
+			close(b.ready)
+			first = false
+		}
+		interval := b.discovery.refreshInterval()
+		if err == nil && b.discovery.Type == DiscoveryTypeConsul {
+			// Blocking queries are sent again immediately, unless Consul returned an unchanged or reset index
+			interval = 0
+			if b.consulIndex == index || b.consulIndex == 1 {
+				interval = b.consulMinInterval
+			}
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// target returns the discovered name, file or service, used in logs
+func (d Discovery) target() string {
+	switch d.Type {
+	case DiscoveryTypeFile:
+		return d.File
+	case DiscoveryTypeConsul:
+		return d.Service
+	}
+	return d.Name
+}
+
+// resolve returns the backends of the discovery, in a stable order
+func (b *backendDiscovery) resolve(ctx context.Context) ([]Backend, error) {
+	d := b.discovery
+	scheme := d.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	switch d.Type {
+	case DiscoveryTypeDNS:
+		addresses, err := b.resolver.LookupHost(ctx, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(addresses)
+		backends := make([]Backend, 0, len(addresses))
+		for _, address := range addresses {
+			host := net.JoinHostPort(address, strconv.Itoa(d.Port))
+			backends = append(backends, Backend{Name: host, Destination: scheme + "://" + host, Weight: 1})
+		}
+		return backends, nil
+	case DiscoveryTypeSRV:
+		_, records, err := b.resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		return srvBackends(records, scheme), nil
+	case DiscoveryTypeConsul:
+		return b.consulBackends(ctx, scheme)
+	default:
+		buf, err := os.ReadFile(d.File)
+		if err != nil {
+			return nil, err
+		}
+		var backends []Backend
+		// JSON is valid YAML
+		if err := yaml.Unmarshal(buf, &backends); err != nil {
+			return nil, fmt.Errorf("error decoding targets: %w", err)
+		}
+		for i := range backends {
+			if backends[i].Destination == "" {
+				return nil, fmt.Errorf("target %d: destination is required", i+1)
+			}
+			if backends[i].Name == "" {
+				backends[i].Name = backendKey(backends[i].Destination)
+			}
+			if backends[i].Weight == 0 {
+				backends[i].Weight = 1
+			}
+		}
+		return backends, nil
+	}
+}
+
+// srvBackends returns the targets of the lowest priority, the other priorities are only used by clients as fallback.
+//
+// Zero weights are chosen only when all the targets have a zero weight
+func srvBackends(records []*net.SRV, scheme string) []Backend {
+	if len(records) == 0 {
+		return nil
+	}
+	priority := records[0].Priority
+	for _, record := range records {
+		priority = min(priority, record.Priority)
+	}
+	totalWeight := 0
+	var backends []Backend
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+		host := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		backends = append(backends, Backend{Name: host, Destination: scheme + "://" + host, Weight: int(record.Weight)})
+		totalWeight += int(record.Weight)
+	}
+	if totalWeight == 0 {
+		for i := range backends {
+			backends[i].Weight = 1
+		}
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Destination < backends[j].Destination })
+	return backends
+}
+
+// discoveredSplitter balances the requests between the discovered backends, the splitter is created again when they change
+type discoveredSplitter struct {
+	discovery *backendDiscovery
+	path      string
+	sticky    Sticky
+	mu        sync.Mutex
+	backends  *[]Backend
+	splitter  *TrafficSplitter
+}
+
+// get returns the splitter of the current backends, nil when no backend is discovered
+func (s *discoveredSplitter) get() *TrafficSplitter {
+	backends := s.discovery.backends.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if backends == s.backends {
+		return s.splitter
+	}
+	s.backends = backends
+	s.splitter = nil
+	if backends == nil || len(*backends) == 0 {
+		return nil
+	}
+	splitter, err := NewTrafficSplitter(s.path, *backends, s.sticky)
+	if err != nil {
+		logger.Error("Error creating route %s discovered backends: %v", s.path, err)
+		return nil
+	}
+	s.splitter = splitter
+	return splitter
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (f fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addresses, ok := f.hosts[host]; ok {
+		return addresses, nil
+	}
+	return nil, fmt.Errorf("lookup %s: no such host", host)
+}
+
+func (f fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if records, ok := f.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, fmt.Errorf("lookup %s: no such host", name)
+}
+
+func TestDiscoveryResolve(t *testing.T) {
+	resolver := fakeResolver{
+		hosts: map[string][]string{"users.internal": {"10.0.0.2", "10.0.0.1", "fd00::1"}},
+		srv: map[string][]*net.SRV{
+			"_http._tcp.orders.internal": {
+				{Target: "orders-1.internal.", Port: 8080, Priority: 10, Weight: 60},
+				{Target: "orders-2.internal.", Port: 8080, Priority: 10, Weight: 40},
+				{Target: "orders-backup.internal.", Port: 8080, Priority: 20, Weight: 100},
+			},
+			"_http._tcp.zero.internal": {
+				{Target: "zero-1.internal.", Port: 80, Priority: 1},
+				{Target: "zero-2.internal.", Port: 80, Priority: 1},
+			},
+		},
+	}
+	tests := []struct {
+		name      string
+		discovery Discovery
+		want      []Backend
+		wantErr   bool
+	}{
+		{name: "dns", discovery: Discovery{Type: DiscoveryTypeDNS, Name: "users.internal", Port: 8080}, want: []Backend{
+			{Name: "10.0.0.1:8080", Destination: "http://10.0.0.1:8080", Weight: 1},
+			{Name: "10.0.0.2:8080", Destination: "http://10.0.0.2:8080", Weight: 1},
+			{Name: "[fd00::1]:8080", Destination: "http://[fd00::1]:8080", Weight: 1},
+		}},
+		{name: "srv priority and weights", discovery: Discovery{Type: DiscoveryTypeSRV, Name: "_http._tcp.orders.internal", Scheme: "https"}, want: []Backend{
+			{Name: "orders-1.internal:8080", Destination: "https://orders-1.internal:8080", Weight: 60},
+			{Name: "orders-2.internal:8080", Destination: "https://orders-2.internal:8080", Weight: 40},
+		}},
+		{name: "srv zero weights", discovery: Discovery{Type: DiscoveryTypeSRV, Name: "_http._tcp.zero.internal"}, want: []Backend{
+			{Name: "zero-1.internal:80", Destination: "http://zero-1.internal:80", Weight: 1},
+			{Name: "zero-2.internal:80", Destination: "http://zero-2.internal:80", Weight: 1},
+		}},
+		{name: "unknown name", discovery: Discovery{Type: DiscoveryTypeDNS, Name: "missing.internal", Port: 80}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery := &backendDiscovery{discovery: tt.discovery, resolver: resolver}
+			got, err := discovery.resolve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected backends %v, got %v", tt.want, got)
+			}
+		})
+	}
+	if err := (Discovery{Type: DiscoveryTypeDNS, Name: "users.internal"}).validate(); err == nil {
+		t.Error("expected an error without port")
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	blue, green := newBackend("blue"), newBackend("green")
+	defer blue.Close()
+	defer green.Close()
+	targets := filepath.Join(t.TempDir(), "targets.yml")
+	writeConfigFile(t, targets, fmt.Sprintf("- destination: %s\n", blue.URL))
+
+	proxyRoute := ProxyRoute{path: "/", discovery: Discovery{Type: DiscoveryTypeFile, File: targets, RefreshInterval: 1}}
+	handler := proxyRoute.ProxyHandler()
+	request := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Body.String()
+	}
+	if body := request(); body != "blue" {
+		t.Fatalf("expected the file backend, got %q", body)
+	}
+
+	// The targets file is watched
+	writeConfigFile(t, targets, fmt.Sprintf(`[{"destination": %q, "weight": 1}]`, green.URL))
+	deadline := time.Now().Add(5 * time.Second)
+	for request() != "green" {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the updated targets")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if backends := proxyRoute.discovery.discovered(); len(backends) != 1 || backends[0].Destination != green.URL {
+		t.Errorf("expected the discovered backend, got %v", backends)
+	}
+
+	// The last backends are kept when the file is invalid
+	writeConfigFile(t, targets, "- weight: 1\n")
+	time.Sleep(1500 * time.Millisecond)
+	if body := request(); body != "green" {
+		t.Errorf("expected the last backends, got %q", body)
+	}
+	// Discoveries no route uses are stopped
+	discovery, err := proxyRoute.discovery.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopDiscoveries([]Route{{Name: "other", Path: "/other"}})
+	if discovery.ctx.Err() == nil || proxyRoute.discovery.discovered() != nil {
+		t.Error("expected the unused discovery to be stopped")
+	}
+}
@@ -0,0 +1,309 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dockerLabelPrefix is the prefix of the container labels read by goma
+	dockerLabelPrefix     = "goma."
+	defaultDockerEndpoint = "unix:///var/run/docker.sock"
+	dockerRetryInterval   = 5 * time.Second
+	dockerComposeService  = "com.docker.compose.service"
+)
+
+// dockerProvider lists the running containers and watches the container events of the Docker Engine API
+type dockerProvider struct {
+	config DockerProvider
+	// baseURL is the Docker Engine API URL, the host is ignored for unix sockets
+	baseURL string
+	client  *http.Client
+	// debounce groups the events of a short period in one update
+	debounce time.Duration
+}
+
+// NewDockerProvider creates the Docker provider, the local Docker socket is used by default
+func NewDockerProvider(config DockerProvider) Provider {
+	if config.Endpoint == "" {
+		config.Endpoint = defaultDockerEndpoint
+	}
+	p := &dockerProvider{config: config, client: &http.Client{}, debounce: time.Second}
+	switch {
+	case strings.HasPrefix(config.Endpoint, "unix://"):
+		socket := strings.TrimPrefix(config.Endpoint, "unix://")
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		p.client.Transport = transport
+		p.baseURL = "http://docker"
+	case strings.HasPrefix(config.Endpoint, "tcp://"):
+		p.baseURL = "http://" + strings.TrimPrefix(config.Endpoint, "tcp://")
+	default:
+		p.baseURL = strings.TrimSuffix(config.Endpoint, "/")
+	}
+	return p
+}
+
+func (p *dockerProvider) Name() string {
+	return "docker"
+}
+
+// Provide subscribes to the container events, lists the containers, sends the routes and waits for an event.
+//
+// Events are subscribed before listing the containers, a container started in the meantime triggers a new list
+func (p *dockerProvider) Provide(ctx context.Context, configs chan<- ProviderConfig) {
+	var previous *ProviderConfig
+	for ctx.Err() == nil {
+		if !p.provide(ctx, configs, &previous) {
+			p.sleep(ctx, dockerRetryInterval)
+			continue
+		}
+		p.sleep(ctx, p.debounce)
+	}
+}
+
+// provide sends the routes when they changed and returns on the next event, it reports whether the events were received
+func (p *dockerProvider) provide(ctx context.Context, configs chan<- ProviderConfig, previous **ProviderConfig) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die", "destroy", "pause", "unpause"},
+	})
+	events, err := p.get(ctx, "/events", url.Values{"filters": {string(filters)}})
+	if err != nil {
+		logger.Error("Docker provider: error watching events: %v", err)
+		return false
+	}
+	defer events.Body.Close()
+	containers, err := p.containers(ctx)
+	if err != nil {
+		logger.Error("Docker provider: %v", err)
+		return false
+	}
+	config := ProviderConfig{Provider: p.Name(), Routes: dockerRoutes(containers, p.config.Network)}
+	if *previous == nil || !reflect.DeepEqual(config, **previous) {
+		select {
+		case configs <- config:
+			*previous = &config
+		case <-ctx.Done():
+			return true
+		}
+	}
+	if bufio.NewScanner(events.Body).Scan() {
+		return true
+	}
+	if ctx.Err() == nil {
+		logger.Error("Docker provider: events stream closed")
+	}
+	return false
+}
+
+func (p *dockerProvider) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// get sends a request to the Docker Engine API
+func (p *dockerProvider) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status code %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// containers returns the running containers
+func (p *dockerProvider) containers(ctx context.Context) ([]dockerContainer, error) {
+	resp, err := p.get(ctx, "/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("error decoding containers: %w", err)
+	}
+	return containers, nil
+}
+
+// dockerContainer is a container of the Docker Engine API list, only the fields used by the provider are decoded
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+	Ports  []struct {
+		PrivatePort int    `json:"PrivatePort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// name returns the container name without the leading slash
+func (c dockerContainer) name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// label returns a goma label value
+func (c dockerContainer) label(name string) string {
+	return strings.TrimSpace(c.Labels[dockerLabelPrefix+name])
+}
+
+// enabled reports whether the container has goma labels and is not disabled with goma.enable=false
+func (c dockerContainer) enabled() bool {
+	if enable, err := strconv.ParseBool(c.label("enable")); err == nil {
+		return enable
+	}
+	for label := range c.Labels {
+		if strings.HasPrefix(label, dockerLabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeName returns the goma.name label, the compose service or the container name.
+//
+// Containers with the same route name, e.g. the replicas of a compose service, are backends of the same route
+func (c dockerContainer) routeName() string {
+	if name := c.label("name"); name != "" {
+		return name
+	}
+	if service := c.Labels[dockerComposeService]; service != "" {
+		return service
+	}
+	return c.name()
+}
+
+// address returns the container address on the network, default is the first network with an address
+func (c dockerContainer) address(network string) string {
+	networks := make([]string, 0, len(c.NetworkSettings.Networks))
+	for name := range c.NetworkSettings.Networks {
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	if network != "" {
+		networks = []string{network}
+	}
+	for _, name := range networks {
+		settings := c.NetworkSettings.Networks[name]
+		if settings.IPAddress != "" {
+			return settings.IPAddress
+		}
+		if settings.GlobalIPv6Address != "" {
+			return settings.GlobalIPv6Address
+		}
+	}
+	return ""
+}
+
+// port returns the goma.port label, the only exposed TCP port or 80
+func (c dockerContainer) port() string {
+	if port := c.label("port"); port != "" {
+		return port
+	}
+	ports := map[int]bool{}
+	for _, port := range c.Ports {
+		if port.Type == "tcp" {
+			ports[port.PrivatePort] = true
+		}
+	}
+	if len(ports) == 1 {
+		for port := range ports {
+			return strconv.Itoa(port)
+		}
+	}
+	return "80"
+}
+
+// dockerRoutes translates the labels of the running containers to routes.
+//
+// The route settings are read from the first container of the route, in container name order
+func dockerRoutes(containers []dockerContainer, network string) []Route {
+	sort.Slice(containers, func(i, j int) bool { return containers[i].name() < containers[j].name() })
+	routes := map[string]*Route{}
+	var names []string
+	for _, container := range containers {
+		if container.State != "running" || !container.enabled() {
+			continue
+		}
+		containerNetwork := container.label("network")
+		if containerNetwork == "" {
+			containerNetwork = network
+		}
+		address := container.address(containerNetwork)
+		if address == "" {
+			logger.Error("Docker provider: container %s has no address on network %q", container.name(), containerNetwork)
+			continue
+		}
+		name := container.routeName()
+		route, ok := routes[name]
+		if !ok {
+			route = &Route{
+				Name:        name,
+				Path:        container.label("path"),
+				Hosts:       splitList(container.label("hosts")),
+				Rewrite:     container.label("rewrite"),
+				HealthCheck: container.label("health-check"),
+				Blocklist:   splitList(container.label("blocklist")),
+			}
+			if route.Path == "" {
+				route.Path = "/"
+			}
+			if middlewares := splitList(container.label("middlewares")); len(middlewares) != 0 {
+				route.Middlewares = []RouteMiddleware{{Path: "/", Rules: middlewares, Mode: container.label("middlewares-mode")}}
+			}
+			routes[name] = route
+			names = append(names, name)
+		}
+		scheme := container.label("scheme")
+		if scheme == "" {
+			scheme = "http"
+		}
+		route.Backends = append(route.Backends, Backend{
+			Name:        container.name(),
+			Destination: fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(address, container.port())),
+			Weight:      1,
+		})
+	}
+	sort.Strings(names)
+	result := make([]Route, 0, len(names))
+	for _, name := range names {
+		result = append(result, *routes[name])
+	}
+	sortRoutes(result)
+	return result
+}
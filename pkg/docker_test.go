@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker is a Docker Engine API stand-in serving the containers list and the events stream
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []map[string]interface{}
+	events     chan string
+}
+
+func (f *fakeDocker) set(containers ...map[string]interface{}) {
+	f.mu.Lock()
+	f.containers = containers
+	f.mu.Unlock()
+	f.events <- `{"Type":"container","Action":"start"}`
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/containers/json":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(f.containers)
+	case "/events":
+		if !strings.Contains(r.URL.Query().Get("filters"), `"container"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case event := <-f.events:
+			_, _ = w.Write([]byte(event + "\n"))
+		case <-r.Context().Done():
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testContainer(name, address string, labels map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":     name + "-id",
+		"Names":  []string{"/" + name},
+		"Labels": labels,
+		"State":  "running",
+		"Ports":  []map[string]interface{}{{"PrivatePort": 8080, "Type": "tcp"}},
+		"NetworkSettings": map[string]interface{}{
+			"Networks": map[string]interface{}{"compose_default": map[string]string{"IPAddress": address}},
+		},
+	}
+}
+
+func TestDockerRoutes(t *testing.T) {
+	var containers []dockerContainer
+	buf, _ := json.Marshal([]map[string]interface{}{
+		testContainer("shop-users-2", "172.18.0.3", map[string]string{"com.docker.compose.service": "users", "goma.path": "/users"}),
+		testContainer("shop-users-1", "172.18.0.2", map[string]string{"com.docker.compose.service": "users", "goma.path": "/users",
+			"goma.rewrite": "/", "goma.middlewares": "basic-auth", "goma.health-check": "/health", "goma.hosts": "shop.example.com"}),
+		testContainer("shop-orders-1", "172.18.0.4", map[string]string{"goma.name": "orders", "goma.path": "/orders", "goma.port": "9000"}),
+		testContainer("shop-db-1", "172.18.0.5", map[string]string{"com.docker.compose.service": "db"}),
+		testContainer("shop-admin-1", "172.18.0.6", map[string]string{"goma.enable": "false", "goma.path": "/admin"}),
+	})
+	if err := json.Unmarshal(buf, &containers); err != nil {
+		t.Fatal(err)
+	}
+	routes := dockerRoutes(containers, "")
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d: %+v", len(routes), routes)
+	}
+	users, orders := routes[0], routes[1]
+	if users.Name != "users" || users.Path != "/users" || users.Rewrite != "/" || users.HealthCheck != "/health" ||
+		strings.Join(users.Hosts, ",") != "shop.example.com" || users.Middlewares[0].Rules[0] != "basic-auth" {
+		t.Errorf("unexpected users route: %+v", users)
+	}
+	if len(users.Backends) != 2 || users.Backends[0].Destination != "http://172.18.0.2:8080" || users.Backends[1].Destination != "http://172.18.0.3:8080" {
+		t.Errorf("expected the replicas backends, got %+v", users.Backends)
+	}
+	if orders.Name != "orders" || len(orders.Backends) != 1 || orders.Backends[0].Destination != "http://172.18.0.4:9000" {
+		t.Errorf("unexpected orders route: %+v", orders)
+	}
+	if routes := dockerRoutes(containers, "other"); len(routes) != 0 {
+		t.Errorf("expected no route on another network, got %+v", routes)
+	}
+}
+
+func TestDockerProvider(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("users " + r.URL.Path))
+	}))
+	defer backend.Close()
+	address, port, _ := strings.Cut(strings.TrimPrefix(backend.URL, "http://"), ":")
+
+	fake := &fakeDocker{events: make(chan string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	provider := NewDockerProvider(DockerProvider{Endpoint: server.URL}).(*dockerProvider)
+	provider.debounce = 10 * time.Millisecond
+
+	gatewayServer := &GatewayServer{gateway: Gateway{Routes: []Route{{Name: "static", Path: "/static", Type: RouteTypeMock,
+		Mock: Mock{Responses: []MockResponse{{Status: http.StatusOK, Body: "static"}}}}}}}
+	rt := newGatewayRuntime(gatewayServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	configs := make(chan ProviderConfig)
+	go provider.Provide(ctx, configs)
+	next := func() ProviderConfig {
+		t.Helper()
+		select {
+		case config := <-configs:
+			rt.updateProvider(config)
+			return config
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the provider configuration")
+		}
+		return ProviderConfig{}
+	}
+	request := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if config := next(); len(config.Routes) != 0 {
+		t.Fatalf("expected no route, got %v", config.Routes)
+	}
+
+	// A container starts
+	fake.set(testContainer("shop-users-1", address, map[string]string{"goma.path": "/users", "goma.port": port}))
+	if config := next(); len(config.Routes) != 1 {
+		t.Fatalf("expected 1 route, got %v", config.Routes)
+	}
+	if rec := request("/users/1"); rec.Code != http.StatusOK || rec.Body.String() != "users /users/1" {
+		t.Errorf("expected the container route, got %d %s", rec.Code, rec.Body.String())
+	}
+	// Configuration file routes are kept
+	if rec := request("/static/"); rec.Body.String() != "static" {
+		t.Errorf("expected the configuration file route, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// The container stops
+	fake.set()
+	if config := next(); len(config.Routes) != 0 {
+		t.Fatalf("expected no route, got %v", config.Routes)
+	}
+	if rec := request("/users/1"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if got := len(rt.current().gateway.Routes); got != 1 {
+		t.Errorf("expected the configuration file route only, got %d routes", got)
+	}
+}
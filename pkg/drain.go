@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// drainedBackends contains the drained backend destinations, they no longer receive requests
+var drainedBackends sync.Map
+
+// backendKey normalizes a backend destination
+func backendKey(destination string) string {
+	return strings.TrimSuffix(destination, "/")
+}
+
+// DrainBackend stops sending requests to the backend destination, on all routes
+func DrainBackend(destination string) {
+	drainedBackends.Store(backendKey(destination), true)
+}
+
+// EnableBackend sends requests to a drained backend destination again
+func EnableBackend(destination string) {
+	drainedBackends.Delete(backendKey(destination))
+}
+
+// IsBackendDrained checks if the backend destination is drained
+func IsBackendDrained(destination string) bool {
+	_, drained := drainedBackends.Load(backendKey(destination))
+	return drained
+}
+
+// DrainedBackends returns the drained backend destinations
+func DrainedBackends() []string {
+	var backends []string
+	drainedBackends.Range(func(key, value any) bool {
+		backends = append(backends, key.(string))
+		return true
+	})
+	sort.Strings(backends)
+	return backends
+}
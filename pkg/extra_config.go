@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultConfigWatchInterval is the default configuration files check interval
+const defaultConfigWatchInterval = 5 * time.Second
+
+// loadConfig reads the main configuration file and the extra configuration directory.
+//
+// Routes and middlewares of the directory files are appended in file name order,
+// route and middleware names must be unique across all files.
+// Values are interpolated and the gateway fields are overridden by their environment variables.
+func loadConfig(configFile string) (*GatewayConfig, error) {
+	buf, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &GatewayConfig{}
+	if err := decodeConfig(buf, c); err != nil {
+		return nil, fmt.Errorf("in file %q: %w", configFile, err)
+	}
+	if err := applyEnvOverrides(&c.GatewayConfig); err != nil {
+		return nil, err
+	}
+	// Trusted proxies are a security setting, a partial list must not be used
+	if _, err := middleware.ParseCIDRs(c.GatewayConfig.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("in file %q: trustedProxies: %w", configFile, err)
+	}
+	routeFiles := map[string]string{}
+	middlewareFiles := map[string]string{}
+	if err := checkDuplicates(configFile, c.GatewayConfig.Routes, c.Middlewares, routeFiles, middlewareFiles); err != nil {
+		return nil, err
+	}
+	files, err := extraConfigFiles(configFile, c.GatewayConfig.ExtraConfig.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		extra := &ExtraRouteConfig{}
+		// JSON is valid YAML
+		if err := decodeConfig(buf, extra); err != nil {
+			return nil, fmt.Errorf("in file %q: %w", file, err)
+		}
+		if err := checkDuplicates(file, extra.Routes, extra.Middlewares, routeFiles, middlewareFiles); err != nil {
+			return nil, err
+		}
+		c.GatewayConfig.Routes = append(c.GatewayConfig.Routes, extra.Routes...)
+		c.Middlewares = append(c.Middlewares, extra.Middlewares...)
+	}
+	if err := validateRoutes(c.GatewayConfig.Routes); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkDuplicates records the file of each route and middleware name, and reports duplicate names
+func checkDuplicates(file string, routes []Route, middlewares []Middleware, routeFiles, middlewareFiles map[string]string) error {
+	for _, route := range routes {
+		if route.Name == "" {
+			continue
+		}
+		if previous, ok := routeFiles[route.Name]; ok {
+			return fmt.Errorf("in file %q: duplicate route name %q, already defined in %q", file, route.Name, previous)
+		}
+		routeFiles[route.Name] = file
+	}
+	for _, middleware := range middlewares {
+		if previous, ok := middlewareFiles[middleware.Name]; ok {
+			return fmt.Errorf("in file %q: duplicate middleware name %q, already defined in %q", file, middleware.Name, previous)
+		}
+		middlewareFiles[middleware.Name] = file
+	}
+	return nil
+}
+
+// extraConfigDir returns the extra configuration directory path
+func extraConfigDir(configFile, directory string) string {
+	if directory == "" || filepath.IsAbs(directory) {
+		return directory
+	}
+	return filepath.Join(filepath.Dir(configFile), directory)
+}
+
+// extraConfigFiles returns the configuration files of the extra directory in file name order, hidden files are ignored.
+// A missing directory has no files
+func extraConfigFiles(configFile, directory string) ([]string, error) {
+	dir := extraConfigDir(configFile, directory)
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		// The directory may be created later, files are loaded on the next reload
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading extra configuration directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yml", ".yaml", ".json":
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// configFilesState returns the names, sizes and modification times of the configuration files
+func configFilesState(configFile, directory string) string {
+	var state strings.Builder
+	files, err := extraConfigFiles(configFile, directory)
+	if err != nil {
+		state.WriteString(err.Error())
+	}
+	for _, file := range append([]string{configFile}, files...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			state.WriteString(file + ":missing\n")
+			continue
+		}
+		_, _ = fmt.Fprintf(&state, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return state.String()
+}
+
+// watchConfig reloads the configuration when the configuration files are added, changed or removed
+func (rt *gatewayRuntime) watchConfig() {
+	extraConfig := rt.current().gateway.ExtraConfig
+	interval := time.Duration(extraConfig.WatchInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultConfigWatchInterval
+	}
+	configFile := rt.current().configFile
+	state := configFilesState(configFile, extraConfig.Directory)
+	for range time.Tick(interval) {
+		current := configFilesState(configFile, extraConfig.Directory)
+		if current == state {
+			continue
+		}
+		state = current
+		logger.Info("Configuration files changed")
+		if err := rt.Reload(); err != nil {
+			logger.Error("Error reloading configuration, keeping the running configuration: %v", err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const extraMainConfig = `
+gateway:
+  listenAddr: 0.0.0.0:8080
+  extraConfig:
+    directory: conf.d
+  routes:
+    - name: main
+      path: /
+      destination: http://main:8080
+middlewares:
+  - name: basic-auth
+    type: basic
+    rule:
+      username: admin
+      password: admin
+`
+
+func writeConfigFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "goma.yml")
+	writeConfigFile(t, configFile, extraMainConfig)
+	writeConfigFile(t, filepath.Join(dir, "conf.d", "20-orders.json"),
+		`{"routes":[{"name":"orders","path":"/orders","destination":"http://orders:8080"}]}`)
+	writeConfigFile(t, filepath.Join(dir, "conf.d", "10-users.yml"), `
+routes:
+  - name: users
+    path: /users
+    destination: http://users:8080
+middlewares:
+  - name: users-auth
+    type: basic
+    rule:
+      username: user
+      password: user
+`)
+	writeConfigFile(t, filepath.Join(dir, "conf.d", ".hidden.yml"), "routes: [")
+	writeConfigFile(t, filepath.Join(dir, "conf.d", "README.md"), "not a configuration file")
+
+	c, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	var names []string
+	for _, route := range c.GatewayConfig.Routes {
+		names = append(names, route.Name)
+	}
+	if got := strings.Join(names, ","); got != "main,users,orders" {
+		t.Errorf("expected routes main,users,orders, got %s", got)
+	}
+	if len(c.Middlewares) != 2 || c.Middlewares[1].Name != "users-auth" {
+		t.Errorf("expected the directory middleware, got %v", c.Middlewares)
+	}
+
+	// Duplicate names are reported with both files
+	duplicate := filepath.Join(dir, "conf.d", "30-duplicate.yaml")
+	writeConfigFile(t, duplicate, "routes:\n  - name: users\n    path: /v2/users\n    destination: http://users:8080\n")
+	_, err = loadConfig(configFile)
+	if err == nil || !strings.Contains(err.Error(), "30-duplicate.yaml") || !strings.Contains(err.Error(), "10-users.yml") {
+		t.Errorf("expected a duplicate route error naming both files, got %v", err)
+	}
+	writeConfigFile(t, duplicate, "middlewares:\n  - name: basic-auth\n    type: basic\n")
+	_, err = loadConfig(configFile)
+	if err == nil || !strings.Contains(err.Error(), "duplicate middleware name \"basic-auth\"") {
+		t.Errorf("expected a duplicate middleware error, got %v", err)
+	}
+}
+
+func TestConfigFilesState(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "goma.yml")
+	writeConfigFile(t, configFile, extraMainConfig)
+	writeConfigFile(t, filepath.Join(dir, "conf.d", "users.yml"), "routes: []\n")
+
+	state := configFilesState(configFile, "conf.d")
+	if configFilesState(configFile, "conf.d") != state {
+		t.Error("expected an unchanged state")
+	}
+	orders := filepath.Join(dir, "conf.d", "orders.yml")
+	writeConfigFile(t, orders, "routes: []\n")
+	added := configFilesState(configFile, "conf.d")
+	if added == state {
+		t.Error("expected a changed state after adding a file")
+	}
+	if err := os.Remove(orders); err != nil {
+		t.Fatal(err)
+	}
+	if configFilesState(configFile, "conf.d") == added {
+		t.Error("expected a changed state after removing a file")
+	}
+}
+
+func TestLoadConfigMissingDirectory(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "goma.yml")
+	writeConfigFile(t, configFile, extraMainConfig)
+	c, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if len(c.GatewayConfig.Routes) != 1 {
+		t.Errorf("expected the main file routes, got %d", len(c.GatewayConfig.Routes))
+	}
+}
+
+func TestLoadConfigInvalidRoute(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		want  string
+	}{
+		{name: "rewrite", route: "rewriteRules:\n        - pattern: \"^/(\"\n          replacement: /", want: "invalid rewrite pattern"},
+		{name: "backends", route: "backends:\n        - name: v1\n          destination: http://v1:8080\n        - name: v1\n          destination: http://v2:8080", want: "duplicate backend name"},
+		{name: "discovery", route: "discovery:\n        type: dns\n        name: users.internal", want: "dns discovery requires a name and a port"},
+		{name: "redirect pattern", route: "type: redirect\n      redirect:\n        pattern: \"^/(\"\n        path: /", want: "invalid rewrite pattern"},
+		{name: "redirect target", route: "type: redirect\n      redirect:\n        code: 302", want: "redirect requires https, scheme, host or path"},
+		{name: "redirect code", route: "type: redirect\n      redirect:\n        https: true\n        code: 200", want: "invalid redirect code 200"},
+		{name: "mirror", route: "mirror:\n        url: shadow:8080", want: `invalid mirror URL "shadow:8080"`},
+		{name: "mirror percentage", route: "mirror:\n        url: http://shadow:8080\n        percentage: 150", want: "mirror percentage 150 must be between 0 and 100"},
+		{name: "static", route: "type: static", want: "static dir is required"},
+		{name: "mock", route: "type: mock", want: "mock requires at least one response"},
+		{name: "mock template", route: "type: mock\n      mock:\n        responses:\n          - body: \"{{ .Vars.id \"", want: "mock response 1: error parsing body template"},
+		{name: "mock status", route: "type: mock\n      mock:\n        responses:\n          - status: 1000", want: "mock response 1: invalid status code 1000"},
+	}
+	for _, tt := range tests {
+		configFile := filepath.Join(t.TempDir(), "goma.yml")
+		writeConfigFile(t, configFile, `
+gateway:
+  routes:
+    - name: invalid
+      path: /invalid
+      destination: http://invalid:8080
+      `+tt.route+"\n")
+		_, err := loadConfig(configFile)
+		if err == nil || !strings.Contains(err.Error(), "route invalid: "+tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestLoadConfigInvalidTrustedProxies(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "goma.yml")
+	writeConfigFile(t, configFile, `
+gateway:
+  trustedProxies:
+    - 10.0.0.0/8
+    - 10.0.0.300
+`)
+	if _, err := loadConfig(configFile); err == nil || !strings.Contains(err.Error(), `trustedProxies: invalid IP "10.0.0.300"`) {
+		t.Errorf("expected an invalid trusted proxy error, got %v", err)
+	}
+}
@@ -58,7 +58,7 @@ func (heathRoute HealthCheckRoute) HealthCheckHandler(w http.ResponseWriter, r *
 	var routes []HealthCheckRouteResponse
 	for _, route := range heathRoute.Routes {
 		if route.HealthCheck != "" {
-			err := HealthCheck(route.Destination + route.HealthCheck)
+			err := HealthCheck(route.Destination+route.HealthCheck, route.UpstreamTLS)
 			if err != nil {
 				logger.Error("Route %s: %v", route.Name, err)
 				if heathRoute.DisableRouteHealthCheckError {
@@ -90,3 +90,17 @@ func (heathRoute HealthCheckRoute) HealthCheckHandler(w http.ResponseWriter, r *
 		return
 	}
 }
+
+// RespondWithError writes a JSON error response
+func RespondWithError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(ErrorResponse{
+		Success: false,
+		Code:    statusCode,
+		Message: message,
+	})
+	if err != nil {
+		return
+	}
+}
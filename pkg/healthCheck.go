@@ -1,12 +1,17 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
+// healthCheckTimeout is the route health check request timeout
+const healthCheckTimeout = 10 * time.Second
+
 type HealthCheckRoute struct {
 	DisableRouteHealthCheckError bool
 	Routes                       []Route
@@ -23,18 +28,27 @@ type HealthCheckRouteResponse struct {
 	Error  string `json:"error"`
 }
 
-func HealthCheck(healthURL string) error {
+func HealthCheck(healthURL string, upstreamTLS UpstreamTLS) error {
+	return HealthCheckContext(context.Background(), healthURL, upstreamTLS)
+}
+
+// HealthCheckContext checks the backend health, the check is canceled with the context
+func HealthCheckContext(ctx context.Context, healthURL string, upstreamTLS UpstreamTLS) error {
 	healthCheckURL, err := url.Parse(healthURL)
 	if err != nil {
 		return fmt.Errorf("error parsing HealthCheck URL: %v ", err)
 	}
 	// Create a new request for the route
-	healthReq, err := http.NewRequest("GET", healthCheckURL.String(), nil)
+	healthReq, err := http.NewRequestWithContext(ctx, "GET", healthCheckURL.String(), nil)
 	if err != nil {
 		return fmt.Errorf("error creating HealthCheck request: %v ", err)
 	}
 	// Perform the request to the route's healthcheck
-	client := &http.Client{}
+	transport, err := upstreamTLS.transport()
+	if err != nil {
+		return fmt.Errorf("error creating HealthCheck transport: %v ", err)
+	}
+	client := &http.Client{Transport: transport, Timeout: healthCheckTimeout}
 	healthResp, err := client.Do(healthReq)
 	if err != nil {
 		return fmt.Errorf("error performing HealthCheck request: %v ", err)
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"gopkg.in/yaml.v3"
+	"sync"
+	"time"
+)
+
+// statefulMiddlewares contains the middleware types keeping state, e.g. caches, sessions or file watchers,
+// they are kept across reloads when their settings are unchanged
+var statefulMiddlewares = map[string]bool{
+	"jwt":              true,
+	"forwardAuth":      true,
+	"apiKey":           true,
+	"oauth2Introspect": true,
+	"oidc":             true,
+}
+
+// middlewareInstances keeps the stateful middlewares and the global rate limiter of the running routes.
+//
+// Each Initialize is a generation, the instances not used by the new generation are closed when it replaces the previous one
+type middlewareInstances struct {
+	mu          sync.Mutex
+	instances   map[string]*middlewareInstance
+	used        map[string]bool
+	rateLimiter *middleware.RateLimiter
+}
+
+type middlewareInstance struct {
+	handler mux.MiddlewareFunc
+	// close releases the middleware resources, it's nil when there is nothing to release
+	close func()
+}
+
+func newMiddlewareInstances() *middlewareInstances {
+	return &middlewareInstances{instances: map[string]*middlewareInstance{}, used: map[string]bool{}}
+}
+
+// build returns the route middleware, stateful middlewares of the previous generation are reused when their settings are unchanged.
+//
+// Without instances, e.g. in tests, the middleware is always built
+func (mi *middlewareInstances) build(route Route, m Middleware) (mux.MiddlewareFunc, error) {
+	if mi == nil || !statefulMiddlewares[m.Type] {
+		handler, _, err := buildMiddleware(route, m)
+		return handler, err
+	}
+	rule, err := yaml.Marshal(m.Rule)
+	if err != nil {
+		return nil, err
+	}
+	// API keys depend on the route name and path
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s", route.Name, route.Path, m.Name, m.Type, rule)
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	if instance, ok := mi.instances[key]; ok {
+		mi.used[key] = true
+		return instance.handler, nil
+	}
+	handler, closer, err := buildMiddleware(route, m)
+	if err != nil {
+		return nil, err
+	}
+	mi.instances[key] = &middlewareInstance{handler: handler, close: closer}
+	mi.used[key] = true
+	return handler, nil
+}
+
+// globalRateLimiter returns the gateway rate limiter, the client counters are kept when the limit is unchanged
+func (mi *middlewareInstances) globalRateLimiter(requests int) *middleware.RateLimiter {
+	if mi == nil {
+		return middleware.NewRateLimiterWindow(requests, time.Minute)
+	}
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	if mi.rateLimiter == nil || mi.rateLimiter.Requests != requests {
+		mi.rateLimiter = middleware.NewRateLimiterWindow(requests, time.Minute)
+	}
+	return mi.rateLimiter
+}
+
+// release closes the instances not used by the generation initialized since the previous release,
+// it's called once the new generation serves the requests
+func (mi *middlewareInstances) release() {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	for key, instance := range mi.instances {
+		if mi.used[key] {
+			continue
+		}
+		if instance.close != nil {
+			instance.close()
+		}
+		delete(mi.instances, key)
+	}
+	mi.used = map[string]bool{}
+}
@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// interpolationPattern matches ${VAR}, ${VAR:-default}, ${file:/path} and the $${ escape
+var interpolationPattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// decodeConfig decodes a configuration file after interpolating its values
+func decodeConfig(buf []byte, out interface{}) error {
+	node, err := interpolatedNode(buf, reflect.TypeOf(out))
+	if err != nil {
+		return err
+	}
+	return node.Decode(out)
+}
+
+// interpolatedNode parses a configuration file and interpolates its values,
+// the interpolated values are strings unless the typ field is a number or a boolean
+func interpolatedNode(buf []byte, typ reflect.Type) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return nil, err
+	}
+	if err := interpolateNode(&node, typ); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// interpolateNode replaces the references of the scalar values decoded into typ, mapping keys are kept as is.
+//
+// Interpolated values are tagged as strings, a secret file containing 007 or null must not be decoded as a number or null,
+// unless the field is a number or a boolean, e.g. ${TIMEOUT:-30}.
+// Fields tagged interpolate:"false" are not interpolated, e.g. regex replacements using ${name} capture groups.
+// Middleware rules are resolved with the rule of the middleware type
+func interpolateNode(node *yaml.Node, typ reflect.Type) error {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value == node.Value {
+			return nil
+		}
+		node.Value = value
+		node.Tag = "!!str"
+		if typ == nil {
+			return nil
+		}
+		switch typ.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			node.Tag = ""
+		}
+	case yaml.SequenceNode:
+		var elem reflect.Type
+		if typ != nil && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+			elem = typ.Elem()
+		}
+		for _, child := range node.Content {
+			if err := interpolateNode(child, elem); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		raw := map[string]bool{}
+		var elem reflect.Type
+		if typ != nil && typ.Kind() == reflect.Map {
+			elem = typ.Elem()
+		}
+		if typ != nil && typ.Kind() == reflect.Struct {
+			for i := 0; i < typ.NumField(); i++ {
+				if field := typ.Field(i); field.IsExported() {
+					fields[yamlFieldName(field)] = field.Type
+					raw[yamlFieldName(field)] = field.Tag.Get("interpolate") == "false"
+				}
+			}
+			if typ == reflect.TypeOf(Middleware{}) {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if rule, ok := middlewareRules[node.Content[i+1].Value]; ok && node.Content[i].Value == "type" {
+						fields["rule"] = reflect.TypeOf(rule)
+					}
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if raw[key] {
+				continue
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				fieldType = elem
+			}
+			if err := interpolateNode(node.Content[i+1], fieldType); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, typ); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate replaces the environment variables and secret files references of a value.
+//
+// ${VAR} fails when VAR is not set, ${VAR:-default} uses the default when VAR is not set or empty,
+// ${file:/run/secrets/password} reads the file content without the trailing newline and $${ escapes ${
+func interpolate(value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var err error
+	result := interpolationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" || err != nil {
+			return strings.TrimPrefix(match, "$")
+		}
+		expr := match[2 : len(match)-1]
+		if path, ok := strings.CutPrefix(expr, "file:"); ok {
+			content, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = fmt.Errorf("error reading secret file: %w", readErr)
+				return ""
+			}
+			return strings.TrimRight(string(content), "\r\n")
+		}
+		name, defaultValue, hasDefault := strings.Cut(expr, ":-")
+		if env, ok := os.LookupEnv(name); ok && (env != "" || !hasDefault) {
+			return env
+		}
+		if !hasDefault {
+			err = fmt.Errorf("environment variable %q is not set", name)
+			return ""
+		}
+		return defaultValue
+	})
+	return result, err
+}
+
+// applyEnvOverrides sets the gateway fields from the environment variables of their env tags.
+//
+// The environment variable overrides the file value with the overwrite option, otherwise it's only used when the field is not set
+func applyEnvOverrides(gateway *Gateway) error {
+	v := reflect.ValueOf(gateway).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		name = strings.TrimSpace(name)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		if strings.TrimSpace(options) != "overwrite" && !field.IsZero() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(env)
+		case reflect.Int:
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return fmt.Errorf("invalid %s value %q: %w", name, env, err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				return fmt.Errorf("invalid %s value %q: %w", name, env, err)
+			}
+			field.SetBool(b)
+		}
+	}
+	return nil
+}
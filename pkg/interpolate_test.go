@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMA_TEST_HOST", "users")
+	t.Setenv("GOMA_TEST_EMPTY", "")
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "http://${GOMA_TEST_HOST}:8080", want: "http://users:8080"},
+		{value: "${GOMA_TEST_PORT:-8080}", want: "8080"},
+		{value: "${GOMA_TEST_EMPTY:-default}", want: "default"},
+		{value: "${GOMA_TEST_EMPTY}", want: ""},
+		{value: "${file:" + secret + "}", want: "s3cret"},
+		{value: "$${GOMA_TEST_HOST}", want: "${GOMA_TEST_HOST}"},
+		{value: "$2y$05$hash", want: "$2y$05$hash"},
+		{value: "${GOMA_TEST_MISSING}", wantErr: true},
+		{value: "${file:/missing/secret}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := interpolate(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("interpolate(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigInterpolation(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("USERS_HOST", "users")
+	t.Setenv("GOMA_LISTEN_ADDR", "0.0.0.0:9000")
+	t.Setenv("GOMA_RATE_LIMITER", "50")
+	configFile := filepath.Join(dir, "goma.yml")
+	writeConfigFile(t, configFile, `
+gateway:
+  listenAddr: 0.0.0.0:8080
+  writeTimeout: ${GOMA_TEST_TIMEOUT:-30}
+  rateLimiter: 10
+  routes:
+    - name: users
+      path: /users
+      destination: http://${USERS_HOST}:8080
+middlewares:
+  - name: basic-auth
+    type: basic
+    rule:
+      username: admin
+      password: ${file:`+secret+`}
+`)
+	c, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	gateway := c.GatewayConfig
+	if gateway.ListenAddr != "0.0.0.0:9000" || gateway.RateLimiter != 50 || gateway.WriteTimeout != 30 {
+		t.Errorf("unexpected gateway settings: listenAddr=%s rateLimiter=%d writeTimeout=%d", gateway.ListenAddr, gateway.RateLimiter, gateway.WriteTimeout)
+	}
+	if gateway.Routes[0].Destination != "http://users:8080" {
+		t.Errorf("expected interpolated destination, got %s", gateway.Routes[0].Destination)
+	}
+	rule, err := ToBasicAuth(c.Middlewares[0].Rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Password != "s3cret" {
+		t.Errorf("expected the secret file password, got %q", rule.Password)
+	}
+
+	t.Setenv("GOMA_RATE_LIMITER", "many")
+	if _, err := loadConfig(configFile); err == nil {
+		t.Error("expected an invalid environment variable error")
+	}
+}
+
+func TestDecodeConfigInterpolatedTypes(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("007\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMA_TEST_HEX", "0x1F")
+	t.Setenv("GOMA_TEST_TOLERANCE", "60")
+	c := &GatewayConfig{}
+	err := decodeConfig([]byte(`
+gateway:
+  writeTimeout: ${GOMA_TEST_TIMEOUT:-30}
+middlewares:
+  - name: basic-auth
+    type: basic
+    rule:
+      username: ${GOMA_TEST_HEX}
+      password: ${file:`+secret+`}
+  - name: signature
+    type: signature
+    rule:
+      secret: ${GOMA_TEST_EMPTY:-null}
+      tolerance: ${GOMA_TEST_TOLERANCE}
+`), c)
+	if err != nil {
+		t.Fatalf("Error decoding config: %v", err)
+	}
+	if c.GatewayConfig.WriteTimeout != 30 {
+		t.Errorf("expected writeTimeout 30, got %d", c.GatewayConfig.WriteTimeout)
+	}
+	basic, err := ToBasicAuth(c.Middlewares[0].Rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if basic.Username != "0x1F" || basic.Password != "007" {
+		t.Errorf("expected the interpolated strings to be kept, got username=%q password=%q", basic.Username, basic.Password)
+	}
+	signature, err := ToSignatureRule(c.Middlewares[1].Rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.Secret != "null" || signature.Tolerance != 60 {
+		t.Errorf("unexpected signature rule: secret=%q tolerance=%d", signature.Secret, signature.Tolerance)
+	}
+}
+
+func TestDecodeConfigRewriteReplacement(t *testing.T) {
+	t.Setenv("id", "unrelated")
+	c := &GatewayConfig{}
+	err := decodeConfig([]byte(`
+gateway:
+  routes:
+    - name: users
+      path: /users
+      destination: ${GOMA_TEST_DESTINATION:-http://users:8080}
+      rewriteRules:
+        - pattern: ^/users/(?P<id>\d+)
+          replacement: /u/${id}
+    - name: old-users
+      path: /old
+      type: redirect
+      redirect:
+        pattern: ^/old/(?P<name>[a-z]+)
+        path: /users/${name}
+`), c)
+	if err != nil {
+		t.Fatalf("Error decoding config: %v", err)
+	}
+	routes := c.GatewayConfig.Routes
+	if routes[0].Destination != "http://users:8080" {
+		t.Errorf("expected the interpolated destination, got %q", routes[0].Destination)
+	}
+	if replacement := routes[0].RewriteRules[0].Replacement; replacement != "/u/${id}" {
+		t.Errorf("expected the capture group to be kept, got %q", replacement)
+	}
+	if path := routes[1].Redirect.Path; path != "/users/${name}" {
+		t.Errorf("expected the capture group to be kept, got %q", path)
+	}
+}
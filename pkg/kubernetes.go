@@ -0,0 +1,756 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// kubernetesAnnotationPrefix is the prefix of the Ingress and HTTPRoute annotations read by goma
+	kubernetesAnnotationPrefix = "goma.ingress.kubernetes.io/"
+	// defaultIngressClass is the Ingress class handled by default
+	defaultIngressClass        = "goma"
+	kubernetesServiceAccount   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesRetryInterval    = 5 * time.Second
+	kubernetesWatchTimeout     = 5 * time.Minute
+	kubernetesMaxEventSize     = 10 * 1024 * 1024
+	kubernetesServiceNameLabel = "kubernetes.io/service-name"
+)
+
+// kubernetesProvider lists and watches the Kubernetes API server
+type kubernetesProvider struct {
+	config KubernetesProvider
+	client *http.Client
+	// debounce groups the changes of a short period in one update
+	debounce time.Duration
+}
+
+// NewKubernetesProvider creates the Kubernetes provider, the in-cluster service account is used by default
+func NewKubernetesProvider(config KubernetesProvider) Provider {
+	if config.Endpoint == "" {
+		config.Endpoint = "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	}
+	if config.TokenFile == "" {
+		config.TokenFile = kubernetesServiceAccount + "/token"
+	}
+	if config.CAFile == "" {
+		config.CAFile = kubernetesServiceAccount + "/ca.crt"
+	}
+	if config.IngressClass == "" {
+		config.IngressClass = defaultIngressClass
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caPEM, err := os.ReadFile(config.CAFile); err == nil {
+		rootCAs := x509.NewCertPool()
+		rootCAs.AppendCertsFromPEM(caPEM)
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+	return &kubernetesProvider{config: config, client: &http.Client{Transport: transport}, debounce: time.Second}
+}
+
+func (p *kubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+// Provide lists the objects and sends the routes, then applies the watch events to the listed objects.
+//
+// The objects are listed again when a watch fails, e.g. when the resource version expired
+func (p *kubernetesProvider) Provide(ctx context.Context, configs chan<- ProviderConfig) {
+	var previous *ProviderConfig
+	send := func(state *kubernetesState) bool {
+		config := ProviderConfig{Provider: p.Name(), Routes: state.routes(p.config)}
+		if previous != nil && reflect.DeepEqual(config, *previous) {
+			return true
+		}
+		select {
+		case configs <- config:
+			previous = &config
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for ctx.Err() == nil {
+		state, err := p.list(ctx)
+		if err != nil {
+			logger.Error("Kubernetes provider: %v", err)
+			p.sleep(ctx, kubernetesRetryInterval)
+			continue
+		}
+		if !send(state) {
+			return
+		}
+		if err := p.watch(ctx, state, send); err != nil && ctx.Err() == nil {
+			logger.Error("Kubernetes provider: %v", err)
+			p.sleep(ctx, p.debounce)
+		}
+	}
+}
+
+func (p *kubernetesProvider) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// collections returns the API paths of the watched collections
+func (p *kubernetesProvider) collections() map[string]string {
+	namespaces := p.config.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	collections := map[string]string{}
+	for _, namespace := range namespaces {
+		for kind, api := range map[string]string{
+			"ingresses":      "/apis/networking.k8s.io/v1",
+			"services":       "/api/v1",
+			"endpointslices": "/apis/discovery.k8s.io/v1",
+			"httproutes":     "/apis/gateway.networking.k8s.io/v1",
+		} {
+			if kind == "httproutes" && !p.config.GatewayAPI {
+				continue
+			}
+			path := api + "/" + kind
+			if namespace != "" {
+				path = api + "/namespaces/" + namespace + "/" + kind
+			}
+			collections[path] = kind
+		}
+	}
+	return collections
+}
+
+// selector returns the list and watch query of a collection, only the endpoint slices of services are watched
+func (p *kubernetesProvider) selector(kind string) url.Values {
+	query := url.Values{}
+	if kind == "endpointslices" {
+		query.Set("labelSelector", kubernetesServiceNameLabel)
+	}
+	return query
+}
+
+// get sends a request to the API server, the service account token is read on each request as it's rotated
+func (p *kubernetesProvider) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Endpoint, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token, err := os.ReadFile(p.config.TokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status code %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// list returns the objects of the watched collections
+func (p *kubernetesProvider) list(ctx context.Context) (*kubernetesState, error) {
+	state := newKubernetesState(p.config.IngressClass)
+	for path, kind := range p.collections() {
+		resp, err := p.get(ctx, path, p.selector(kind))
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+			Items []json.RawMessage `json:"items"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		state.resourceVersions[path] = list.Metadata.ResourceVersion
+		for _, item := range list.Items {
+			if err := state.put(kind, item); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return state, nil
+}
+
+// k8sWatchEvent is a watch event of the API server
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+	path   string
+}
+
+// watch applies the watch events of the collections to the state, the changes of a debounce period are sent together.
+//
+// It returns when a watch fails or when the context is canceled
+func (p *kubernetesProvider) watch(ctx context.Context, state *kubernetesState, send func(*kubernetesState) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	collections := p.collections()
+	events := make(chan k8sWatchEvent)
+	errs := make(chan error, len(collections))
+	var wg sync.WaitGroup
+	// The other watches are stopped when one fails
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for path, kind := range collections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.watchCollection(ctx, path, kind, state.resourceVersions[path], events)
+		}()
+	}
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event := <-events:
+			if err := state.apply(collections[event.path], event); err != nil {
+				return fmt.Errorf("%s: %w", event.path, err)
+			}
+			if debounce == nil {
+				debounce = time.After(p.debounce)
+			}
+		case <-debounce:
+			debounce = nil
+			if !send(state) {
+				return ctx.Err()
+			}
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watchCollection sends the watch events of a collection, the watch is resumed from the last resource version when it times out
+func (p *kubernetesProvider) watchCollection(ctx context.Context, path, kind, resourceVersion string, events chan<- k8sWatchEvent) error {
+	for {
+		query := p.selector(kind)
+		query.Set("watch", "1")
+		query.Set("allowWatchBookmarks", "true")
+		query.Set("resourceVersion", resourceVersion)
+		query.Set("timeoutSeconds", strconv.Itoa(int(kubernetesWatchTimeout.Seconds())))
+		resp, err := p.get(ctx, path, query)
+		if err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), kubernetesMaxEventSize)
+		for scanner.Scan() {
+			var event k8sWatchEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				_ = resp.Body.Close()
+				return fmt.Errorf("error decoding %s event: %w", path, err)
+			}
+			if event.Type == "ERROR" {
+				// e.g. 410 Gone, the resource version expired
+				_ = resp.Body.Close()
+				return fmt.Errorf("error watching %s: %s", path, event.Object)
+			}
+			var object struct {
+				Metadata struct {
+					ResourceVersion string `json:"resourceVersion"`
+				} `json:"metadata"`
+			}
+			if err := json.Unmarshal(event.Object, &object); err == nil && object.Metadata.ResourceVersion != "" {
+				resourceVersion = object.Metadata.ResourceVersion
+			}
+			if event.Type == "BOOKMARK" {
+				continue
+			}
+			logger.Debug("Kubernetes provider: %s %s", path, strings.ToLower(event.Type))
+			event.path = path
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				_ = resp.Body.Close()
+				return ctx.Err()
+			}
+		}
+		_ = resp.Body.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+	}
+}
+
+// Kubernetes API objects, only the fields used by the provider are decoded
+type k8sObjectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type k8sIngress struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		IngressClassName string             `json:"ingressClassName"`
+		DefaultBackend   *k8sIngressBackend `json:"defaultBackend"`
+		Rules            []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path     string            `json:"path"`
+					PathType string            `json:"pathType"`
+					Backend  k8sIngressBackend `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+type k8sIngressBackend struct {
+	Service *struct {
+		Name string `json:"name"`
+		Port struct {
+			Name   string `json:"name"`
+			Number int    `json:"number"`
+		} `json:"port"`
+	} `json:"service"`
+}
+
+type k8sService struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		Ports []k8sServicePort `json:"ports"`
+	} `json:"spec"`
+}
+
+type k8sServicePort struct {
+	Name        string `json:"name"`
+	Port        int    `json:"port"`
+	AppProtocol string `json:"appProtocol"`
+}
+
+type k8sEndpointSlice struct {
+	Metadata  k8sObjectMeta `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+type k8sHTTPRoute struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		ParentRefs []struct {
+			Name string `json:"name"`
+		} `json:"parentRefs"`
+		Hostnames []string `json:"hostnames"`
+		Rules     []struct {
+			Matches []struct {
+				Path *struct {
+					Type  string `json:"type"`
+					Value string `json:"value"`
+				} `json:"path"`
+			} `json:"matches"`
+			Filters []struct {
+				Type       string `json:"type"`
+				URLRewrite *struct {
+					Path *struct {
+						Type               string `json:"type"`
+						ReplacePrefixMatch string `json:"replacePrefixMatch"`
+					} `json:"path"`
+				} `json:"urlRewrite"`
+			} `json:"filters"`
+			BackendRefs []struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+				Port      int    `json:"port"`
+				Weight    *int   `json:"weight"`
+			} `json:"backendRefs"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// kubernetesState contains the listed objects by namespace/name, they are updated by the watch events
+type kubernetesState struct {
+	// ingressClass is the handled Ingress class, the Ingresses of other classes are not kept
+	ingressClass     string
+	ingresses        map[string]k8sIngress
+	httpRoutes       map[string]k8sHTTPRoute
+	services         map[string]k8sService
+	endpointSlices   map[string]k8sEndpointSlice
+	resourceVersions map[string]string
+}
+
+func newKubernetesState(ingressClass string) *kubernetesState {
+	return &kubernetesState{
+		ingressClass:     ingressClass,
+		ingresses:        map[string]k8sIngress{},
+		httpRoutes:       map[string]k8sHTTPRoute{},
+		services:         map[string]k8sService{},
+		endpointSlices:   map[string]k8sEndpointSlice{},
+		resourceVersions: map[string]string{},
+	}
+}
+
+func (m k8sObjectMeta) key() string {
+	return m.Namespace + "/" + m.Name
+}
+
+// class returns the Ingress class, from the spec or the legacy annotation
+func (ingress k8sIngress) class() string {
+	if ingress.Spec.IngressClassName != "" {
+		return ingress.Spec.IngressClassName
+	}
+	return ingress.Metadata.Annotations["kubernetes.io/ingress.class"]
+}
+
+// apply applies a watch event to the objects
+func (s *kubernetesState) apply(kind string, event k8sWatchEvent) error {
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		return s.put(kind, event.Object)
+	case "DELETED":
+		var object struct {
+			Metadata k8sObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Object, &object); err != nil {
+			return err
+		}
+		key := object.Metadata.key()
+		switch kind {
+		case "ingresses":
+			delete(s.ingresses, key)
+		case "httproutes":
+			delete(s.httpRoutes, key)
+		case "services":
+			delete(s.services, key)
+		case "endpointslices":
+			delete(s.endpointSlices, key)
+		}
+	}
+	return nil
+}
+
+// put adds or replaces an object
+func (s *kubernetesState) put(kind string, item json.RawMessage) error {
+	switch kind {
+	case "ingresses":
+		var ingress k8sIngress
+		if err := json.Unmarshal(item, &ingress); err != nil {
+			return err
+		}
+		// The Ingresses of other classes don't change the routes, e.g. when the class is changed
+		if ingress.class() != s.ingressClass {
+			delete(s.ingresses, ingress.Metadata.key())
+			return nil
+		}
+		s.ingresses[ingress.Metadata.key()] = ingress
+	case "httproutes":
+		var httpRoute k8sHTTPRoute
+		if err := json.Unmarshal(item, &httpRoute); err != nil {
+			return err
+		}
+		s.httpRoutes[httpRoute.Metadata.key()] = httpRoute
+	case "services":
+		var service k8sService
+		if err := json.Unmarshal(item, &service); err != nil {
+			return err
+		}
+		s.services[service.Metadata.key()] = service
+	case "endpointslices":
+		var slice k8sEndpointSlice
+		if err := json.Unmarshal(item, &slice); err != nil {
+			return err
+		}
+		s.endpointSlices[slice.Metadata.key()] = slice
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the objects in namespace/name order, the API server list order
+func sortedKeys[T any](objects map[string]T) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// routes translates the Ingress and HTTPRoute objects to routes
+func (s *kubernetesState) routes(config KubernetesProvider) []Route {
+	var routes []Route
+	for _, key := range sortedKeys(s.ingresses) {
+		ingress := s.ingresses[key]
+		namespace := ingress.Metadata.Namespace
+		start := len(routes)
+		n := 0
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				n++
+				// goma routes match path prefixes, an exact path would serve its sub paths
+				if path.PathType == "Exact" {
+					logger.Warn("Kubernetes provider: ingress %s/%s: path %s skipped, pathType Exact is not supported", namespace, ingress.Metadata.Name, path.Path)
+					continue
+				}
+				route := Route{
+					Name: fmt.Sprintf("ingress-%s-%s-%d", namespace, ingress.Metadata.Name, n),
+					Path: path.Path,
+				}
+				if rule.Host != "" {
+					route.Hosts = []string{rule.Host}
+				}
+				if !s.setIngressBackend(&route, namespace, path.Backend) {
+					continue
+				}
+				routes = append(routes, route)
+			}
+		}
+		if backend := ingress.Spec.DefaultBackend; backend != nil {
+			route := Route{Name: fmt.Sprintf("ingress-%s-%s-default", namespace, ingress.Metadata.Name), Path: "/"}
+			if s.setIngressBackend(&route, namespace, *backend) {
+				routes = append(routes, route)
+			}
+		}
+		for i := start; i < len(routes); i++ {
+			applyKubernetesAnnotations(&routes[i], ingress.Metadata.Annotations)
+		}
+	}
+	if config.GatewayAPI {
+		for _, key := range sortedKeys(s.httpRoutes) {
+			httpRoute := s.httpRoutes[key]
+			if !attachedToGateway(httpRoute, config.GatewayName) {
+				continue
+			}
+			routes = append(routes, s.httpRouteRoutes(httpRoute)...)
+		}
+	}
+	for i := range routes {
+		if routes[i].Path == "" {
+			routes[i].Path = "/"
+		}
+	}
+	sortRoutes(routes)
+	return routes
+}
+
+// setIngressBackend sets the backends of an Ingress service backend
+func (s *kubernetesState) setIngressBackend(route *Route, namespace string, backend k8sIngressBackend) bool {
+	if backend.Service == nil {
+		logger.Error("Kubernetes provider: route %s: only service backends are supported", route.Name)
+		return false
+	}
+	port := k8sServicePort{Name: backend.Service.Port.Name, Port: backend.Service.Port.Number}
+	backends, err := s.serviceBackends(namespace, backend.Service.Name, port, 1)
+	if err != nil {
+		logger.Error("Kubernetes provider: route %s: %v", route.Name, err)
+		return false
+	}
+	route.Backends = backends
+	return true
+}
+
+// httpRouteRoutes translates a HTTPRoute, each rule match is a route
+func (s *kubernetesState) httpRouteRoutes(httpRoute k8sHTTPRoute) []Route {
+	var routes []Route
+	namespace := httpRoute.Metadata.Namespace
+	n := 0
+	for _, rule := range httpRoute.Spec.Rules {
+		var backends []Backend
+		for _, ref := range rule.BackendRefs {
+			weight := 1
+			if ref.Weight != nil {
+				weight = *ref.Weight
+			}
+			refNamespace := ref.Namespace
+			if refNamespace == "" {
+				refNamespace = namespace
+			}
+			refBackends, err := s.serviceBackends(refNamespace, ref.Name, k8sServicePort{Port: ref.Port}, weight)
+			if err != nil {
+				logger.Error("Kubernetes provider: httproute %s/%s: %v", namespace, httpRoute.Metadata.Name, err)
+				continue
+			}
+			backends = append(backends, refBackends...)
+		}
+		if len(backends) == 0 {
+			continue
+		}
+		rewrite := ""
+		for _, filter := range rule.Filters {
+			if filter.URLRewrite != nil && filter.URLRewrite.Path != nil && filter.URLRewrite.Path.Type == "ReplacePrefixMatch" {
+				rewrite = filter.URLRewrite.Path.ReplacePrefixMatch
+				continue
+			}
+			logger.Error("Kubernetes provider: httproute %s/%s: unsupported filter %s", namespace, httpRoute.Metadata.Name, filter.Type)
+		}
+		paths := []string{"/"}
+		if len(rule.Matches) != 0 {
+			paths = nil
+			for _, match := range rule.Matches {
+				if match.Path == nil {
+					paths = append(paths, "/")
+					continue
+				}
+				if match.Path.Type == "RegularExpression" || match.Path.Type == "Exact" {
+					logger.Error("Kubernetes provider: httproute %s/%s: unsupported path type %s", namespace, httpRoute.Metadata.Name, match.Path.Type)
+					continue
+				}
+				paths = append(paths, match.Path.Value)
+			}
+		}
+		for _, path := range paths {
+			n++
+			route := Route{
+				Name:     fmt.Sprintf("httproute-%s-%s-%d", namespace, httpRoute.Metadata.Name, n),
+				Path:     path,
+				Hosts:    httpRoute.Spec.Hostnames,
+				Rewrite:  rewrite,
+				Backends: backends,
+			}
+			applyKubernetesAnnotations(&route, httpRoute.Metadata.Annotations)
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// attachedToGateway reports whether the HTTPRoute is attached to the gateway, all HTTPRoute objects are handled without gateway name
+func attachedToGateway(httpRoute k8sHTTPRoute, gatewayName string) bool {
+	if gatewayName == "" {
+		return true
+	}
+	for _, ref := range httpRoute.Spec.ParentRefs {
+		if ref.Name == gatewayName {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceBackends returns the ready endpoints of a service port, sharing the weight.
+//
+// The service DNS name is used when the service has no ready endpoint
+func (s *kubernetesState) serviceBackends(namespace, name string, port k8sServicePort, weight int) ([]Backend, error) {
+	service, ok := s.services[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("service %s/%s not found", namespace, name)
+	}
+	var servicePort *k8sServicePort
+	for i, p := range service.Spec.Ports {
+		if (port.Name != "" && p.Name == port.Name) || (port.Name == "" && p.Port == port.Port) {
+			servicePort = &service.Spec.Ports[i]
+			break
+		}
+	}
+	if servicePort == nil {
+		return nil, fmt.Errorf("service %s/%s: port %s not found", namespace, name, strings.TrimPrefix(port.Name+"/"+strconv.Itoa(port.Port), "/"))
+	}
+	scheme := "http"
+	if servicePort.AppProtocol == "https" || servicePort.Name == "https" {
+		scheme = "https"
+	}
+	var addresses []string
+	for _, slice := range s.endpointSlices {
+		if slice.Metadata.Namespace != namespace || slice.Metadata.Labels[kubernetesServiceNameLabel] != name {
+			continue
+		}
+		for _, p := range slice.Ports {
+			if p.Name != servicePort.Name {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+				for _, address := range endpoint.Addresses {
+					addresses = append(addresses, net.JoinHostPort(address, strconv.Itoa(p.Port)))
+				}
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return []Backend{{
+			Name:        fmt.Sprintf("%s-%s", namespace, name),
+			Destination: fmt.Sprintf("%s://%s.%s.svc:%d", scheme, name, namespace, servicePort.Port),
+			Weight:      weight * 100,
+		}}, nil
+	}
+	sort.Strings(addresses)
+	backends := make([]Backend, 0, len(addresses))
+	for _, address := range addresses {
+		backendWeight := weight * 100 / len(addresses)
+		if weight > 0 {
+			backendWeight = max(backendWeight, 1)
+		}
+		backends = append(backends, Backend{
+			Name:        fmt.Sprintf("%s-%s-%s", namespace, name, address),
+			Destination: scheme + "://" + address,
+			Weight:      backendWeight,
+		})
+	}
+	return backends, nil
+}
+
+// applyKubernetesAnnotations sets the route settings from the goma annotations
+func applyKubernetesAnnotations(route *Route, annotations map[string]string) {
+	annotation := func(name string) string {
+		return strings.TrimSpace(annotations[kubernetesAnnotationPrefix+name])
+	}
+	if rewrite := annotation("rewrite"); rewrite != "" {
+		route.Rewrite = rewrite
+	}
+	if middlewares := splitList(annotation("middlewares")); len(middlewares) != 0 {
+		route.Middlewares = []RouteMiddleware{{Path: "/", Rules: middlewares, Mode: annotation("middlewares-mode")}}
+	}
+	if healthCheck := annotation("health-check"); healthCheck != "" {
+		route.HealthCheck = healthCheck
+	}
+	if blocklist := splitList(annotation("blocklist")); len(blocklist) != 0 {
+		route.Blocklist = blocklist
+	}
+	if disable, err := strconv.ParseBool(annotation("disable-x-forward")); err == nil {
+		route.DisableHeaderXForward = disable
+	}
+}
+
+// splitList splits a comma separated list, empty values are ignored
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,357 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKubernetes is a Kubernetes API server stand-in serving lists and watches of raw JSON items,
+// the watches send the added, modified and deleted items since the requested resource version
+type fakeKubernetes struct {
+	mu      sync.Mutex
+	items   map[string]map[string]json.RawMessage
+	events  []fakeKubernetesEvent
+	version int
+	// expired is the oldest resource version the watches of the expired paths can start from, all paths when empty
+	expired      int
+	expiredPaths []string
+	// lists is the number of list requests
+	lists   int
+	changed chan struct{}
+}
+
+type fakeKubernetesEvent struct {
+	path    string
+	version int
+	line    []byte
+}
+
+func newFakeKubernetes() *fakeKubernetes {
+	return &fakeKubernetes{items: map[string]map[string]json.RawMessage{}, changed: make(chan struct{})}
+}
+
+// set replaces the items of a collection, the changed items get a new resource version
+func (f *fakeKubernetes) set(path, items string) {
+	var list []map[string]interface{}
+	if err := json.Unmarshal([]byte(items), &list); err != nil {
+		panic(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := f.items[path]
+	current := map[string]json.RawMessage{}
+	event := func(kind string, item json.RawMessage) {
+		line, _ := json.Marshal(map[string]interface{}{"type": kind, "object": item})
+		f.events = append(f.events, fakeKubernetesEvent{path: path, version: f.version, line: append(line, '\n')})
+	}
+	for _, item := range list {
+		metadata := item["metadata"].(map[string]interface{})
+		key := fmt.Sprintf("%s/%s", metadata["namespace"], metadata["name"])
+		unversioned, _ := json.Marshal(item)
+		if old, ok := previous[key]; ok && string(f.unversioned(old)) == string(unversioned) {
+			current[key] = old
+			continue
+		}
+		f.version++
+		metadata["resourceVersion"] = strconv.Itoa(f.version)
+		current[key], _ = json.Marshal(item)
+		if _, ok := previous[key]; ok {
+			event("MODIFIED", current[key])
+		} else {
+			event("ADDED", current[key])
+		}
+	}
+	for key, old := range previous {
+		if _, ok := current[key]; !ok {
+			f.version++
+			event("DELETED", old)
+		}
+	}
+	f.items[path] = current
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// unversioned returns an item without its resource version
+func (f *fakeKubernetes) unversioned(item json.RawMessage) []byte {
+	var object map[string]interface{}
+	_ = json.Unmarshal(item, &object)
+	delete(object["metadata"].(map[string]interface{}), "resourceVersion")
+	buf, _ := json.Marshal(object)
+	return buf
+}
+
+// expire expires the resource versions of the paths, or of all paths, the running watches fail with 410 Gone
+func (f *fakeKubernetes) expire(paths ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	f.expired = f.version
+	f.expiredPaths = paths
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/endpointslices") && r.URL.Query().Get("labelSelector") != kubernetesServiceNameLabel {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("watch") == "1" {
+		f.watch(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+	keys := sortedKeys(f.items[r.URL.Path])
+	items := make([]json.RawMessage, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, f.items[r.URL.Path][key])
+	}
+	buf, _ := json.Marshal(items)
+	_, _ = fmt.Fprintf(w, `{"metadata":{"resourceVersion":"%d"},"items":%s}`, f.version, buf)
+}
+
+func (f *fakeKubernetes) watch(w http.ResponseWriter, r *http.Request) {
+	version, _ := strconv.Atoi(r.URL.Query().Get("resourceVersion"))
+	w.WriteHeader(http.StatusOK)
+	for {
+		f.mu.Lock()
+		if version < f.expired && (len(f.expiredPaths) == 0 || slices.Contains(f.expiredPaths, r.URL.Path)) {
+			f.mu.Unlock()
+			_, _ = w.Write([]byte(`{"type":"ERROR","object":{"code":410}}` + "\n"))
+			return
+		}
+		for _, event := range f.events {
+			if event.path == r.URL.Path && event.version > version {
+				_, _ = w.Write(event.line)
+			}
+		}
+		version = f.version
+		changed := f.changed
+		f.mu.Unlock()
+		w.(http.Flusher).Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const (
+	k8sTestServices = `[
+  {"metadata":{"name":"users","namespace":"default"},"spec":{"ports":[{"name":"http","port":80}]}},
+  {"metadata":{"name":"orders","namespace":"default"},"spec":{"ports":[{"port":8080}]}}
+]`
+	k8sTestEndpointSlices = `[
+  {"metadata":{"name":"users-abc","namespace":"default","labels":{"kubernetes.io/service-name":"users"}},
+   "endpoints":[{"addresses":["10.0.0.2"],"conditions":{"ready":true}},{"addresses":["10.0.0.1"]},{"addresses":["10.0.0.3"],"conditions":{"ready":false}}],
+   "ports":[{"name":"http","port":8080}]}
+]`
+	k8sTestIngresses = `[
+  {"metadata":{"name":"shop","namespace":"default","annotations":{
+     "goma.ingress.kubernetes.io/rewrite":"/",
+     "goma.ingress.kubernetes.io/middlewares":"basic-auth, api-key"}},
+   "spec":{"ingressClassName":"goma",
+     "defaultBackend":{"service":{"name":"orders","port":{"number":8080}}},
+     "rules":[{"host":"shop.example.com","http":{"paths":[
+       {"path":"/users","pathType":"Prefix","backend":{"service":{"name":"users","port":{"name":"http"}}}},
+       {"path":"/missing","pathType":"Prefix","backend":{"service":{"name":"missing","port":{"number":80}}}},
+       {"path":"/exact","pathType":"Exact","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}},
+  {"metadata":{"name":"other","namespace":"default"},
+   "spec":{"ingressClassName":"nginx","rules":[{"http":{"paths":[{"path":"/","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}}
+]`
+	k8sTestHTTPRoutes = `[
+  {"metadata":{"name":"api","namespace":"default"},
+   "spec":{"parentRefs":[{"name":"goma"}],"hostnames":["api.example.com"],
+     "rules":[{"matches":[{"path":{"type":"PathPrefix","value":"/v1"}}],
+       "filters":[{"type":"URLRewrite","urlRewrite":{"path":{"type":"ReplacePrefixMatch","replacePrefixMatch":"/"}}}],
+       "backendRefs":[{"name":"users","port":80,"weight":3},{"name":"orders","port":8080,"weight":1}]}]}},
+  {"metadata":{"name":"detached","namespace":"default"},
+   "spec":{"parentRefs":[{"name":"other"}],"rules":[{"backendRefs":[{"name":"orders","port":8080}]}]}}
+]`
+)
+
+func TestKubernetesRoutes(t *testing.T) {
+	fake := newFakeKubernetes()
+	fake.set("/api/v1/services", k8sTestServices)
+	fake.set("/apis/discovery.k8s.io/v1/endpointslices", k8sTestEndpointSlices)
+	fake.set("/apis/networking.k8s.io/v1/ingresses", k8sTestIngresses)
+	fake.set("/apis/gateway.networking.k8s.io/v1/httproutes", k8sTestHTTPRoutes)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeConfigFile(t, tokenFile, "test-token\n")
+	config := KubernetesProvider{Endpoint: server.URL, TokenFile: tokenFile, GatewayAPI: true, GatewayName: "goma"}
+	provider := NewKubernetesProvider(config).(*kubernetesProvider)
+	state, err := provider.list(context.Background())
+	if err != nil {
+		t.Fatalf("Error listing objects: %v", err)
+	}
+	routes := state.routes(provider.config)
+	got := map[string]Route{}
+	for _, route := range routes {
+		got[route.Name] = route
+	}
+	// Exact paths are skipped, a prefix route would serve their sub paths
+	if len(routes) != 3 {
+		t.Fatalf("expected 3 routes, got %d: %v", len(routes), routes)
+	}
+	if routes[2].Name != "ingress-default-shop-default" {
+		t.Errorf("expected the default backend route last, got %s", routes[2].Name)
+	}
+
+	users := got["ingress-default-shop-1"]
+	if users.Path != "/users" || strings.Join(users.Hosts, ",") != "shop.example.com" || users.Rewrite != "/" {
+		t.Errorf("unexpected ingress route: %+v", users)
+	}
+	if len(users.Middlewares) != 1 || strings.Join(users.Middlewares[0].Rules, ",") != "basic-auth,api-key" {
+		t.Errorf("expected the annotation middlewares, got %+v", users.Middlewares)
+	}
+	if len(users.Backends) != 2 || users.Backends[0].Destination != "http://10.0.0.1:8080" || users.Backends[1].Destination != "http://10.0.0.2:8080" {
+		t.Errorf("expected the ready endpoints, got %+v", users.Backends)
+	}
+	// Services without endpoints use the service DNS name
+	if backends := got["ingress-default-shop-default"].Backends; len(backends) != 1 || backends[0].Destination != "http://orders.default.svc:8080" {
+		t.Errorf("expected the service DNS name, got %+v", backends)
+	}
+
+	api := got["httproute-default-api-1"]
+	if api.Path != "/v1" || api.Rewrite != "/" || strings.Join(api.Hosts, ",") != "api.example.com" {
+		t.Errorf("unexpected httproute route: %+v", api)
+	}
+	weights := map[string]int{}
+	for _, backend := range api.Backends {
+		weights[backend.Destination] = backend.Weight
+	}
+	if weights["http://10.0.0.1:8080"] != 150 || weights["http://10.0.0.2:8080"] != 150 || weights["http://orders.default.svc:8080"] != 100 {
+		t.Errorf("unexpected backend weights: %v", weights)
+	}
+}
+
+func TestKubernetesProviderWatch(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("users " + r.URL.Path))
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+	address, port, _ := strings.Cut(host, ":")
+
+	fake := newFakeKubernetes()
+	fake.set("/api/v1/namespaces/default/services", k8sTestServices)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeConfigFile(t, tokenFile, "test-token")
+
+	provider := NewKubernetesProvider(KubernetesProvider{Endpoint: server.URL, TokenFile: tokenFile, Namespaces: []string{"default"}}).(*kubernetesProvider)
+	provider.debounce = 10 * time.Millisecond
+	rt := newGatewayRuntime(&GatewayServer{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	configs := make(chan ProviderConfig)
+	go provider.Provide(ctx, configs)
+	next := func() ProviderConfig {
+		t.Helper()
+		select {
+		case config := <-configs:
+			rt.updateProvider(config)
+			return config
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the provider configuration")
+		}
+		return ProviderConfig{}
+	}
+	if config := next(); len(config.Routes) != 0 {
+		t.Fatalf("expected no route, got %v", config.Routes)
+	}
+
+	// Routes are added with the Ingress and the endpoints
+	fake.set("/apis/discovery.k8s.io/v1/namespaces/default/endpointslices", fmt.Sprintf(`[
+  {"metadata":{"name":"users-abc","namespace":"default","labels":{"kubernetes.io/service-name":"users"}},
+   "endpoints":[{"addresses":[%q]}],"ports":[{"name":"http","port":%s}]}]`, address, port))
+	fake.set("/apis/networking.k8s.io/v1/namespaces/default/ingresses", `[
+  {"metadata":{"name":"shop","namespace":"default"},
+   "spec":{"ingressClassName":"goma","rules":[{"host":"shop.example.com","http":{"paths":[
+     {"path":"/users","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}}]`)
+	for config := next(); len(config.Routes) == 0 || len(config.Routes[0].Backends) == 0; config = next() {
+	}
+	request := func(host string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		r.Host = host
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, r)
+		return rec
+	}
+	if rec := request("shop.example.com"); rec.Code != http.StatusOK || rec.Body.String() != "users /users/1" {
+		t.Errorf("expected the ingress route, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := request("other.example.com"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for another host, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Ingresses of other classes don't change the routes
+	fake.set("/apis/networking.k8s.io/v1/namespaces/default/ingresses", `[
+  {"metadata":{"name":"shop","namespace":"default"},
+   "spec":{"ingressClassName":"goma","rules":[{"host":"shop.example.com","http":{"paths":[
+     {"path":"/users","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}},
+  {"metadata":{"name":"other","namespace":"default"},
+   "spec":{"ingressClassName":"nginx","rules":[{"http":{"paths":[{"path":"/","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}}]`)
+	select {
+	case config := <-configs:
+		t.Errorf("expected no configuration for an Ingress of another class, got %v", config.Routes)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Routes are removed with the Ingress
+	fake.set("/apis/networking.k8s.io/v1/namespaces/default/ingresses", "[]")
+	if config := next(); len(config.Routes) != 0 {
+		t.Errorf("expected no route, got %v", config.Routes)
+	}
+	if rec := request("shop.example.com"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d after the Ingress removal, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Objects are listed again when the resource version of a collection expires
+	fake.mu.Lock()
+	lists := fake.lists
+	fake.mu.Unlock()
+	fake.expire("/apis/networking.k8s.io/v1/namespaces/default/ingresses")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		relisted := fake.lists > lists
+		fake.mu.Unlock()
+		if relisted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the objects to be listed again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fake.set("/apis/networking.k8s.io/v1/namespaces/default/ingresses", `[
+  {"metadata":{"name":"shop","namespace":"default"},
+   "spec":{"ingressClassName":"goma","rules":[{"host":"shop.example.com","http":{"paths":[
+     {"path":"/users","backend":{"service":{"name":"users","port":{"name":"http"}}}}]}}]}}]`)
+	if config := next(); len(config.Routes) != 1 {
+		t.Errorf("expected the Ingress route, got %v", config.Routes)
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mirrorMetrics contains the traffic mirroring metrics by route name
+var mirrorMetrics sync.Map
+
+// MirrorMetrics records the traffic mirroring metrics of a route
+type MirrorMetrics struct {
+	// Requests is the number of mirrored requests
+	Requests atomic.Int64
+	// Errors is the number of failed mirrored requests
+	Errors atomic.Int64
+	// Skipped is the number of requests not mirrored, body too large or too many pending requests
+	Skipped atomic.Int64
+	// latency is the total latency of mirrored requests, in nanoseconds
+	latency atomic.Int64
+}
+
+// MirrorMetricsSnapshot is a point-in-time copy of MirrorMetrics
+type MirrorMetricsSnapshot struct {
+	Route          string  `json:"route"`
+	Requests       int64   `json:"requests"`
+	Errors         int64   `json:"errors"`
+	Skipped        int64   `json:"skipped"`
+	AverageLatency float64 `json:"averageLatencyMs"`
+}
+
+// getMirrorMetrics returns the route mirror metrics, they are created if they do not exist
+func getMirrorMetrics(route string) *MirrorMetrics {
+	m, _ := mirrorMetrics.LoadOrStore(route, &MirrorMetrics{})
+	return m.(*MirrorMetrics)
+}
+
+// observe records a mirrored request
+func (m *MirrorMetrics) observe(duration time.Duration, err error) {
+	m.Requests.Add(1)
+	m.latency.Add(int64(duration))
+	if err != nil {
+		m.Errors.Add(1)
+	}
+}
+
+// GetMirrorMetrics returns the traffic mirroring metrics of all routes
+func GetMirrorMetrics() []MirrorMetricsSnapshot {
+	var snapshots []MirrorMetricsSnapshot
+	mirrorMetrics.Range(func(key, value any) bool {
+		m := value.(*MirrorMetrics)
+		snapshot := MirrorMetricsSnapshot{
+			Route:    key.(string),
+			Requests: m.Requests.Load(),
+			Errors:   m.Errors.Load(),
+			Skipped:  m.Skipped.Load(),
+		}
+		if snapshot.Requests > 0 {
+			snapshot.AverageLatency = float64(m.latency.Load()) / float64(snapshot.Requests) / float64(time.Millisecond)
+		}
+		snapshots = append(snapshots, snapshot)
+		return true
+	})
+	return snapshots
+}
@@ -1,21 +1,261 @@
 package pkg
 
 import (
-	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"net/http"
 	"slices"
 	"strings"
+	"time"
 )
 
-func searchMiddleware(rules []string, middlewares []Middleware) (Middleware, error) {
-	for _, m := range middlewares {
-		if slices.Contains(rules, m.Name) {
-			return m, nil
+// findMiddlewares returns the middlewares in the rules declared order and the names not found
+func findMiddlewares(rules []string, middlewares []Middleware) ([]Middleware, []string) {
+	var found []Middleware
+	var missing []string
+	for _, rule := range rules {
+		index := slices.IndexFunc(middlewares, func(m Middleware) bool { return m.Name == rule })
+		if index < 0 {
+			missing = append(missing, rule)
+			continue
 		}
-		continue
+		found = append(found, middlewares[index])
 	}
+	return found, missing
+}
+
+// middlewareChain builds the route path middlewares.
+//
+// With the allOf mode, every middleware is applied in declared order.
+// With the anyOf mode, the request is allowed when one of the middlewares allows it.
+// Rules naming a middleware not found are an error, the path must not be exposed without the missing check.
+// Stateful middlewares are reused from the instances of the previous generation.
+func middlewareChain(route Route, mid RouteMiddleware, middlewares []Middleware, instances *middlewareInstances) ([]mux.MiddlewareFunc, error) {
+	found, missing := findMiddlewares(mid.Rules, middlewares)
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("middlewares not found: %s", strings.Join(missing, ", "))
+	}
+	var chain []mux.MiddlewareFunc
+	for _, m := range found {
+		mw, err := instances.build(route, m)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", m.Name, err)
+		}
+		chain = append(chain, mw)
+	}
+	switch mid.Mode {
+	case "", MiddlewareModeAllOf:
+		return chain, nil
+	case MiddlewareModeAnyOf:
+		if len(chain) == 0 {
+			return nil, nil
+		}
+		return []mux.MiddlewareFunc{middleware.AnyOf(chain...)}, nil
+	default:
+		return nil, fmt.Errorf("unknown middleware mode %s", mid.Mode)
+	}
+}
 
-	return Middleware{}, errors.New("no middleware found with name " + strings.Join(rules, ";"))
+// describeChain returns the route path middlewares chain displayed on start
+func describeChain(mid RouteMiddleware, middlewares []Middleware) string {
+	found, missing := findMiddlewares(mid.Rules, middlewares)
+	var names []string
+	for _, m := range found {
+		names = append(names, m.Name)
+	}
+	for _, name := range missing {
+		names = append(names, name+" (not found)")
+	}
+	if mid.Mode == MiddlewareModeAnyOf {
+		return fmt.Sprintf("anyOf(%s)", strings.Join(names, " | "))
+	}
+	return strings.Join(names, " -> ")
+}
+
+// unavailableMiddleware rejects all requests
+func unavailableMiddleware(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+	})
+}
+
+// buildMiddleware creates the middleware from its type and rule, and the function releasing its resources when it has any
+func buildMiddleware(route Route, m Middleware) (mux.MiddlewareFunc, func(), error) {
+	switch m.Type {
+	case "basic":
+		basicAuth, err := ToBasicAuth(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		users, err := basicUsers(basicAuth)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := middleware.AuthBasic{
+			Username:   basicAuth.Username,
+			Password:   basicAuth.Password,
+			Users:      users,
+			Realm:      basicAuth.Realm,
+			UserHeader: basicAuth.UserHeader,
+			Headers:    nil,
+			Params:     nil,
+		}
+		return amw.AuthMiddleware, nil, nil
+	case "jwt", "forwardAuth":
+		jwt, err := ToJWTRuler(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := &middleware.AuthJWT{
+			AuthURL:         jwt.URL,
+			RequiredHeaders: jwt.RequiredHeaders,
+			Headers:         jwt.Headers,
+			Params:          jwt.Params,
+			ForwardHeaders:  jwt.ForwardHeaders,
+			ResponseHeaders: jwt.ResponseHeaders,
+			Timeout:         time.Duration(jwt.Timeout) * time.Second,
+			CacheTTL:        time.Duration(jwt.CacheTTL) * time.Second,
+		}
+		amw.Init()
+		return amw.AuthMiddleware, nil, nil
+	case "access":
+		accessRule, err := ToAccessRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		allow, err := middleware.ParseCIDRs(accessRule.Allow)
+		if err != nil {
+			return nil, nil, err
+		}
+		deny, err := middleware.ParseCIDRs(accessRule.Deny)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := middleware.AccessListMiddleware{
+			Allow: allow,
+			Deny:  deny,
+		}
+		return amw.AccessMiddleware, nil, nil
+	case "apiKey":
+		apiKeyRule, err := ToAPIKeyRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := &middleware.AuthAPIKey{
+			Route:      []string{route.Name, route.Path},
+			HeaderName: apiKeyRule.Header,
+			QueryName:  apiKeyRule.Query,
+			CookieName: apiKeyRule.Cookie,
+			Headers:    apiKeyRule.Headers,
+			KeysFile:   apiKeyRule.KeysFile,
+		}
+		for _, key := range apiKeyRule.Keys {
+			amw.Keys = append(amw.Keys, middleware.APIKey(key))
+		}
+		if err := amw.Load(); err != nil {
+			return nil, nil, err
+		}
+		return amw.AuthMiddleware, amw.Close, nil
+	case "oauth2Introspect":
+		introspectRule, err := ToOAuth2IntrospectRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := &middleware.OAuth2Introspect{
+			URL:              introspectRule.URL,
+			ClientID:         introspectRule.ClientID,
+			ClientSecret:     introspectRule.ClientSecret,
+			AuthMethod:       introspectRule.AuthMethod,
+			RequiredScopes:   introspectRule.RequiredScopes,
+			Audiences:        introspectRule.Audiences,
+			CacheTTL:         time.Duration(introspectRule.CacheTTL) * time.Second,
+			NegativeCacheTTL: time.Duration(introspectRule.NegativeCacheTTL) * time.Second,
+			Timeout:          time.Duration(introspectRule.Timeout) * time.Second,
+			Headers:          introspectRule.Headers,
+		}
+		amw.Init()
+		return amw.AuthMiddleware, nil, nil
+	case "oidc":
+		oidcRule, err := ToOIDCRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := &middleware.OIDC{
+			Issuer:                oidcRule.Issuer,
+			ClientID:              oidcRule.ClientID,
+			ClientSecret:          oidcRule.ClientSecret,
+			RedirectURL:           oidcRule.RedirectURL,
+			Scopes:                oidcRule.Scopes,
+			CookieName:            oidcRule.CookieName,
+			CookieSecret:          oidcRule.CookieSecret,
+			SessionTTL:            time.Duration(oidcRule.SessionTTL) * time.Second,
+			LogoutPath:            oidcRule.LogoutPath,
+			PostLogoutRedirectURL: oidcRule.PostLogoutRedirectURL,
+			AllowedDomains:        oidcRule.AllowedDomains,
+			AllowedGroups:         oidcRule.AllowedGroups,
+			GroupsClaim:           oidcRule.GroupsClaim,
+			Headers:               oidcRule.Headers,
+		}
+		if err := amw.Init(); err != nil {
+			return nil, nil, err
+		}
+		return amw.AuthMiddleware, nil, nil
+	case "signature":
+		signatureRule, err := ToSignatureRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := &middleware.Signature{
+			Secret:          signatureRule.Secret,
+			Algorithm:       signatureRule.Algorithm,
+			Header:          signatureRule.Header,
+			Format:          signatureRule.Format,
+			Prefix:          signatureRule.Prefix,
+			Encoding:        signatureRule.Encoding,
+			TimestampHeader: signatureRule.TimestampHeader,
+			Payload:         signatureRule.Payload,
+			Tolerance:       time.Duration(signatureRule.Tolerance) * time.Second,
+			MaxBodySize:     signatureRule.MaxBodySize,
+		}
+		if err := amw.Init(); err != nil {
+			return nil, nil, err
+		}
+		return amw.AuthMiddleware, nil, nil
+	case "mtls":
+		mTLSRule, err := ToMTLSRule(m.Rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		amw := middleware.MTLS{
+			Subjects: mTLSRule.Subjects,
+			Headers:  mTLSRule.Headers,
+		}
+		return amw.AuthMiddleware, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown middleware type %s", m.Type)
+	}
+}
+
+// basicUsers returns the basic auth users from inline entries and the htpasswd file
+func basicUsers(basicAuth BasicRule) (map[string]string, error) {
+	users, err := middleware.ParseHtpasswd(basicAuth.Users)
+	if err != nil {
+		return nil, err
+	}
+	if basicAuth.HtpasswdFile != "" {
+		fileUsers, err := middleware.ReadHtpasswdFile(basicAuth.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		for username, hash := range fileUsers {
+			if _, ok := users[username]; ok {
+				return nil, fmt.Errorf("duplicate user %s in %s", username, basicAuth.HtpasswdFile)
+			}
+			users[username] = hash
+		}
+	}
+	return users, nil
 }
 
 type RoutePath struct {
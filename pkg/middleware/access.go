@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/jkaninda/goma/internal/logger"
+	"net"
+	"net/http"
+)
+
+// AccessMiddleware allows or denies requests by client IP and returns 403 Forbidden.
+//
+// Deny takes precedence over Allow, all IPs are allowed when Allow is empty
+func (accessList AccessListMiddleware) AccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := ClientIP(r)
+		ip := net.ParseIP(clientIP)
+		if ip == nil || containsIP(accessList.Deny, ip) || (len(accessList.Allow) != 0 && !containsIP(accessList.Allow, ip)) {
+			logger.Error("Access to %s is forbidden for %s", r.URL.Path, clientIP)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			err := json.NewEncoder(w).Encode(ProxyResponseError{
+				Success: false,
+				Code:    http.StatusForbidden,
+				Message: "Access denied",
+			})
+			if err != nil {
+				return
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
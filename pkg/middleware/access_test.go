@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("Error parsing trusted proxies: %v", err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "untrusted remote", remoteAddr: "203.0.113.7:4000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "203.0.113.7"},
+		{name: "x-forwarded-for", remoteAddr: "10.0.0.2:4000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.9, 10.0.0.3"}, want: "203.0.113.9"},
+		{name: "x-real-ip", remoteAddr: "10.0.0.2:4000", headers: map[string]string{"X-Real-IP": "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forwarded", remoteAddr: "[fd00::1]:4000", headers: map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.3`}, want: "2001:db8::1"},
+		{name: "no header", remoteAddr: "10.0.0.2:4000", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := ResolveClientIP(r, trusted); got != tt.want {
+				t.Errorf("expected client IP %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	allow, _ := ParseCIDRs([]string{"192.168.0.0/16", "2001:db8::/32"})
+	deny, _ := ParseCIDRs([]string{"192.168.1.10"})
+	handler := AccessListMiddleware{Allow: allow, Deny: deny}.AccessMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := map[string]int{
+		"192.168.2.1:80":    http.StatusOK,
+		"[2001:db8::5]:80":  http.StatusOK,
+		"192.168.1.10:80":   http.StatusForbidden,
+		"203.0.113.7:80":    http.StatusForbidden,
+		"[2001:db9::5]:443": http.StatusForbidden,
+	}
+	for remoteAddr, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("%s: expected status code %d, got %d", remoteAddr, want, rec.Code)
+		}
+	}
+}
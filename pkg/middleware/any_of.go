@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// AnyOf combines middlewares, the request is allowed when one of them allows it.
+//
+// Middlewares are tried in order with a copy of the request.
+// When all of them deny the request, the response of the last middleware is returned.
+func AnyOf(middlewares ...mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var denied *bufferedResponse
+			for _, mw := range middlewares {
+				var allowed *http.Request
+				buffered := &bufferedResponse{header: http.Header{}}
+				attempt := r.Clone(r.Context())
+				mw(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					allowed = r
+				})).ServeHTTP(buffered, attempt)
+				// Keep the body restored by the middleware for the next attempt
+				r.Body = attempt.Body
+				if allowed != nil {
+					// Keep the headers set by the middleware, e.g. cookies
+					for name, values := range buffered.header {
+						w.Header()[name] = values
+					}
+					next.ServeHTTP(w, allowed)
+					return
+				}
+				denied = buffered
+			}
+			if denied == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			denied.writeTo(w)
+		})
+	}
+}
+
+// bufferedResponse holds a middleware response until the middleware decision is known
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.status == 0 {
+		b.status = statusCode
+	}
+}
+
+// writeTo writes the buffered response
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
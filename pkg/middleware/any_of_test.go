@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnyOf(t *testing.T) {
+	headerAuth := func(header, user string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(header) == "" {
+					w.Header().Set("WWW-Authenticate", header)
+					respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+				r.Header.Set("X-Auth-User", user)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	var user string
+	handler := AnyOf(headerAuth("X-Api-Key", "api"), headerAuth("Authorization", "jwt"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = r.Header.Get("X-Auth-User")
+	}))
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+		wantUser string
+	}{
+		{name: "first allows", header: "X-Api-Key", wantCode: http.StatusOK, wantUser: "api"},
+		{name: "second allows", header: "Authorization", wantCode: http.StatusOK, wantUser: "jwt"},
+		{name: "all deny", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user = ""
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, "value")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode || user != tt.wantUser {
+				t.Errorf("expected %d and user %q, got %d and user %q", tt.wantCode, tt.wantUser, rec.Code, user)
+			}
+			if tt.wantCode == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Authorization" {
+				t.Errorf("expected the last middleware response, got %v", rec.Header())
+			}
+		})
+	}
+}
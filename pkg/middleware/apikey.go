@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"gopkg.in/yaml.v3"
+	"hash"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiKeysReloadInterval defines how often the keys file is checked for changes
+var apiKeysReloadInterval = 5 * time.Second
+
+type apiKey struct {
+	APIKey
+	algorithm func() hash.Hash
+	hash      []byte
+}
+
+// Load parses the API keys and starts watching the keys file for changes
+func (amw *AuthAPIKey) Load() error {
+	if amw.HeaderName == "" && amw.QueryName == "" && amw.CookieName == "" {
+		amw.HeaderName = "X-API-Key"
+	}
+	if len(amw.Headers) == 0 {
+		amw.Headers = map[string]string{
+			"consumer": "X-Consumer-Name",
+			"tier":     "X-Consumer-Tier",
+		}
+	}
+	configKeys, err := parseAPIKeys(amw.Keys)
+	if err != nil {
+		return err
+	}
+	amw.keys = configKeys
+	if amw.KeysFile != "" {
+		modTime, err := amw.loadKeysFile(configKeys)
+		if err != nil {
+			return err
+		}
+		amw.stop = make(chan struct{})
+		go amw.watchKeysFile(configKeys, modTime)
+	}
+	return nil
+}
+
+// Close stops watching the keys file, the middleware is no longer served
+func (amw *AuthAPIKey) Close() {
+	amw.closeOnce.Do(func() {
+		if amw.stop != nil {
+			close(amw.stop)
+		}
+	})
+}
+
+// parseAPIKeys decodes the API key hashes
+func parseAPIKeys(keys []APIKey) ([]apiKey, error) {
+	var parsed []apiKey
+	for _, key := range keys {
+		algorithm, value := sha256.New, key.Hash
+		if v, ok := strings.CutPrefix(key.Hash, "sha256:"); ok {
+			value = v
+		} else if v, ok := strings.CutPrefix(key.Hash, "sha512:"); ok {
+			algorithm, value = sha512.New, v
+		}
+		decoded, err := hex.DecodeString(value)
+		if err != nil || len(decoded) != algorithm().Size() {
+			return nil, fmt.Errorf("consumer %s: invalid API key hash", key.Consumer)
+		}
+		parsed = append(parsed, apiKey{APIKey: key, algorithm: algorithm, hash: decoded})
+	}
+	return parsed, nil
+}
+
+// loadKeysFile loads the keys file, config keys are kept
+func (amw *AuthAPIKey) loadKeysFile(configKeys []apiKey) (time.Time, error) {
+	info, err := os.Stat(amw.KeysFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading API keys file: %w", err)
+	}
+	buf, err := os.ReadFile(amw.KeysFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading API keys file: %w", err)
+	}
+	file := struct {
+		Keys []APIKey `yaml:"keys"`
+	}{}
+	if err = yaml.Unmarshal(buf, &file); err != nil {
+		return time.Time{}, fmt.Errorf("error parsing API keys file %s: %w", amw.KeysFile, err)
+	}
+	fileKeys, err := parseAPIKeys(file.Keys)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("in file %s: %w", amw.KeysFile, err)
+	}
+	amw.mu.Lock()
+	amw.keys = append(slices.Clip(configKeys), fileKeys...)
+	amw.mu.Unlock()
+	return info.ModTime(), nil
+}
+
+// watchKeysFile reloads the keys file when it changes, until the middleware is closed
+func (amw *AuthAPIKey) watchKeysFile(configKeys []apiKey, modTime time.Time) {
+	ticker := time.NewTicker(apiKeysReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-amw.stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(amw.KeysFile)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		newModTime, err := amw.loadKeysFile(configKeys)
+		if err != nil {
+			logger.Error("Error reloading API keys: %v", err)
+			// Retry on the next change only
+			modTime = info.ModTime()
+			continue
+		}
+		modTime = newModTime
+		logger.Info("API keys file %s reloaded", amw.KeysFile)
+	}
+}
+
+// lookup returns the key matching the presented value, using constant-time comparison
+func (amw *AuthAPIKey) lookup(value string) *apiKey {
+	amw.mu.RLock()
+	defer amw.mu.RUnlock()
+	var found *apiKey
+	sums := map[int][]byte{}
+	for i := range amw.keys {
+		key := &amw.keys[i]
+		size := len(key.hash)
+		sum, ok := sums[size]
+		if !ok {
+			h := key.algorithm()
+			h.Write([]byte(value))
+			sum = h.Sum(nil)
+			sums[size] = sum
+		}
+		if subtle.ConstantTimeCompare(sum, key.hash) == 1 && found == nil {
+			found = key
+		}
+	}
+	return found
+}
+
+// presentedKey returns the API key of the request
+func (amw *AuthAPIKey) presentedKey(r *http.Request) string {
+	if amw.HeaderName != "" {
+		if value := r.Header.Get(amw.HeaderName); value != "" {
+			return value
+		}
+	}
+	if amw.QueryName != "" {
+		if value := r.URL.Query().Get(amw.QueryName); value != "" {
+			return value
+		}
+	}
+	if amw.CookieName != "" {
+		if cookie, err := r.Cookie(amw.CookieName); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// AuthMiddleware checks the request API key and injects its metadata to the backend request
+func (amw *AuthAPIKey) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := amw.presentedKey(r)
+		if value == "" {
+			logger.Error("Proxy error, missing API key")
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		key := amw.lookup(value)
+		if key == nil {
+			logger.Error("Proxy error, invalid API key")
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if len(key.Routes) != 0 && !slices.ContainsFunc(amw.Route, func(route string) bool {
+			return slices.Contains(key.Routes, route)
+		}) {
+			logger.Error("Proxy error, consumer %s is not allowed to access %s", key.Consumer, r.URL.Path)
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		metadata := map[string]string{
+			"consumer": key.Consumer,
+			"tier":     key.Tier,
+		}
+		for k, v := range key.Metadata {
+			metadata[k] = v
+		}
+		for k, header := range amw.Headers {
+			// Values coming from the client are never trusted
+			r.Header.Del(header)
+			if value, ok := metadata[k]; ok && value != "" {
+				r.Header.Set(header, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
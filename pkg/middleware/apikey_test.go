@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	apiKeysReloadInterval = 10 * time.Millisecond
+	keysFile := filepath.Join(t.TempDir(), "keys.yml")
+	if err := os.WriteFile(keysFile, []byte("keys: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	amw := &AuthAPIKey{
+		Route:      []string{"Store", "/store"},
+		HeaderName: "X-API-Key",
+		QueryName:  "api_key",
+		Keys: []APIKey{
+			{Consumer: "partner", Hash: hashKey("partner-key"), Tier: "gold"},
+			{Consumer: "other", Hash: hashKey("other-key"), Routes: []string{"Payment"}},
+		},
+		KeysFile: keysFile,
+	}
+	if err := amw.Load(); err != nil {
+		t.Fatalf("Error loading API keys: %v", err)
+	}
+	var consumer, tier string
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consumer, tier = r.Header.Get("X-Consumer-Name"), r.Header.Get("X-Consumer-Tier")
+	}))
+	serve := func(target string, header string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			r.Header.Set("X-API-Key", header)
+		}
+		r.Header.Set("X-Consumer-Name", "spoofed")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := serve("/store", "partner-key"); code != http.StatusOK || consumer != "partner" || tier != "gold" {
+		t.Errorf("unexpected response %d, consumer=%q tier=%q", code, consumer, tier)
+	}
+	if code := serve("/store?api_key=partner-key", ""); code != http.StatusOK {
+		t.Errorf("expected query key to be accepted, got %d", code)
+	}
+	if code := serve("/store", "wrong-key"); code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := serve("/store", "other-key"); code != http.StatusForbidden {
+		t.Errorf("expected status code %d, got %d", http.StatusForbidden, code)
+	}
+	// Keys file is reloaded on change
+	content := "keys:\n  - consumer: file\n    hash: '" + hashKey("file-key") + "'\n"
+	if err := os.WriteFile(keysFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keysFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for serve("/store", "file-key") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("keys file was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Closed middlewares stop watching the keys file
+	amw.Close()
+	amw.Close()
+	if err := os.WriteFile(keysFile, []byte("keys: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(keysFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if code := serve("/store", "file-key"); code != http.StatusOK {
+		t.Errorf("expected the keys file not to be reloaded after Close, got %d", code)
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "clientIP"
+
+// ParseCIDRs parses a list of IPs and CIDR ranges, IPv4 and IPv6.
+//
+// A single IP is converted to a /32 or /128 range
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+			}
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// containsIP checks if one of the networks contains the IP
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPMiddleware resolves the client IP and stores it in the request context.
+//
+// Forwarded, X-Forwarded-For and X-Real-IP headers are only used when the request comes from a trusted proxy,
+// they are removed from the other requests and never reach the backends
+func ClientIPMiddleware(trustedProxies []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ResolveClientIP(r, trustedProxies)
+			if !isTrusted(remoteIP(r), trustedProxies) {
+				r.Header.Del("Forwarded")
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("X-Real-IP")
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		})
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware, or the request remote address
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok && ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// ResolveClientIP returns the client IP using the trusted proxies
+func ResolveClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote := remoteIP(r)
+	if !isTrusted(remote, trustedProxies) {
+		return remote
+	}
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) != 0 {
+		chain = parseForwarded(forwarded)
+	} else if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) != 0 {
+		for _, value := range forwardedFor {
+			for _, ip := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(ip))
+			}
+		}
+	} else if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		chain = []string{realIP}
+	}
+	// The right-most untrusted address is the client
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(stripPort(chain[i]))
+		if ip == nil {
+			// Unknown or obfuscated identifier, stop at the last trusted hop
+			break
+		}
+		if !isTrusted(ip.String(), trustedProxies) || i == 0 {
+			return ip.String()
+		}
+	}
+	return remote
+}
+
+// parseForwarded returns the "for" addresses of Forwarded headers, RFC 7239
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, strings.Trim(val, `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// stripPort removes the port and the IPv6 brackets of an address
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
+
+// remoteIP returns the request remote address without its port
+func remoteIP(r *http.Request) string {
+	return stripPort(r.RemoteAddr)
+}
+
+func isTrusted(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && containsIP(trustedProxies, ip)
+}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// forwardAuthCacheSize triggers the removal of expired cache entries
+const forwardAuthCacheSize = 10000
+
+// forwardAuthMaxBody limits the auth service response body relayed to the client
+const forwardAuthMaxBody = 64 << 10
+
+// defaultForwardAuthResponseHeaders are relayed to the client when no response headers are defined
+var defaultForwardAuthResponseHeaders = []string{"WWW-Authenticate", "Location", "Set-Cookie"}
+
+// forwardAuthEntry is an auth service decision
+type forwardAuthEntry struct {
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// Init sets the default values
+func (amw *AuthJWT) Init() {
+	if amw.Timeout <= 0 {
+		amw.Timeout = 10 * time.Second
+	}
+	if len(amw.ResponseHeaders) == 0 {
+		amw.ResponseHeaders = defaultForwardAuthResponseHeaders
+	}
+	amw.cache = map[string]forwardAuthEntry{}
+	amw.client = &http.Client{
+		Timeout: amw.Timeout,
+		// Redirects are relayed to the client
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// AuthMiddleware function, which will be called for each request
+func (amw *AuthJWT) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range amw.RequiredHeaders {
+			if r.Header.Get(header) == "" {
+				logger.Error("Proxy error, missing %s header", header)
+				respondWithError(w, http.StatusForbidden, fmt.Sprintf("Missing %s header", header))
+				return
+			}
+		}
+		entry, err := amw.authorize(r)
+		if err != nil {
+			logger.Error("Proxy error, authentication request failed: %v", err)
+			respondWithError(w, http.StatusBadGateway, "Authorization service unavailable")
+			return
+		}
+		switch {
+		case entry.status >= 200 && entry.status < 300:
+		case entry.status == http.StatusUnauthorized, entry.status == http.StatusForbidden,
+			entry.status >= 300 && entry.status < 400:
+			logger.Error("Proxy authentication error, auth service returned status code %d", entry.status)
+			// Relay the auth service response
+			for _, name := range amw.ResponseHeaders {
+				for _, value := range entry.header.Values(name) {
+					w.Header().Add(name, value)
+				}
+			}
+			if contentType := entry.header.Get("Content-Type"); contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(entry.status)
+			_, _ = w.Write(entry.body)
+			return
+		default:
+			logger.Error("Proxy authentication error, auth service returned status code %d", entry.status)
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		// Inject specific header tp the current request's header
+		// Add header to the next request from AuthRequest header, depending on your requirements
+		for k, v := range amw.Headers {
+			r.Header.Set(v, entry.header.Get(k))
+		}
+		// Add query parameters to the next request from AuthRequest header, depending on your requirements
+		if len(amw.Params) != 0 {
+			query := r.URL.Query()
+			for k, v := range amw.Params {
+				query.Set(v, entry.header.Get(k))
+			}
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize returns the cached decision or sends the request to the auth service
+func (amw *AuthJWT) authorize(r *http.Request) (forwardAuthEntry, error) {
+	key := amw.cacheKey(r)
+	now := time.Now()
+	if key != "" {
+		amw.mu.Lock()
+		entry, ok := amw.cache[key]
+		amw.mu.Unlock()
+		if ok && now.Before(entry.expiresAt) {
+			return entry, nil
+		}
+	}
+	authReq, err := http.NewRequest(http.MethodGet, amw.AuthURL, nil)
+	if err != nil {
+		return forwardAuthEntry{}, err
+	}
+	// Copy headers from the original request to the auth request
+	if len(amw.ForwardHeaders) == 0 {
+		for name, values := range r.Header {
+			for _, value := range values {
+				authReq.Header.Add(name, value)
+			}
+		}
+	} else {
+		for _, name := range amw.ForwardHeaders {
+			for _, value := range r.Header.Values(name) {
+				authReq.Header.Add(name, value)
+			}
+		}
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	authReq.Header.Set("X-Forwarded-Method", r.Method)
+	authReq.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	authReq.Header.Set("X-Forwarded-Host", r.Host)
+	authReq.Header.Set("X-Forwarded-Proto", proto)
+	authReq.Header.Set("X-Forwarded-For", ClientIP(r))
+	authResp, err := amw.client.Do(authReq)
+	if err != nil {
+		return forwardAuthEntry{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+		}
+	}(authResp.Body)
+	entry := forwardAuthEntry{status: authResp.StatusCode, header: authResp.Header}
+	if authResp.StatusCode >= 300 {
+		entry.body, err = io.ReadAll(io.LimitReader(authResp.Body, forwardAuthMaxBody))
+		if err != nil {
+			return forwardAuthEntry{}, err
+		}
+	}
+	// Redirects usually depend on the request URI, they are not cached
+	if key != "" && (authResp.StatusCode < 300 || authResp.StatusCode >= 400) {
+		entry.expiresAt = now.Add(amw.CacheTTL)
+		amw.mu.Lock()
+		if len(amw.cache) >= forwardAuthCacheSize {
+			for k, v := range amw.cache {
+				if !now.Before(v.expiresAt) {
+					delete(amw.cache, k)
+				}
+			}
+		}
+		if len(amw.cache) < forwardAuthCacheSize {
+			amw.cache[key] = entry
+		}
+		amw.mu.Unlock()
+	}
+	return entry, nil
+}
+
+// cacheKey returns a hash of the request token, the Authorization header or the cookies,
+// and of everything the auth service receives: the method, host, URI, client IP and forwarded headers.
+//
+// It returns an empty key when the cache is disabled or the request has no token.
+func (amw *AuthJWT) cacheKey(r *http.Request) string {
+	if amw.CacheTTL <= 0 || amw.cache == nil {
+		return ""
+	}
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.Header.Get("Cookie")
+	}
+	if token == "" {
+		return ""
+	}
+	hash := sha256.New()
+	write := func(values ...string) {
+		for _, value := range values {
+			hash.Write([]byte(value))
+			hash.Write([]byte{0})
+		}
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	write(token, r.Method, r.Host, r.URL.RequestURI(), proto, ClientIP(r))
+	names := append(append([]string(nil), amw.ForwardHeaders...), amw.RequiredHeaders...)
+	if len(amw.ForwardHeaders) == 0 {
+		// All headers are sent to the auth service
+		names = names[:0]
+		for name := range r.Header {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		write(http.CanonicalHeaderKey(name))
+		write(r.Header.Values(name)...)
+	}
+	return string(hash.Sum(nil))
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForwardAuth(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Forwarded-Method") != http.MethodPost || r.Header.Get("X-Forwarded-Uri") != "/orders?page=2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("X-Ignored") != "" || len(r.Header.Values("X-Tenant")) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			w.Header().Set("X-Auth-UserId", "42")
+		case "Bearer guest":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":"read only"}`))
+		case "":
+			w.Header().Set("Location", "https://auth.example.com/login")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.Header().Set("X-Internal", "secret")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	amw := &AuthJWT{
+		AuthURL:        server.URL,
+		Headers:        map[string]string{"X-Auth-UserId": "X-User-Id"},
+		Params:         map[string]string{"X-Auth-UserId": "userId"},
+		ForwardHeaders: []string{"Authorization", "X-Tenant"},
+		CacheTTL:       time.Minute,
+	}
+	amw.Init()
+	var userId, query string
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId = r.Header.Get("X-User-Id")
+		query = r.URL.Query().Get("userId")
+	}))
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/orders?page=2", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.Header.Add("X-Tenant", "a")
+		r.Header.Add("X-Tenant", "b")
+		r.Header.Set("X-Ignored", "value")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	if rec := serve("valid"); rec.Code != http.StatusOK || userId != "42" || query != "42" {
+		t.Errorf("unexpected response %d, user id %q, query %q", rec.Code, userId, query)
+	}
+	if rec := serve("valid"); rec.Code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("expected cached decision, got %d and %d calls", rec.Code, calls.Load())
+	}
+	rec := serve("guest")
+	if rec.Code != http.StatusForbidden || rec.Body.String() != `{"error":"read only"}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected relayed forbidden response, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = serve("expired")
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" || rec.Header().Get("X-Internal") != "" {
+		t.Errorf("expected relayed unauthorized response, got %d %v", rec.Code, rec.Header())
+	}
+	rec = serve("")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://auth.example.com/login" {
+		t.Errorf("expected relayed redirect, got %d %v", rec.Code, rec.Header())
+	}
+	serve("")
+	if calls.Load() != 5 {
+		t.Errorf("expected redirects not to be cached, got %d calls", calls.Load())
+	}
+}
+
+func TestForwardAuthUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	amw := &AuthJWT{AuthURL: server.URL, Timeout: 50 * time.Millisecond}
+	amw.Init()
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected status code %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
+
+func TestForwardAuthCacheKey(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// The token is only allowed to read the public path
+		if r.Header.Get("X-Forwarded-Method") != http.MethodGet || r.Header.Get("X-Forwarded-Uri") != "/public" ||
+			r.Header.Get("X-Tenant") != "a" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	amw := &AuthJWT{AuthURL: server.URL, ForwardHeaders: []string{"Authorization", "X-Tenant"}, CacheTTL: time.Minute}
+	amw.Init()
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, path, tenant string) int {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer token")
+		r.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := serve(http.MethodGet, "/public", "a"); code != http.StatusOK {
+		t.Fatalf("expected the public path to be allowed, got %d", code)
+	}
+	// The cached decision must not be replayed for another method, path or forwarded header
+	for _, tt := range []struct{ method, path, tenant string }{
+		{http.MethodDelete, "/admin", "a"},
+		{http.MethodGet, "/admin", "a"},
+		{http.MethodDelete, "/public", "a"},
+		{http.MethodGet, "/public", "b"},
+	} {
+		if code := serve(tt.method, tt.path, tt.tenant); code != http.StatusForbidden {
+			t.Errorf("%s %s tenant %s: expected forbidden, got %d", tt.method, tt.path, tt.tenant, code)
+		}
+	}
+	if code := serve(http.MethodGet, "/public", "a"); code != http.StatusOK || calls.Load() != 5 {
+		t.Errorf("expected the cached decision, got %d and %d calls", code, calls.Load())
+	}
+}
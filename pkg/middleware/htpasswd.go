@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+)
+
+// Password hash algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+	HashSHA256   = "sha256"
+	HashSHA512   = "sha512"
+)
+
+// argon2id default parameters
+const (
+	argon2Memory  = 64 * 1024
+	argon2Time    = 3
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// HashPassword returns the password hash, in htpasswd format
+func HashPassword(algorithm, password string) (string, error) {
+	switch algorithm {
+	case HashBcrypt, "":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case HashSHA256:
+		sum := sha256.Sum256([]byte(password))
+		return "{SHA256}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	case HashSHA512:
+		sum := sha512.Sum512([]byte(password))
+		return "{SHA512}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// hashPrefixes contains the supported hash schemes
+var hashPrefixes = []string{"$2a$", "$2b$", "$2y$", "$argon2id$", "$argon2i$", "{SHA}", "{SHA256}", "{SHA512}"}
+
+// checkHash returns an error for the unsupported hash schemes, e.g. $apr1$, $5$ or $6$,
+// they must not be compared as plaintext passwords
+func checkHash(hash string) error {
+	if !strings.HasPrefix(hash, "$") && !strings.HasPrefix(hash, "{") {
+		return nil
+	}
+	for _, prefix := range hashPrefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return nil
+		}
+	}
+	scheme := hash
+	if i := strings.IndexAny(hash[1:], "$}"); i >= 0 {
+		scheme = hash[:i+2]
+	}
+	return fmt.Errorf("unsupported password hash scheme %q, use bcrypt, argon2, {SHA}, {SHA256} or {SHA512}", scheme)
+}
+
+// VerifyPassword checks the password against a bcrypt, argon2, {SHA}, {SHA256}, {SHA512} hash or a plaintext password,
+// unsupported hash schemes never match
+func VerifyPassword(hash, password string) bool {
+	switch {
+	case checkHash(hash) != nil:
+		return false
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"), strings.HasPrefix(hash, "$argon2i$"):
+		return verifyArgon2(hash, password)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return compareBase64(strings.TrimPrefix(hash, "{SHA}"), sum[:])
+	case strings.HasPrefix(hash, "{SHA256}"):
+		sum := sha256.Sum256([]byte(password))
+		return compareBase64(strings.TrimPrefix(hash, "{SHA256}"), sum[:])
+	case strings.HasPrefix(hash, "{SHA512}"):
+		sum := sha512.Sum512([]byte(password))
+		return compareBase64(strings.TrimPrefix(hash, "{SHA512}"), sum[:])
+	default:
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+}
+
+func compareBase64(encoded string, sum []byte) bool {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(decoded, sum) == 1
+}
+
+// verifyArgon2 checks a password against a PHC formatted argon2 hash
+//
+// e.g. $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func verifyArgon2(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	var derived []byte
+	if parts[1] == "argon2id" {
+		derived = argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	} else {
+		derived = argon2.Key([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	}
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+// ParseHtpasswd parses htpasswd lines, user:hash
+func ParseHtpasswd(lines []string) (map[string]string, error) {
+	users := map[string]string{}
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" || hash == "" {
+			return nil, fmt.Errorf("invalid htpasswd entry at line %d", i+1)
+		}
+		if err := checkHash(hash); err != nil {
+			return nil, fmt.Errorf("user %s at line %d: %w", username, i+1, err)
+		}
+		users[username] = hash
+	}
+	return users, nil
+}
+
+// ReadHtpasswdFile reads an htpasswd file
+func ReadHtpasswdFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading htpasswd file: %w", err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+		}
+	}(f)
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading htpasswd file: %w", err)
+	}
+	users, err := ParseHtpasswd(lines)
+	if err != nil {
+		return nil, fmt.Errorf("in file %s: %w", file, err)
+	}
+	return users, nil
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	for _, algorithm := range []string{HashBcrypt, HashArgon2id, HashSHA256, HashSHA512} {
+		hash, err := HashPassword(algorithm, "goma")
+		if err != nil {
+			t.Fatalf("%s: error hashing password: %v", algorithm, err)
+		}
+		if !VerifyPassword(hash, "goma") {
+			t.Errorf("%s: expected password to match", algorithm)
+		}
+		if VerifyPassword(hash, "wrong") {
+			t.Errorf("%s: expected password not to match", algorithm)
+		}
+	}
+	// htpasswd -s
+	if !VerifyPassword("{SHA}wZBl/1oEhxP2sJZzG35fZs9pZCs=", "goma") {
+		t.Error("expected {SHA} password to match")
+	}
+	if !VerifyPassword("goma", "goma") || VerifyPassword("goma", "wrong") {
+		t.Error("unexpected plaintext password verification")
+	}
+	// Unsupported hashes are not compared as plaintext
+	for _, hash := range []string{"$apr1$salt$hash", "$5$salt$hash", "$6$salt$hash", "{MD5}hash"} {
+		if VerifyPassword(hash, hash) {
+			t.Errorf("%s: expected unsupported hash not to match", hash)
+		}
+	}
+}
+
+func TestParseHtpasswdUnsupportedHash(t *testing.T) {
+	for _, line := range []string{"alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/", "alice:$5$salt$hash", "alice:$6$salt$hash", "alice:{MD5}hash"} {
+		if _, err := ParseHtpasswd([]string{line}); err == nil {
+			t.Errorf("%s: expected an unsupported hash error", line)
+		}
+	}
+	if _, err := ParseHtpasswd([]string{"alice:{SHA}wZBl/1oEhxP2sJZzG35fZs9pZCs=", "bob:plaintext"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAuthBasicUsers(t *testing.T) {
+	hash, err := HashPassword(HashBcrypt, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ParseHtpasswd([]string{"# users", "alice:" + hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user string
+	handler := AuthBasic{Username: "admin", Password: "admin", Users: users, Realm: "Goma", UserHeader: "X-Auth-User"}.
+		AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = r.Header.Get("X-Auth-User")
+		}))
+	tests := []struct {
+		credentials string
+		want        int
+		wantUser    string
+	}{
+		{credentials: "alice:secret", want: http.StatusOK, wantUser: "alice"},
+		{credentials: "admin:admin", want: http.StatusOK, wantUser: "admin"},
+		{credentials: "alice:wrong", want: http.StatusUnauthorized},
+		{credentials: "bob:secret", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		user = ""
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.credentials)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want || user != tt.wantUser {
+			t.Errorf("%s: unexpected response %d, user %q", tt.credentials, rec.Code, user)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="Goma"` {
+			t.Errorf("unexpected challenge %q", rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// introspectionCacheSize triggers the removal of expired cache entries
+const introspectionCacheSize = 10000
+
+// introspectionEntry is a cached introspection result
+type introspectionEntry struct {
+	response  map[string]any
+	active    bool
+	expiresAt time.Time
+}
+
+// Init sets the default values
+func (amw *OAuth2Introspect) Init() {
+	if amw.CacheTTL <= 0 {
+		amw.CacheTTL = time.Minute
+	}
+	if amw.NegativeCacheTTL <= 0 {
+		amw.NegativeCacheTTL = 10 * time.Second
+	}
+	if amw.Timeout <= 0 {
+		amw.Timeout = 10 * time.Second
+	}
+	amw.cache = map[string]introspectionEntry{}
+	amw.client = &http.Client{Timeout: amw.Timeout}
+}
+
+// AuthMiddleware validates the request bearer token with the introspection endpoint
+func (amw *OAuth2Introspect) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			logger.Error("Proxy error, missing bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		entry, err := amw.introspect(token)
+		if err != nil {
+			logger.Error("Proxy error, token introspection failed: %v", err)
+			respondWithError(w, http.StatusBadGateway, "Authorization service unavailable")
+			return
+		}
+		if !entry.active || !amw.validAudience(entry.response) {
+			logger.Error("Proxy error, invalid token")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		scopes := strings.Fields(claimString(entry.response["scope"]))
+		for _, scope := range amw.RequiredScopes {
+			if !slices.Contains(scopes, scope) {
+				logger.Error("Proxy error, token missing %s scope", scope)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(amw.RequiredScopes, " ")))
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+		}
+		for field, header := range amw.Headers {
+			r.Header.Del(header)
+			if value := claimString(entry.response[field]); value != "" {
+				r.Header.Set(header, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// introspect returns the cached introspection result or calls the introspection endpoint
+func (amw *OAuth2Introspect) introspect(token string) (introspectionEntry, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := string(sum[:])
+	now := time.Now()
+	amw.mu.Lock()
+	entry, ok := amw.cache[key]
+	amw.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry, nil
+	}
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	if amw.AuthMethod == "client_secret_post" {
+		form.Set("client_id", amw.ClientID)
+		form.Set("client_secret", amw.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, amw.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return introspectionEntry{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if amw.AuthMethod != "client_secret_post" && amw.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(amw.ClientID), url.QueryEscape(amw.ClientSecret))
+	}
+	resp, err := amw.client.Do(req)
+	if err != nil {
+		return introspectionEntry{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+		}
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return introspectionEntry{}, fmt.Errorf("introspection endpoint returned status code %d", resp.StatusCode)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	response := map[string]any{}
+	if err := decoder.Decode(&response); err != nil {
+		return introspectionEntry{}, fmt.Errorf("error decoding introspection response: %w", err)
+	}
+	active, _ := response["active"].(bool)
+	entry = introspectionEntry{response: response, active: active, expiresAt: now.Add(amw.NegativeCacheTTL)}
+	if active {
+		entry.expiresAt = now.Add(amw.CacheTTL)
+		// The cache never outlives the token
+		if exp, err := strconv.ParseInt(claimString(response["exp"]), 10, 64); err == nil {
+			if expiry := time.Unix(exp, 0); expiry.Before(entry.expiresAt) {
+				entry.expiresAt = expiry
+			}
+		}
+		if !now.Before(entry.expiresAt) {
+			entry.active = false
+		}
+	}
+	amw.mu.Lock()
+	if len(amw.cache) >= introspectionCacheSize {
+		for k, v := range amw.cache {
+			if !now.Before(v.expiresAt) {
+				delete(amw.cache, k)
+			}
+		}
+	}
+	if len(amw.cache) < introspectionCacheSize {
+		amw.cache[key] = entry
+	}
+	amw.mu.Unlock()
+	return entry, nil
+}
+
+// validAudience checks the token audience, aud is a string or an array
+func (amw *OAuth2Introspect) validAudience(response map[string]any) bool {
+	if len(amw.Audiences) == 0 {
+		return true
+	}
+	var audiences []string
+	switch aud := response["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			audiences = append(audiences, claimString(a))
+		}
+	}
+	for _, audience := range audiences {
+		if slices.Contains(amw.Audiences, audience) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the request bearer token
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// claimString converts a claim value to a header value, arrays are comma separated
+func claimString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		var values []string
+		for _, item := range v {
+			values = append(values, claimString(item))
+		}
+		return strings.Join(values, ",")
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(buf)
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOAuth2Introspect(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if username, password, ok := r.BasicAuth(); !ok || username != "goma" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response := map[string]any{"active": false}
+		switch r.PostFormValue("token") {
+		case "valid":
+			response = map[string]any{"active": true, "sub": "alice", "scope": "read write", "aud": []string{"store"},
+				"exp": time.Now().Add(time.Hour).Unix()}
+		case "read-only":
+			response = map[string]any{"active": true, "sub": "bob", "scope": "read", "aud": "store"}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	amw := &OAuth2Introspect{
+		URL:            server.URL,
+		ClientID:       "goma",
+		ClientSecret:   "secret",
+		RequiredScopes: []string{"write"},
+		Audiences:      []string{"store"},
+		Headers:        map[string]string{"sub": "X-Auth-Subject"},
+	}
+	amw.Init()
+	var subject string
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.Header.Get("X-Auth-Subject")
+	}))
+	serve := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := serve("valid"); code != http.StatusOK || subject != "alice" {
+		t.Errorf("unexpected response %d, subject %q", code, subject)
+	}
+	if code := serve("valid"); code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("expected cached result, got %d and %d calls", code, calls.Load())
+	}
+	if code := serve("read-only"); code != http.StatusForbidden {
+		t.Errorf("expected status code %d, got %d", http.StatusForbidden, code)
+	}
+	if code := serve("revoked"); code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+	serve("revoked")
+	if calls.Load() != 3 {
+		t.Errorf("expected negative result to be cached, got %d calls", calls.Load())
+	}
+	if code := serve(""); code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+}
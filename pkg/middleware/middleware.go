@@ -1,12 +1,15 @@
 package middleware
 
 import (
+	"crypto/cipher"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/jkaninda/goma/internal/logger"
-	"io"
+	"hash"
+	"net"
 	"net/http"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -56,6 +59,16 @@ type AuthJWT struct {
 	RequiredHeaders []string
 	Headers         map[string]string
 	Params          map[string]string
+	// ForwardHeaders contains the request headers sent to the auth service, all headers when empty
+	ForwardHeaders []string
+	// ResponseHeaders contains the auth service response headers relayed to the client on denial
+	ResponseHeaders []string
+	Timeout         time.Duration
+	// CacheTTL caches the auth service decisions per token, disabled when zero
+	CacheTTL time.Duration
+	cache    map[string]forwardAuthEntry
+	client   *http.Client
+	mu       sync.Mutex
 }
 
 // AuthenticationMiddleware  Define struct
@@ -71,117 +84,112 @@ type BlockListMiddleware struct {
 	List        []string
 }
 
-// AuthBasic  Define Basic auth
-type AuthBasic struct {
-	Username string
-	Password string
-	Headers  map[string]string
-	Params   map[string]string
+// AccessListMiddleware  Define IP access list
+type AccessListMiddleware struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
 }
 
-// AuthMiddleware function, which will be called for each request
-func (amw *AuthJWT) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for _, header := range amw.RequiredHeaders {
-			if r.Header.Get(header) == "" {
-				logger.Error("Proxy error, missing %s header", header)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusForbidden)
-				err := json.NewEncoder(w).Encode(ProxyResponseError{
-					Message: "Missing Authorization header",
-					Code:    http.StatusForbidden,
-					Success: false,
-				})
-				if err != nil {
-					return
-				}
-				return
-			}
-		}
-		//token := r.Header.Get("Authorization")
-		authURL, err := url.Parse(amw.AuthURL)
-		if err != nil {
-			logger.Error("Error parsing auth URL: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			err = json.NewEncoder(w).Encode(ProxyResponseError{
-				Message: "Internal Server Error",
-				Code:    http.StatusInternalServerError,
-				Success: false,
-			})
-			if err != nil {
-				return
-			}
-			return
-		}
-		// Create a new request for /authentication
-		authReq, err := http.NewRequest("GET", authURL.String(), nil)
-		if err != nil {
-			logger.Error("Proxy error creating authentication request: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			err = json.NewEncoder(w).Encode(ProxyResponseError{
-				Message: "Internal Server Error",
-				Code:    http.StatusInternalServerError,
-				Success: false,
-			})
-			if err != nil {
-				return
-			}
-			return
-		}
-		// Copy headers from the original request to the new request
-		for name, values := range r.Header {
-			for _, value := range values {
-				authReq.Header.Set(name, value)
-			}
-		}
-		// Copy cookies from the original request to the new request
-		for _, cookie := range r.Cookies() {
-			authReq.AddCookie(cookie)
-		}
-		// Perform the request to the auth service
-		client := &http.Client{}
-		authResp, err := client.Do(authReq)
-		if err != nil || authResp.StatusCode != http.StatusOK {
-			logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
-			logger.Error("Proxy authentication error")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			err = json.NewEncoder(w).Encode(ProxyResponseError{
-				Message: "Unauthorized",
-				Code:    http.StatusUnauthorized,
-				Success: false,
-			})
-			if err != nil {
-				return
-			}
-			return
-		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
+// AuthAPIKey  Define API key auth
+type AuthAPIKey struct {
+	// Route contains the route name and path, used to check the key allowed routes
+	Route      []string
+	HeaderName string
+	QueryName  string
+	CookieName string
+	// Headers maps the key metadata to backend request headers
+	Headers map[string]string
+	Keys    []APIKey
+	// KeysFile contains additional keys, it's reloaded on change
+	KeysFile string
+	keys     []apiKey
+	mu       sync.RWMutex
+	// stop stops the keys file watcher, it's closed by Close
+	stop      chan struct{}
+	closeOnce sync.Once
+}
 
-			}
-		}(authResp.Body)
-		// Inject specific header tp the current request's header
-		// Add header to the next request from AuthRequest header, depending on your requirements
-		if amw.Headers != nil {
-			for k, v := range amw.Headers {
-				r.Header.Set(v, authResp.Header.Get(k))
-			}
-		}
-		query := r.URL.Query()
-		// Add query parameters to the next request from AuthRequest header, depending on your requirements
-		if amw.Params != nil {
-			for k, v := range amw.Params {
-				query.Set(v, authResp.Header.Get(k))
-			}
-		}
-		r.URL.RawQuery = query.Encode()
+// APIKey defines an API key and its consumer metadata
+type APIKey struct {
+	Consumer string            `yaml:"consumer"`
+	Hash     string            `yaml:"hash"`
+	Routes   []string          `yaml:"routes"`
+	Tier     string            `yaml:"tier"`
+	Metadata map[string]string `yaml:"metadata"`
+}
 
-		next.ServeHTTP(w, r)
-	})
+// OAuth2Introspect  Define OAuth2 token introspection
+type OAuth2Introspect struct {
+	URL              string
+	ClientID         string
+	ClientSecret     string
+	AuthMethod       string
+	RequiredScopes   []string
+	Audiences        []string
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+	Timeout          time.Duration
+	Headers          map[string]string
+	cache            map[string]introspectionEntry
+	client           *http.Client
+	mu               sync.Mutex
+}
+
+// OIDC  Define OpenID Connect login
+type OIDC struct {
+	Issuer                string
+	ClientID              string
+	ClientSecret          string
+	RedirectURL           string
+	Scopes                []string
+	CookieName            string
+	CookieSecret          string
+	SessionTTL            time.Duration
+	LogoutPath            string
+	PostLogoutRedirectURL string
+	AllowedDomains        []string
+	AllowedGroups         []string
+	GroupsClaim           string
+	Headers               map[string]string
+	callbackPath          string
+	aead                  cipher.AEAD
+	provider              *oidcProvider
+	mu                    sync.Mutex
+}
+
+// Signature  Define HMAC request signature verification
+type Signature struct {
+	Secret          string
+	Algorithm       string
+	Header          string
+	Format          string
+	Prefix          string
+	Encoding        string
+	TimestampHeader string
+	Payload         string
+	Tolerance       time.Duration
+	MaxBodySize     int64
+	hash            func() hash.Hash
+}
+
+// MTLS  Define client certificate authorization
+type MTLS struct {
+	Subjects []string
+	Headers  map[string]string
+}
+
+// AuthBasic  Define Basic auth
+type AuthBasic struct {
+	Username string
+	Password string
+	// Users contains usernames and password hashes
+	Users map[string]string
+	// Realm defines the authentication realm, default is Restricted
+	Realm string
+	// UserHeader defines the backend header receiving the authenticated username
+	UserHeader string
+	Headers    map[string]string
+	Params     map[string]string
 }
 
 // AuthMiddleware checks for the Authorization header and verifies the credentials
@@ -191,7 +199,7 @@ func (basicAuth AuthBasic) AuthMiddleware(next http.Handler) http.Handler {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			logger.Error("Proxy error, missing Authorization header")
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			w.Header().Set("WWW-Authenticate", basicAuth.challenge())
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			err := json.NewEncoder(w).Encode(ProxyResponseError{
@@ -239,8 +247,8 @@ func (basicAuth AuthBasic) AuthMiddleware(next http.Handler) http.Handler {
 
 		// Split the payload into username and password
 		pair := strings.SplitN(string(payload), ":", 2)
-		if len(pair) != 2 || pair[0] != basicAuth.Username || pair[1] != basicAuth.Password {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		if len(pair) != 2 || !basicAuth.verify(pair[0], pair[1]) {
+			w.Header().Set("WWW-Authenticate", basicAuth.challenge())
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			err := json.NewEncoder(w).Encode(ProxyResponseError{
@@ -254,8 +262,47 @@ func (basicAuth AuthBasic) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if basicAuth.UserHeader != "" {
+			r.Header.Set(basicAuth.UserHeader, pair[0])
+		}
 		// Continue to the next handler if the authentication is successful
 		next.ServeHTTP(w, r)
 	})
 
 }
+
+// verify checks the username and password using constant-time comparison
+func (basicAuth AuthBasic) verify(username, password string) bool {
+	if basicAuth.Username != "" &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(basicAuth.Username)) == 1 {
+		return VerifyPassword(basicAuth.Password, password)
+	}
+	hash, ok := basicAuth.Users[username]
+	if !ok {
+		return false
+	}
+	return VerifyPassword(hash, password)
+}
+
+// challenge returns the WWW-Authenticate header value
+func (basicAuth AuthBasic) challenge() string {
+	realm := basicAuth.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	return fmt.Sprintf("Basic realm=%q", realm)
+}
+
+// respondWithError writes a JSON error response
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(ProxyResponseError{
+		Success: false,
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		return
+	}
+}
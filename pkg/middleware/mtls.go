@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/jkaninda/goma/internal/logger"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultMTLSHeaders maps the certificate fields to backend request headers
+var defaultMTLSHeaders = map[string]string{
+	"subject":     "X-Client-Cert-Subject",
+	"sans":        "X-Client-Cert-SANs",
+	"fingerprint": "X-Client-Cert-Fingerprint",
+}
+
+// AuthMiddleware requires a verified client certificate and checks its subject
+func (amw MTLS) AuthMiddleware(next http.Handler) http.Handler {
+	headers := amw.Headers
+	if len(headers) == 0 {
+		headers = defaultMTLSHeaders
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := SetClientCertHeaders(r, headers)
+		if cert == nil {
+			logger.Error("Proxy error, missing client certificate")
+			respondWithError(w, http.StatusUnauthorized, "Client certificate required")
+			return
+		}
+		if len(amw.Subjects) != 0 && !slices.Contains(amw.Subjects, cert.Subject.CommonName) && !slices.Contains(amw.Subjects, cert.Subject.String()) {
+			logger.Error("Proxy error, client certificate %s not allowed", cert.Subject)
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetClientCertHeaders replaces the certificate headers sent by the client by the verified client certificate fields,
+// default headers are used when headers is empty.
+//
+// It returns the verified client certificate, nil when the request has none
+func SetClientCertHeaders(r *http.Request, headers map[string]string) *x509.Certificate {
+	if len(headers) == 0 {
+		headers = defaultMTLSHeaders
+	}
+	// Never trust certificate headers sent by the client
+	for _, header := range headers {
+		r.Header.Del(header)
+	}
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return nil
+	}
+	for field, header := range headers {
+		if value := certificateField(cert, field); value != "" {
+			r.Header.Set(header, value)
+		}
+	}
+	return cert
+}
+
+// ClientCertificate returns the verified client certificate, nil when the request has none
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateField returns a certificate field as a header value
+func certificateField(cert *x509.Certificate, field string) string {
+	switch field {
+	case "subject":
+		return cert.Subject.String()
+	case "issuer":
+		return cert.Issuer.String()
+	case "serial":
+		return cert.SerialNumber.String()
+	case "fingerprint":
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	case "sans":
+		var sans []string
+		for _, name := range cert.DNSNames {
+			sans = append(sans, "DNS:"+name)
+		}
+		for _, email := range cert.EmailAddresses {
+			sans = append(sans, "email:"+email)
+		}
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, "IP:"+ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, "URI:"+uri.String())
+		}
+		return strings.Join(sans, ",")
+	default:
+		logger.Error("Unknown client certificate field %s", field)
+		return ""
+	}
+}
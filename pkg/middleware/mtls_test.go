@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMTLS(t *testing.T) {
+	cert := &x509.Certificate{
+		Raw:          []byte("certificate"),
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "partner-a", Organization: []string{"Partner A"}},
+		DNSNames:     []string{"partner-a.example.com"},
+	}
+	amw := MTLS{Subjects: []string{"partner-a"}}
+	var headers http.Header
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantCode int
+	}{
+		{name: "allowed subject", cert: cert, wantCode: http.StatusOK},
+		{name: "other subject", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "partner-b"}}, wantCode: http.StatusForbidden},
+		{name: "missing certificate", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Client-Cert-Subject", "CN=spoofed")
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+	if got := headers.Get("X-Client-Cert-Subject"); got != "CN=partner-a,O=Partner A" {
+		t.Errorf("unexpected subject header %q", got)
+	}
+	if got := headers.Get("X-Client-Cert-SANs"); got != "DNS:partner-a.example.com" {
+		t.Errorf("unexpected SANs header %q", got)
+	}
+	if got := headers.Get("X-Client-Cert-Fingerprint"); len(got) != 64 {
+		t.Errorf("unexpected fingerprint header %q", got)
+	}
+}
@@ -0,0 +1,459 @@
+package middleware
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jkaninda/goma/internal/logger"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// oidcStateTTL defines how long a login can take
+	oidcStateTTL = 10 * time.Minute
+	// oidcDiscoveryTimeout defines the provider discovery timeout
+	oidcDiscoveryTimeout = 10 * time.Second
+)
+
+type oidcProvider struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// endSessionURL is the provider logout endpoint, if supported
+	endSessionURL string
+}
+
+// oidcSession is stored in the encrypted session cookie
+type oidcSession struct {
+	Claims       map[string]any `json:"c"`
+	RefreshToken string         `json:"r,omitempty"`
+	// Expiry is the token expiry, the session is refreshed after it
+	Expiry int64 `json:"e"`
+	// CreatedAt is the login time
+	CreatedAt int64 `json:"t"`
+}
+
+// oidcState is stored in the encrypted state cookie during the login
+type oidcState struct {
+	State      string `json:"s"`
+	Verifier   string `json:"v"`
+	Nonce      string `json:"n"`
+	RedirectTo string `json:"r"`
+	Expiry     int64  `json:"e"`
+}
+
+// Init validates the configuration and sets the default values, the provider is discovered on the first request
+func (amw *OIDC) Init() error {
+	if amw.Issuer == "" || amw.ClientID == "" || amw.RedirectURL == "" {
+		return errors.New("issuer, clientId and redirectUrl are required")
+	}
+	if len(amw.CookieSecret) < 32 {
+		return errors.New("cookieSecret must be at least 32 characters")
+	}
+	redirectURL, err := url.Parse(amw.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("error parsing redirectUrl: %w", err)
+	}
+	amw.callbackPath = redirectURL.Path
+	if len(amw.Scopes) == 0 {
+		amw.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	if amw.CookieName == "" {
+		amw.CookieName = "goma_session"
+	}
+	if amw.SessionTTL <= 0 {
+		amw.SessionTTL = 24 * time.Hour
+	}
+	if amw.GroupsClaim == "" {
+		amw.GroupsClaim = "groups"
+	}
+	if len(amw.Headers) == 0 {
+		amw.Headers = map[string]string{
+			"sub":   "X-Auth-User",
+			"email": "X-Auth-Email",
+		}
+	}
+	key := sha256.Sum256([]byte(amw.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	amw.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// getProvider discovers the OpenID provider
+func (amw *OIDC) getProvider() (*oidcProvider, error) {
+	amw.mu.Lock()
+	defer amw.mu.Unlock()
+	if amw.provider != nil {
+		return amw.provider, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oidcDiscoveryTimeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, amw.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, err
+	}
+	amw.provider = &oidcProvider{
+		config: oauth2.Config{
+			ClientID:     amw.ClientID,
+			ClientSecret: amw.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  amw.RedirectURL,
+			Scopes:       amw.Scopes,
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: amw.ClientID}),
+		endSessionURL: metadata.EndSessionEndpoint,
+	}
+	return amw.provider, nil
+}
+
+// AuthMiddleware redirects unauthenticated browsers to the OpenID provider and handles the callback and logout
+func (amw *OIDC) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider, err := amw.getProvider()
+		if err != nil {
+			logger.Error("Proxy error, OpenID provider discovery failed: %v", err)
+			respondWithError(w, http.StatusBadGateway, "Authorization service unavailable")
+			return
+		}
+		switch r.URL.Path {
+		case amw.callbackPath:
+			amw.handleCallback(w, r, provider)
+			return
+		case amw.LogoutPath:
+			if amw.LogoutPath != "" {
+				amw.handleLogout(w, r, provider)
+				return
+			}
+		}
+		session, err := amw.session(r, provider)
+		if err != nil {
+			if !errors.Is(err, http.ErrNoCookie) {
+				logger.Error("Proxy error, invalid OIDC session: %v", err)
+			}
+			amw.clearCookie(w, r, amw.CookieName)
+			amw.login(w, r, provider)
+			return
+		}
+		if session.updated {
+			amw.setSessionCookie(w, r, session.oidcSession)
+		}
+		if !amw.authorized(session.Claims) {
+			logger.Error("Proxy error, user %v is not allowed to access %s", session.Claims["sub"], r.URL.Path)
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		for claim, header := range amw.Headers {
+			// Values coming from the client are never trusted
+			r.Header.Del(header)
+			if value := claimString(session.Claims[claim]); value != "" {
+				r.Header.Set(header, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type currentSession struct {
+	oidcSession
+	updated bool
+}
+
+// session returns the request session, it's refreshed when the token has expired
+func (amw *OIDC) session(r *http.Request, provider *oidcProvider) (currentSession, error) {
+	cookie, err := r.Cookie(amw.CookieName)
+	if err != nil {
+		return currentSession{}, err
+	}
+	var session oidcSession
+	if err := amw.decrypt(cookie.Value, &session); err != nil {
+		return currentSession{}, err
+	}
+	now := time.Now()
+	if now.After(time.Unix(session.CreatedAt, 0).Add(amw.SessionTTL)) {
+		return currentSession{}, errors.New("session expired")
+	}
+	if now.Before(time.Unix(session.Expiry, 0)) {
+		return currentSession{oidcSession: session}, nil
+	}
+	if session.RefreshToken == "" {
+		return currentSession{}, errors.New("token expired")
+	}
+	token, err := provider.config.TokenSource(r.Context(), &oauth2.Token{RefreshToken: session.RefreshToken}).Token()
+	if err != nil {
+		return currentSession{}, fmt.Errorf("error refreshing token: %w", err)
+	}
+	if rawIDToken, ok := token.Extra("id_token").(string); ok {
+		idToken, err := provider.verifier.Verify(r.Context(), rawIDToken)
+		if err != nil {
+			return currentSession{}, fmt.Errorf("error verifying refreshed ID token: %w", err)
+		}
+		claims := map[string]any{}
+		if err := idToken.Claims(&claims); err != nil {
+			return currentSession{}, err
+		}
+		session.Claims = amw.sessionClaims(claims)
+	}
+	if token.RefreshToken != "" {
+		session.RefreshToken = token.RefreshToken
+	}
+	session.Expiry = token.Expiry.Unix()
+	return currentSession{oidcSession: session, updated: true}, nil
+}
+
+// login redirects the browser to the OpenID provider
+func (amw *OIDC) login(w http.ResponseWriter, r *http.Request, provider *oidcProvider) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	state := oidcState{
+		State:      randomString(),
+		Verifier:   oauth2.GenerateVerifier(),
+		Nonce:      randomString(),
+		RedirectTo: r.URL.RequestURI(),
+		Expiry:     time.Now().Add(oidcStateTTL).Unix(),
+	}
+	value, err := amw.encrypt(state)
+	if err != nil {
+		logger.Error("Proxy error, %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	amw.setCookie(w, r, amw.stateCookieName(), value, oidcStateTTL)
+	authURL := provider.config.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback exchanges the authorization code and creates the session
+func (amw *OIDC) handleCallback(w http.ResponseWriter, r *http.Request, provider *oidcProvider) {
+	cookie, err := r.Cookie(amw.stateCookieName())
+	var state oidcState
+	if err == nil {
+		err = amw.decrypt(cookie.Value, &state)
+	}
+	amw.clearCookie(w, r, amw.stateCookieName())
+	query := r.URL.Query()
+	if err != nil || time.Now().After(time.Unix(state.Expiry, 0)) || query.Get("state") != state.State {
+		logger.Error("Proxy error, invalid OIDC state")
+		respondWithError(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		logger.Error("Proxy error, OIDC login failed: %s %s", errorCode, query.Get("error_description"))
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	token, err := provider.config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		logger.Error("Proxy error, OIDC code exchange failed: %v", err)
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		logger.Error("Proxy error, OIDC token response without id_token")
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	idToken, err := provider.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != state.Nonce {
+		logger.Error("Proxy error, invalid ID token: %v", err)
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		logger.Error("Proxy error, invalid ID token claims: %v", err)
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	session := oidcSession{
+		Claims:       amw.sessionClaims(claims),
+		RefreshToken: token.RefreshToken,
+		Expiry:       idToken.Expiry.Unix(),
+		CreatedAt:    time.Now().Unix(),
+	}
+	if !token.Expiry.IsZero() && token.Expiry.Before(idToken.Expiry) {
+		session.Expiry = token.Expiry.Unix()
+	}
+	if !amw.authorized(session.Claims) {
+		logger.Error("Proxy error, user %v is not allowed", session.Claims["sub"])
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	amw.setSessionCookie(w, r, session)
+	redirectTo := state.RedirectTo
+	// Only local redirects are allowed
+	if !strings.HasPrefix(redirectTo, "/") || strings.HasPrefix(redirectTo, "//") {
+		redirectTo = "/"
+	}
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+// handleLogout clears the session and redirects to the provider logout endpoint, if supported
+func (amw *OIDC) handleLogout(w http.ResponseWriter, r *http.Request, provider *oidcProvider) {
+	amw.clearCookie(w, r, amw.CookieName)
+	redirectTo := amw.PostLogoutRedirectURL
+	if provider.endSessionURL != "" {
+		endSessionURL, err := url.Parse(provider.endSessionURL)
+		if err == nil {
+			query := endSessionURL.Query()
+			query.Set("client_id", amw.ClientID)
+			if amw.PostLogoutRedirectURL != "" {
+				query.Set("post_logout_redirect_uri", amw.PostLogoutRedirectURL)
+			}
+			endSessionURL.RawQuery = query.Encode()
+			redirectTo = endSessionURL.String()
+		}
+	}
+	if redirectTo == "" {
+		redirectTo = "/"
+	}
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+// sessionClaims keeps the claims needed by the session, to limit the cookie size
+func (amw *OIDC) sessionClaims(claims map[string]any) map[string]any {
+	kept := map[string]any{}
+	for _, claim := range []string{"sub", "email", "email_verified", amw.GroupsClaim} {
+		if value, ok := claims[claim]; ok {
+			kept[claim] = value
+		}
+	}
+	for claim := range amw.Headers {
+		if value, ok := claims[claim]; ok {
+			kept[claim] = value
+		}
+	}
+	return kept
+}
+
+// authorized checks the email domain and groups restrictions
+func (amw *OIDC) authorized(claims map[string]any) bool {
+	if len(amw.AllowedDomains) != 0 {
+		email, _ := claims["email"].(string)
+		_, domain, ok := strings.Cut(email, "@")
+		if !ok || !slices.Contains(amw.AllowedDomains, strings.ToLower(domain)) {
+			return false
+		}
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return false
+		}
+	}
+	if len(amw.AllowedGroups) != 0 {
+		var groups []string
+		switch value := claims[amw.GroupsClaim].(type) {
+		case string:
+			groups = []string{value}
+		case []any:
+			for _, group := range value {
+				groups = append(groups, claimString(group))
+			}
+		}
+		if !slices.ContainsFunc(groups, func(group string) bool {
+			return slices.Contains(amw.AllowedGroups, group)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (amw *OIDC) stateCookieName() string {
+	return amw.CookieName + "_state"
+}
+
+func (amw *OIDC) setSessionCookie(w http.ResponseWriter, r *http.Request, session oidcSession) {
+	value, err := amw.encrypt(session)
+	if err != nil {
+		logger.Error("Proxy error, %v", err)
+		return
+	}
+	ttl := time.Until(time.Unix(session.CreatedAt, 0).Add(amw.SessionTTL))
+	amw.setCookie(w, r, amw.CookieName, value, ttl)
+}
+
+func (amw *OIDC) setCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (amw *OIDC) clearCookie(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := r.Cookie(name); err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// encrypt encodes and encrypts a cookie value with AES-GCM
+func (amw *OIDC) encrypt(v any) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding cookie: %w", err)
+	}
+	nonce := make([]byte, amw.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(amw.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// decrypt decrypts and decodes a cookie value
+func (amw *OIDC) decrypt(value string, v any) error {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(ciphertext) < amw.aead.NonceSize() {
+		return errors.New("invalid cookie")
+	}
+	nonceSize := amw.aead.NonceSize()
+	plaintext, err := amw.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return errors.New("invalid cookie")
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// randomString returns a random URL safe string
+func randomString() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// isSecure checks if the client uses HTTPS
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
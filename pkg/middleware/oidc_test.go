@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/go-jose/go-jose/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockOIDCProvider is a minimal OpenID provider issuing RS256 ID tokens
+type mockOIDCProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	email string
+	// nonces contains the authorization request nonce by code
+	nonces map[string]string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, email: "alice@example.com", nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"end_session_endpoint":                  p.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			http.Error(w, "PKCE required", http.StatusBadRequest)
+			return
+		}
+		code := "code-" + query.Get("state")
+		p.nonces[code] = query.Get("nonce")
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code_verifier") == "" {
+			http.Error(w, "missing code_verifier", http.StatusBadRequest)
+			return
+		}
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, _ := json.Marshal(map[string]any{
+			"iss":    p.URL,
+			"aud":    "goma",
+			"sub":    "alice",
+			"email":  p.email,
+			"groups": []string{"admins"},
+			"nonce":  p.nonces[r.PostFormValue("code")],
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		})
+		signed, err := signer.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idToken, _ := signed.CompactSerialize()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access",
+			"token_type":    "Bearer",
+			"refresh_token": "refresh",
+			"expires_in":    3600,
+			"id_token":      idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDC(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.Close()
+	amw := &OIDC{
+		Issuer:         provider.URL,
+		ClientID:       "goma",
+		ClientSecret:   "secret",
+		RedirectURL:    "http://dashboard.example.com/admin/oauth2/callback",
+		CookieSecret:   strings.Repeat("s", 32),
+		LogoutPath:     "/admin/logout",
+		AllowedDomains: []string{"example.com"},
+		AllowedGroups:  []string{"admins"},
+	}
+	if err := amw.Init(); err != nil {
+		t.Fatalf("Error initializing OIDC middleware: %v", err)
+	}
+	handler := amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Auth-Email")))
+	}))
+	serve := func(target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	// Unauthenticated browsers are redirected to the provider
+	rec := serve("http://dashboard.example.com/admin/users?page=2", nil)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), provider.URL+"/authorize") {
+		t.Fatalf("expected a redirect to the provider, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	stateCookies := rec.Result().Cookies()
+	// The provider redirects back to the callback
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	rec = serve(resp.Header.Get("Location"), stateCookies)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/admin/users?page=2" {
+		t.Fatalf("expected a redirect to the original URL, got %d %s %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	var sessionCookies []*http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "goma_session" {
+			sessionCookies = append(sessionCookies, cookie)
+		}
+	}
+	if len(sessionCookies) != 1 {
+		t.Fatal("expected a session cookie")
+	}
+	// Authenticated requests reach the backend with identity headers
+	rec = serve("http://dashboard.example.com/admin/users", sessionCookies)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice@example.com" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	// Tampered cookies restart the login
+	rec = serve("http://dashboard.example.com/admin/users", []*http.Cookie{{Name: "goma_session", Value: sessionCookies[0].Value + "x"}})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the provider, got %d", rec.Code)
+	}
+	// Logout clears the session
+	rec = serve("http://dashboard.example.com/admin/logout", sessionCookies)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), provider.URL+"/logout") {
+		t.Fatalf("expected a redirect to the provider logout, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// Users outside the allowed domains are rejected
+	amw.AllowedDomains = []string{"goma.dev"}
+	rec = serve("http://dashboard.example.com/admin/users", sessionCookies)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
@@ -38,8 +38,7 @@ func (rl *RateLimiter) RateLimitMiddleware() mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-			//TODO:
-			clientID := r.RemoteAddr
+			clientID := ClientIP(r)
 			logger.Info(clientID)
 
 			rl.mu.Lock()
@@ -73,3 +72,26 @@ func (rl *RateLimiter) RateLimitMiddleware() mux.MiddlewareFunc {
 		})
 	}
 }
+
+// Clients returns a copy of the clients request counters
+func (rl *RateLimiter) Clients() map[string]Client {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	clients := make(map[string]Client, len(rl.ClientMap))
+	now := time.Now()
+	for id, client := range rl.ClientMap {
+		if now.Before(client.ExpiresAt) {
+			clients[id] = *client
+		}
+	}
+	return clients
+}
+
+// Reset removes the client request counter, it returns false when the client is unknown
+func (rl *RateLimiter) Reset(clientID string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	_, exists := rl.ClientMap[clientID]
+	delete(rl.ClientMap, clientID)
+	return exists
+}
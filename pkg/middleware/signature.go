@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature header formats
+const (
+	SignatureFormatPlain  = "plain"
+	SignatureFormatStripe = "stripe"
+)
+
+// Init validates the signature settings and sets the default values
+func (amw *Signature) Init() error {
+	if amw.Secret == "" {
+		return errors.New("signature secret is required")
+	}
+	switch strings.ToLower(amw.Algorithm) {
+	case "", "sha256":
+		amw.hash = sha256.New
+	case "sha512":
+		amw.hash = sha512.New
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", amw.Algorithm)
+	}
+	switch amw.Format {
+	case "", SignatureFormatPlain, SignatureFormatStripe:
+	default:
+		return fmt.Errorf("unsupported signature format %s", amw.Format)
+	}
+	switch amw.Encoding {
+	case "", "hex", "base64":
+	default:
+		return fmt.Errorf("unsupported signature encoding %s", amw.Encoding)
+	}
+	if amw.Header == "" {
+		amw.Header = "X-Signature"
+	}
+	if amw.Payload == "" {
+		amw.Payload = "{body}"
+		if amw.timestamped() {
+			amw.Payload = "{timestamp}.{body}"
+		}
+	}
+	// An unsigned timestamp can be replaced by the client, the replay protection would be bypassed
+	if amw.timestamped() && !strings.Contains(amw.Payload, "{timestamp}") {
+		return errors.New("signature payload must include {timestamp} when the timestamp is checked")
+	}
+	if amw.Tolerance > 0 && !amw.timestamped() {
+		return errors.New("signature tolerance requires a timestamp header or the stripe format")
+	}
+	if amw.Tolerance <= 0 {
+		amw.Tolerance = 5 * time.Minute
+	}
+	if amw.MaxBodySize <= 0 {
+		amw.MaxBodySize = 10 << 20
+	}
+	return nil
+}
+
+// AuthMiddleware verifies the request signature, the body is passed unchanged to the backend
+func (amw *Signature) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, signatures := amw.parseHeader(r)
+		if len(signatures) == 0 {
+			logger.Error("Proxy error, missing %s header", amw.Header)
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if amw.timestamped() {
+			if err := amw.checkTimestamp(timestamp); err != nil {
+				logger.Error("Proxy error, %v", err)
+				respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+		}
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, amw.MaxBodySize+1))
+			if err != nil {
+				logger.Error("Proxy error reading request body: %v", err)
+				respondWithError(w, http.StatusBadRequest, "Bad Request")
+				return
+			}
+			if int64(len(body)) > amw.MaxBodySize {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+				return
+			}
+			// Restore the body for the backend
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		expected := amw.sign(r, timestamp, body)
+		for _, signature := range signatures {
+			if hmac.Equal(amw.decode(signature), expected) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		logger.Error("Proxy error, invalid request signature")
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	})
+}
+
+// timestamped returns true when the signature includes a timestamp
+func (amw *Signature) timestamped() bool {
+	return amw.Format == SignatureFormatStripe || amw.TimestampHeader != ""
+}
+
+// parseHeader returns the request timestamp and signatures
+func (amw *Signature) parseHeader(r *http.Request) (string, []string) {
+	value := strings.TrimSpace(r.Header.Get(amw.Header))
+	if value == "" {
+		return "", nil
+	}
+	timestamp := r.Header.Get(amw.TimestampHeader)
+	if amw.Format != SignatureFormatStripe {
+		return timestamp, []string{strings.TrimPrefix(value, amw.Prefix)}
+	}
+	// t=1492774577,v1=5257a869...,v1=...
+	var signatures []string
+	for _, part := range strings.Split(value, ",") {
+		key, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	return timestamp, signatures
+}
+
+// checkTimestamp rejects replayed requests outside the tolerance window
+func (amw *Signature) checkTimestamp(timestamp string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > amw.Tolerance || age < -amw.Tolerance {
+		return fmt.Errorf("signature timestamp outside the tolerance window")
+	}
+	return nil
+}
+
+// sign returns the HMAC of the signed payload
+func (amw *Signature) sign(r *http.Request, timestamp string, body []byte) []byte {
+	replacer := strings.NewReplacer("{timestamp}", timestamp, "{method}", r.Method, "{path}", r.URL.EscapedPath())
+	mac := hmac.New(amw.hash, []byte(amw.Secret))
+	before, after, hasBody := strings.Cut(amw.Payload, "{body}")
+	_, _ = mac.Write([]byte(replacer.Replace(before)))
+	if hasBody {
+		_, _ = mac.Write(body)
+		_, _ = mac.Write([]byte(replacer.Replace(after)))
+	}
+	return mac.Sum(nil)
+}
+
+// decode decodes the signature, it returns nil for an invalid signature
+func (amw *Signature) decode(signature string) []byte {
+	var decoded []byte
+	var err error
+	if amw.Encoding == "base64" {
+		decoded, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		decoded, err = hex.DecodeString(strings.ToLower(signature))
+	}
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
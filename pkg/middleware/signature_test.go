@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hmacHex(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignature(t *testing.T) {
+	const body = `{"action":"opened"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	tests := []struct {
+		name     string
+		amw      Signature
+		body     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{name: "github", amw: Signature{Secret: "secret", Header: "X-Hub-Signature-256", Prefix: "sha256="}, body: body,
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + hmacHex("secret", body)},
+			wantCode: http.StatusOK},
+		{name: "github tampered body", amw: Signature{Secret: "secret", Header: "X-Hub-Signature-256", Prefix: "sha256="}, body: body + " ",
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + hmacHex("secret", body)},
+			wantCode: http.StatusUnauthorized},
+		{name: "missing signature", amw: Signature{Secret: "secret"}, body: body, wantCode: http.StatusUnauthorized},
+		{name: "stripe", amw: Signature{Secret: "whsec", Header: "Stripe-Signature", Format: SignatureFormatStripe}, body: body,
+			headers:  map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s,v1=%s", now, hmacHex("old", now+"."+body), hmacHex("whsec", now+"."+body))},
+			wantCode: http.StatusOK},
+		{name: "stripe replay", amw: Signature{Secret: "whsec", Header: "Stripe-Signature", Format: SignatureFormatStripe}, body: body,
+			headers:  map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s", old, hmacHex("whsec", old+"."+body))},
+			wantCode: http.StatusUnauthorized},
+		{name: "timestamp and path", amw: Signature{Secret: "secret", TimestampHeader: "X-Timestamp", Payload: "{method}\n{path}\n{timestamp}\n{body}"}, body: body,
+			headers:  map[string]string{"X-Timestamp": now, "X-Signature": hmacHex("secret", "POST\n/hooks\n"+now+"\n"+body)},
+			wantCode: http.StatusOK},
+		{name: "body too large", amw: Signature{Secret: "secret", MaxBodySize: 4}, body: body,
+			headers:  map[string]string{"X-Signature": hmacHex("secret", body)},
+			wantCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.amw.Init(); err != nil {
+				t.Fatalf("Error initializing signature middleware: %v", err)
+			}
+			var backendBody string
+			handler := tt.amw.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				backendBody = string(b)
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if tt.wantCode == http.StatusOK && backendBody != tt.body {
+				t.Errorf("expected backend body %q, got %q", tt.body, backendBody)
+			}
+		})
+	}
+}
+
+func TestSignatureInit(t *testing.T) {
+	for _, amw := range []Signature{{}, {Secret: "secret", Algorithm: "md5"}, {Secret: "secret", Format: "github"},
+		{Secret: "secret", TimestampHeader: "X-Timestamp", Payload: "{body}"},
+		{Secret: "secret", Format: SignatureFormatStripe, Payload: "{method}.{body}"},
+		{Secret: "secret", Tolerance: time.Minute}} {
+		if err := amw.Init(); err == nil {
+			t.Errorf("expected an error for %+v", amw)
+		}
+	}
+}
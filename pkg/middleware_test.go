@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 )
@@ -46,10 +48,11 @@ func TestMiddleware(t *testing.T) {
 func TestReadMiddleware(t *testing.T) {
 	TestMiddleware(t)
 	middlewares := getMiddlewares(t)
-	middleware, err := searchMiddleware(rules, middlewares)
-	if err != nil {
-		t.Fatalf("Error searching middleware %s", err.Error())
+	found, _ := findMiddlewares(rules, middlewares)
+	if len(found) == 0 {
+		t.Fatalf("No middleware found with name %v", rules)
 	}
+	middleware := found[0]
 	switch middleware.Type {
 	case "basic":
 		log.Println("Basic auth")
@@ -74,11 +77,13 @@ func TestReadMiddleware(t *testing.T) {
 
 func TestFoundMiddleware(t *testing.T) {
 	middlewares := getMiddlewares(t)
-	middleware, err := searchMiddleware(rules, middlewares)
-	if err != nil {
-		t.Errorf("Error getting middleware %v", err)
+	found, missing := findMiddlewares(rules, middlewares)
+	if len(found) != 1 || len(missing) != 2 {
+		t.Errorf("Error getting middleware, found %v, missing %v", found, missing)
+	}
+	for _, middleware := range found {
+		fmt.Println(middleware.Type)
 	}
-	fmt.Println(middleware.Type)
 }
 
 func getMiddlewares(t *testing.T) []Middleware {
@@ -93,3 +98,77 @@ func getMiddlewares(t *testing.T) []Middleware {
 	}
 	return *c
 }
+
+func TestMiddlewareChain(t *testing.T) {
+	middlewares := []Middleware{
+		{Name: "basic-auth", Type: "basic", Rule: BasicRule{Username: "goma", Password: "goma"}},
+		{Name: "internal", Type: "access", Rule: AccessRule{Allow: []string{"10.0.0.0/8"}}},
+	}
+	tests := []struct {
+		name     string
+		mid      RouteMiddleware
+		remote   string
+		auth     bool
+		wantCode int
+		wantDesc string
+	}{
+		{name: "allOf denied by access", mid: RouteMiddleware{Rules: []string{"internal", "basic-auth"}},
+			remote: "192.168.1.1:1234", auth: true, wantCode: http.StatusForbidden, wantDesc: "internal -> basic-auth"},
+		{name: "allOf allowed", mid: RouteMiddleware{Rules: []string{"internal", "basic-auth"}},
+			remote: "10.0.0.1:1234", auth: true, wantCode: http.StatusOK, wantDesc: "internal -> basic-auth"},
+		{name: "anyOf allowed by basic auth", mid: RouteMiddleware{Rules: []string{"internal", "basic-auth"}, Mode: MiddlewareModeAnyOf},
+			remote: "192.168.1.1:1234", auth: true, wantCode: http.StatusOK, wantDesc: "anyOf(internal | basic-auth)"},
+		{name: "anyOf denied", mid: RouteMiddleware{Rules: []string{"internal", "basic-auth"}, Mode: MiddlewareModeAnyOf},
+			remote: "192.168.1.1:1234", wantCode: http.StatusUnauthorized, wantDesc: "anyOf(internal | basic-auth)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := middlewareChain(Route{Name: "test", Path: "/"}, tt.mid, middlewares, nil)
+			if err != nil {
+				t.Fatalf("Error creating middlewares chain: %v", err)
+			}
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			for i := len(chain) - 1; i >= 0; i-- {
+				handler = chain[i](handler)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.auth {
+				r.SetBasicAuth("goma", "goma")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if desc := describeChain(tt.mid, middlewares); desc != tt.wantDesc {
+				t.Errorf("expected chain %q, got %q", tt.wantDesc, desc)
+			}
+		})
+	}
+	if _, err := middlewareChain(Route{}, RouteMiddleware{Rules: []string{"internal"}, Mode: "oneOf"}, middlewares, nil); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+	// Missing middlewares must not open the path, whatever the mode
+	for _, mid := range []RouteMiddleware{
+		{Rules: []string{"internal", "fake"}},
+		{Rules: []string{"fake"}},
+		{Rules: []string{"internal", "fake"}, Mode: MiddlewareModeAnyOf},
+	} {
+		if _, err := middlewareChain(Route{}, mid, middlewares, nil); err == nil {
+			t.Errorf("%v: expected an error for a missing middleware", mid)
+		}
+	}
+	if desc := describeChain(RouteMiddleware{Rules: []string{"internal", "fake"}, Mode: MiddlewareModeAnyOf}, middlewares); desc != "anyOf(internal | fake (not found))" {
+		t.Errorf("unexpected chain %q", desc)
+	}
+
+	// The gateway rejects the requests of the path
+	gatewayServer := &GatewayServer{gateway: Gateway{Routes: []Route{{Name: "admin", Path: "/admin", Destination: "http://127.0.0.1:1",
+		Middlewares: []RouteMiddleware{{Path: "/", Rules: []string{"fake"}, Mode: MiddlewareModeAnyOf}}}}}, middlewares: middlewares}
+	rec := httptest.NewRecorder()
+	gatewayServer.Initialize().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected the path to be unavailable, got %d", rec.Code)
+	}
+}
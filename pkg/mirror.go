@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMirrorMaxBodySize = 1 << 20
+	defaultMirrorTimeout     = 10
+	// maxPendingMirrorRequests limits the number of in-flight mirrored requests per route
+	maxPendingMirrorRequests = 100
+)
+
+// TrafficMirror sends a copy of requests to a secondary backend
+type TrafficMirror struct {
+	route       string
+	target      *url.URL
+	percentage  int
+	maxBodySize int64
+	client      *http.Client
+	pending     chan struct{}
+	metrics     *MirrorMetrics
+}
+
+// NewTrafficMirror creates a TrafficMirror for the route
+func NewTrafficMirror(route string, mirror Mirror) (*TrafficMirror, error) {
+	target, err := mirror.target()
+	if err != nil {
+		return nil, err
+	}
+	tm := &TrafficMirror{
+		route:       route,
+		target:      target,
+		percentage:  mirror.Percentage,
+		maxBodySize: mirror.MaxBodySize,
+		pending:     make(chan struct{}, maxPendingMirrorRequests),
+		metrics:     getMirrorMetrics(route),
+	}
+	if tm.percentage <= 0 || tm.percentage > 100 {
+		tm.percentage = 100
+	}
+	if tm.maxBodySize <= 0 {
+		tm.maxBodySize = defaultMirrorMaxBodySize
+	}
+	timeout := mirror.Timeout
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+	tm.client = &http.Client{
+		Timeout: time.Duration(timeout) * time.Second,
+		// Redirects are returned to the mirror as is
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return tm, nil
+}
+
+// target returns the mirror backend URL
+func (mirror Mirror) target() (*url.URL, error) {
+	target, err := url.Parse(mirror.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mirror URL: %w", err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid mirror URL %q", mirror.URL)
+	}
+	return target, nil
+}
+
+// validate checks the mirror settings
+func (mirror Mirror) validate() error {
+	if mirror.Percentage < 0 || mirror.Percentage > 100 {
+		return fmt.Errorf("mirror percentage %d must be between 0 and 100", mirror.Percentage)
+	}
+	_, err := mirror.target()
+	return err
+}
+
+// Mirror sends a copy of the request asynchronously.
+//
+// The request body is copied to the mirror while the primary backend reads it, the mirrored request is sent once the body is read
+func (tm *TrafficMirror) Mirror(r *http.Request) {
+	if tm.percentage < 100 && rand.IntN(100) >= tm.percentage {
+		return
+	}
+	u := *tm.target
+	u.Path = r.URL.Path
+	u.RawPath = r.URL.RawPath
+	u.RawQuery = r.URL.RawQuery
+	header := r.Header.Clone()
+	method := r.Method
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		tm.dispatch(method, u.String(), header, nil)
+		return
+	}
+	if r.ContentLength > tm.maxBodySize {
+		tm.skip(method, u.String(), "body larger than the mirror maxBodySize")
+		return
+	}
+	r.Body = &mirrorBody{ReadCloser: r.Body, maxSize: tm.maxBodySize, done: func(body []byte, reason string) {
+		if reason != "" {
+			tm.skip(method, u.String(), reason)
+			return
+		}
+		tm.dispatch(method, u.String(), header, body)
+	}}
+}
+
+// dispatch sends the mirrored request in the background, it's skipped when too many requests are pending
+func (tm *TrafficMirror) dispatch(method, target string, header http.Header, body []byte) {
+	select {
+	case tm.pending <- struct{}{}:
+	default:
+		tm.skip(method, target, "too many pending mirrored requests")
+		return
+	}
+	go func() {
+		defer func() { <-tm.pending }()
+		start := time.Now()
+		err := tm.send(method, target, header, body)
+		duration := time.Since(start)
+		tm.metrics.observe(duration, err)
+		if err != nil {
+			logger.Error("Route %s: mirror %s %s error: %v", tm.route, method, target, err)
+			return
+		}
+		logger.Debug("Route %s: mirror %s %s %v", tm.route, method, target, duration)
+	}()
+}
+
+// skip counts a request that is not mirrored
+func (tm *TrafficMirror) skip(method, target, reason string) {
+	tm.metrics.Skipped.Add(1)
+	logger.Warn("Route %s: mirror %s %s skipped, %s", tm.route, method, target, reason)
+}
+
+// send performs the mirrored request and discards its response
+func (tm *TrafficMirror) send(method, target string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Del("Connection")
+	resp, err := tm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+		}
+	}(resp.Body)
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("mirror responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mirrorBody copies the request body read by the primary backend, up to maxSize bytes.
+//
+// done is called once, with the body when it was read to the end, or with the reason the copy is incomplete
+type mirrorBody struct {
+	io.ReadCloser
+	maxSize int64
+	// mu protects the copy, the transport can close the body while reading it
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	overflow bool
+	once     sync.Once
+	done     func(body []byte, reason string)
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > 0 && !b.overflow {
+		if int64(b.buf.Len()+n) > b.maxSize {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.finish("")
+	} else if err != nil {
+		b.finish(fmt.Sprintf("error reading the body: %v", err))
+	}
+	return n, err
+}
+
+func (b *mirrorBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The body was not read to the end, e.g. the primary backend responded early
+	b.finish("body not fully read by the backend")
+	return err
+}
+
+// finish calls done, the caller holds the lock
+func (b *mirrorBody) finish(reason string) {
+	b.once.Do(func() {
+		if b.overflow {
+			reason = "body larger than the mirror maxBodySize"
+		}
+		if reason != "" {
+			b.done(nil, reason)
+			return
+		}
+		b.done(b.buf.Bytes(), "")
+	})
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrafficMirror(t *testing.T) {
+	mirrored := make(chan string, 1)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mirror.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	proxyRoute := ProxyRoute{
+		name:        "mirror-test",
+		path:        "/store",
+		rewrite:     "/",
+		destination: backend.URL,
+		mirror:      Mirror{URL: mirror.URL, MaxBodySize: 16},
+	}
+	handler := proxyRoute.ProxyHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/store/items", strings.NewReader("goma")))
+	if rec.Body.String() != "goma" {
+		t.Fatalf("expected backend body %q, got %q", "goma", rec.Body.String())
+	}
+	select {
+	case got := <-mirrored:
+		if got != "POST /items goma" {
+			t.Errorf("unexpected mirrored request %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+	// Bodies larger than the limit are not mirrored but still proxied
+	rec = httptest.NewRecorder()
+	largeBody := strings.Repeat("a", 32)
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/store/items", strings.NewReader(largeBody)))
+	if rec.Body.String() != largeBody {
+		t.Fatalf("expected backend body %q, got %q", largeBody, rec.Body.String())
+	}
+	// Bodies of unknown length are copied while proxied, the copy stops at the limit
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/store/items", strings.NewReader(largeBody))
+	r.ContentLength = -1
+	handler.ServeHTTP(rec, r)
+	if rec.Body.String() != largeBody {
+		t.Fatalf("expected backend body %q, got %q", largeBody, rec.Body.String())
+	}
+	metrics := getMirrorMetrics("mirror-test")
+	deadline := time.Now().Add(5 * time.Second)
+	for metrics.Errors.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if metrics.Requests.Load() != 1 || metrics.Errors.Load() != 1 || metrics.Skipped.Load() != 2 {
+		t.Errorf("unexpected mirror metrics: requests=%d errors=%d skipped=%d",
+			metrics.Requests.Load(), metrics.Errors.Load(), metrics.Skipped.Load())
+	}
+}
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jkaninda/goma/internal/logger"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type MockRoute struct {
+	path string
+	mock Mock
+}
+
+// mockData contains the request values available in mock response templates
+type mockData struct {
+	Method  string
+	Path    string
+	Vars    map[string]string
+	Query   map[string]string
+	Headers map[string]string
+}
+
+// MockHandler returns the configured mock responses
+func (mockRoute MockRoute) MockHandler() http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Not found: %s", r.URL.Path))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+	for _, response := range mockRoute.mock.Responses {
+		handler, err := response.handler()
+		if err != nil {
+			logger.Error("Route %s: error creating mock response: %v", mockRoute.path, err)
+			continue
+		}
+		var route *mux.Route
+		if response.Path == "" {
+			route = router.PathPrefix(mockRoute.path).Handler(handler)
+		} else {
+			route = router.Path(mockRoute.path + response.Path).Handler(handler)
+		}
+		if len(response.Methods) != 0 {
+			route.Methods(response.Methods...)
+		}
+	}
+	return router
+}
+
+// validate checks the mock responses, body files are read when the route starts
+func (mock Mock) validate() error {
+	if len(mock.Responses) == 0 {
+		return errors.New("mock requires at least one response")
+	}
+	for i, response := range mock.Responses {
+		if err := response.validate(); err != nil {
+			return fmt.Errorf("mock response %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (response MockResponse) validate() error {
+	if response.Path != "" && !strings.HasPrefix(response.Path, "/") {
+		return fmt.Errorf("path %s must start with /", response.Path)
+	}
+	for _, status := range []int{response.Status, response.ErrorStatus} {
+		if status != 0 && (status < 100 || status > 599) {
+			return fmt.Errorf("invalid status code %d", status)
+		}
+	}
+	if response.ErrorRate < 0 || response.ErrorRate > 100 {
+		return fmt.Errorf("errorRate %d must be between 0 and 100", response.ErrorRate)
+	}
+	if response.Latency < 0 {
+		return fmt.Errorf("invalid latency %d", response.Latency)
+	}
+	if _, err := template.New("body").Parse(response.Body); err != nil {
+		return fmt.Errorf("error parsing body template: %w", err)
+	}
+	return nil
+}
+
+// handler returns the mock response handler
+func (response MockResponse) handler() (http.HandlerFunc, error) {
+	body := response.Body
+	if body == "" && response.BodyFile != "" {
+		buf, err := os.ReadFile(response.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body file: %w", err)
+		}
+		body = string(buf)
+	}
+	tmpl, err := template.New("body").Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing body template: %w", err)
+	}
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	errorStatus := response.ErrorStatus
+	if errorStatus == 0 {
+		errorStatus = http.StatusInternalServerError
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
+		if response.Latency > 0 {
+			select {
+			case <-time.After(time.Duration(response.Latency) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if response.ErrorRate > 0 && rand.IntN(100) < response.ErrorRate {
+			RespondWithError(w, errorStatus, http.StatusText(errorStatus))
+			return
+		}
+		data := mockData{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Vars:    mux.Vars(r),
+			Query:   map[string]string{},
+			Headers: map[string]string{},
+		}
+		for k := range r.URL.Query() {
+			data.Query[k] = r.URL.Query().Get(k)
+		}
+		for k := range r.Header {
+			data.Headers[k] = r.Header.Get(k)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			logger.Error("Error executing mock response template: %v", err)
+			RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		for k, v := range response.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(status)
+		_, err := w.Write(buf.Bytes())
+		if err != nil {
+			return
+		}
+	}, nil
+}
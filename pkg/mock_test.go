@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMockRoute(t *testing.T) {
+	mockRoute := MockRoute{
+		path: "/api",
+		mock: Mock{
+			Responses: []MockResponse{
+				{
+					Path:    "/users/{id}",
+					Methods: []string{http.MethodGet},
+					Headers: map[string]string{"Content-Type": "application/json"},
+					Body:    `{"id":"{{ .Vars.id }}","page":"{{ .Query.page }}"}`,
+				},
+				{
+					Path:    "/users",
+					Methods: []string{http.MethodPost},
+					Status:  http.StatusCreated,
+				},
+				{
+					Path:      "/failing",
+					ErrorRate: 100,
+				},
+			},
+		},
+	}
+	handler := mockRoute.MockHandler()
+	tests := []struct {
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{method: http.MethodGet, target: "/api/users/12?page=2", wantCode: http.StatusOK, wantBody: `{"id":"12","page":"2"}`},
+		{method: http.MethodPost, target: "/api/users", wantCode: http.StatusCreated},
+		{method: http.MethodDelete, target: "/api/users/12", wantCode: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/api/orders", wantCode: http.StatusNotFound},
+		{method: http.MethodGet, target: "/api/failing", wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s %s: expected status code %d, got %d", tt.method, tt.target, tt.wantCode, rec.Code)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s %s: expected body %q, got %q", tt.method, tt.target, tt.wantBody, rec.Body.String())
+		}
+	}
+}
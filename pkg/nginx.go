@@ -0,0 +1,726 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxDirective is a parsed nginx directive, block directives contain their children
+type nginxDirective struct {
+	name  string
+	args  []string
+	line  int
+	block []nginxDirective
+}
+
+type nginxToken struct {
+	value  string
+	quoted bool
+	line   int
+}
+
+// nginxTokens splits an nginx configuration into words, quoted strings and ; { } delimiters, comments are skipped
+func nginxTokens(buf []byte) ([]nginxToken, error) {
+	var tokens []nginxToken
+	line := 1
+	for i := 0; i < len(buf); {
+		ch := buf[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch == '#':
+			for i < len(buf) && buf[i] != '\n' {
+				i++
+			}
+		case ch == ';' || ch == '{' || ch == '}':
+			tokens = append(tokens, nginxToken{value: string(ch), line: line})
+			i++
+		case ch == '"' || ch == '\'':
+			start := line
+			var value strings.Builder
+			i++
+			for ; i < len(buf) && buf[i] != ch; i++ {
+				if buf[i] == '\\' && i+1 < len(buf) {
+					i++
+				}
+				if buf[i] == '\n' {
+					line++
+				}
+				value.WriteByte(buf[i])
+			}
+			if i == len(buf) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			tokens = append(tokens, nginxToken{value: value.String(), quoted: true, line: start})
+			i++
+		default:
+			start := i
+			for i < len(buf) && !strings.ContainsRune(" \t\r\n;{}", rune(buf[i])) {
+				i++
+				// Variables can be enclosed in braces, e.g. ${host}
+				if buf[i-1] == '$' && i < len(buf) && buf[i] == '{' {
+					for i < len(buf) && buf[i] != '}' {
+						i++
+					}
+					if i < len(buf) {
+						i++
+					}
+				}
+			}
+			tokens = append(tokens, nginxToken{value: string(buf[start:i]), line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// parseNginx parses an nginx configuration, included files are not loaded
+func parseNginx(buf []byte) ([]nginxDirective, error) {
+	tokens, err := nginxTokens(buf)
+	if err != nil {
+		return nil, err
+	}
+	p := &nginxParser{tokens: tokens}
+	return p.parseBlock(false)
+}
+
+type nginxParser struct {
+	tokens []nginxToken
+	pos    int
+}
+
+func (p *nginxParser) parseBlock(nested bool) ([]nginxDirective, error) {
+	var directives []nginxDirective
+	var current *nginxDirective
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		p.pos++
+		if token.quoted || !strings.Contains(";{}", token.value) {
+			if current == nil {
+				current = &nginxDirective{name: token.value, line: token.line}
+			} else {
+				current.args = append(current.args, token.value)
+			}
+			continue
+		}
+		switch token.value {
+		case ";":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: unexpected \";\"", token.line)
+			}
+			directives = append(directives, *current)
+			current = nil
+		case "{":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: unexpected \"{\"", token.line)
+			}
+			block, err := p.parseBlock(true)
+			if err != nil {
+				return nil, err
+			}
+			current.block = block
+			directives = append(directives, *current)
+			current = nil
+		case "}":
+			if !nested {
+				return nil, fmt.Errorf("line %d: unexpected \"}\"", token.line)
+			}
+			if current != nil {
+				return nil, fmt.Errorf("line %d: directive %q is not terminated by \";\"", current.line, current.name)
+			}
+			return directives, nil
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: directive %q is not terminated by \";\"", current.line, current.name)
+	}
+	if nested {
+		return nil, fmt.Errorf("unexpected end of file, expecting \"}\"")
+	}
+	return directives, nil
+}
+
+// nginxIgnored contains the directives without goma equivalent that don't change the routing,
+// and the proxy headers goma sends by default
+var nginxIgnored = map[string]bool{
+	"user": true, "worker_processes": true, "worker_connections": true, "events": true, "pid": true,
+	"sendfile": true, "tcp_nopush": true, "tcp_nodelay": true, "keepalive_timeout": true, "types": true,
+	"default_type": true, "server_tokens": true, "log_format": true, "access_log": true, "error_log": true,
+	"proxy_http_version": true, "proxy_redirect": true,
+}
+
+// nginxForwardedHeaders contains the proxy_set_header headers goma sets by default
+var nginxForwardedHeaders = map[string]bool{
+	"host": true, "x-real-ip": true, "x-forwarded-for": true, "x-forwarded-proto": true, "x-forwarded-host": true,
+	"upgrade": true, "connection": true,
+}
+
+// nginxSettings contains the location settings, server settings are inherited by locations
+type nginxSettings struct {
+	realm      string
+	userFile   string
+	allow      []string
+	deny       []string
+	denyAll    bool
+	limitZones []string
+	root       string
+	alias      string
+	index      string
+	tryFiles   []string
+	proxyPass  string
+	rewrites   [][]string
+	ret        []string
+}
+
+// nginxLimitZone is a limit_req_zone rate, in requests per minute
+type nginxLimitZone struct {
+	rate int
+}
+
+type nginxConverter struct {
+	*converter
+	upstreams map[string][]Backend
+	zones     map[string]nginxLimitZone
+	listen    string
+	tlsListen string
+}
+
+// nginx converts nginx server blocks, at the top level or in the http block, to routes
+func (c *converter) nginx(buf []byte) error {
+	directives, err := parseNginx(buf)
+	if err != nil {
+		return fmt.Errorf("error parsing nginx configuration: %w", err)
+	}
+	n := &nginxConverter{converter: c, upstreams: map[string][]Backend{}, zones: map[string]nginxLimitZone{}}
+	n.collect(directives)
+	n.convert(directives)
+	return nil
+}
+
+// collect reads the upstreams and the rate limit zones, they can be declared after their use
+func (n *nginxConverter) collect(directives []nginxDirective) {
+	for _, d := range directives {
+		switch d.name {
+		case "http":
+			n.collect(d.block)
+		case "upstream":
+			if len(d.args) == 1 {
+				n.upstream(d)
+			}
+		case "limit_req_zone":
+			n.limitZone(d)
+		}
+	}
+}
+
+func (n *nginxConverter) convert(directives []nginxDirective) {
+	for _, d := range directives {
+		switch {
+		case d.name == "http":
+			n.convert(d.block)
+		case d.name == "server":
+			n.server(d)
+		case d.name == "upstream" || d.name == "limit_req_zone" || nginxIgnored[d.name]:
+		case d.name == "include" && len(d.args) == 1 && strings.HasSuffix(d.args[0], "mime.types"):
+		case d.name == "include":
+			n.warnf("line %d: include %s is not loaded, convert the included files separately", d.line, strings.Join(d.args, " "))
+		default:
+			n.warnf("line %d: %s is not translated", d.line, d.name)
+		}
+	}
+}
+
+// upstream converts the upstream servers to backends, the scheme is set by proxy_pass
+func (n *nginxConverter) upstream(d nginxDirective) {
+	var backends []Backend
+	for _, s := range d.block {
+		if s.name != "server" || len(s.args) == 0 {
+			n.warnf("line %d: upstream %s: %s is not translated", s.line, d.args[0], s.name)
+			continue
+		}
+		backend := Backend{Name: s.args[0], Destination: s.args[0], Weight: 1}
+		skip := false
+		for _, param := range s.args[1:] {
+			if weight, ok := strings.CutPrefix(param, "weight="); ok {
+				backend.Weight, _ = strconv.Atoi(weight)
+				continue
+			}
+			if param == "backup" || param == "down" {
+				skip = true
+			}
+			n.warnf("line %d: upstream %s: server %s %s is not translated", s.line, d.args[0], s.args[0], param)
+		}
+		if !skip {
+			backends = append(backends, backend)
+		}
+	}
+	n.upstreams[d.args[0]] = backends
+}
+
+var nginxRate = regexp.MustCompile(`^rate=(\d+)r/([sm])$`)
+
+// limitZone reads a limit_req_zone rate, goma limits are per client IP
+func (n *nginxConverter) limitZone(d nginxDirective) {
+	var name string
+	rate := 0
+	for _, arg := range d.args {
+		if zone, ok := strings.CutPrefix(arg, "zone="); ok {
+			name, _, _ = strings.Cut(zone, ":")
+		}
+		if match := nginxRate.FindStringSubmatch(arg); match != nil {
+			rate, _ = strconv.Atoi(match[1])
+			if match[2] == "s" {
+				rate *= 60
+			}
+		}
+	}
+	if len(d.args) > 0 && d.args[0] != "$binary_remote_addr" && d.args[0] != "$remote_addr" {
+		n.warnf("line %d: limit_req_zone %s: goma limits requests per client IP, key %s is not translated", d.line, name, d.args[0])
+	}
+	n.zones[name] = nginxLimitZone{rate: rate}
+}
+
+// setting reads a location setting, it returns false for the other directives
+func (n *nginxConverter) setting(s *nginxSettings, d nginxDirective) bool {
+	arg := func(i int) string {
+		if i < len(d.args) {
+			return d.args[i]
+		}
+		return ""
+	}
+	switch d.name {
+	case "auth_basic":
+		s.realm = arg(0)
+	case "auth_basic_user_file":
+		s.userFile = arg(0)
+	case "allow":
+		if arg(0) != "all" {
+			s.allow = append(s.allow, arg(0))
+		}
+	case "deny":
+		if arg(0) != "all" {
+			s.deny = append(s.deny, arg(0))
+		} else if len(s.allow) == 0 {
+			s.denyAll = true
+		}
+	case "limit_req":
+		for _, a := range d.args {
+			if zone, ok := strings.CutPrefix(a, "zone="); ok {
+				s.limitZones = append(s.limitZones, zone)
+			} else {
+				n.warnf("line %d: limit_req %s is not translated", d.line, a)
+			}
+		}
+	case "root":
+		s.root = arg(0)
+	case "alias":
+		s.alias = arg(0)
+	case "index":
+		s.index = arg(0)
+	case "try_files":
+		s.tryFiles = d.args
+	case "proxy_pass":
+		s.proxyPass = arg(0)
+	case "rewrite":
+		s.rewrites = append(s.rewrites, d.args)
+	case "return":
+		s.ret = d.args
+	case "proxy_set_header":
+		if !nginxForwardedHeaders[strings.ToLower(arg(0))] {
+			n.warnf("line %d: proxy_set_header %s is not translated", d.line, arg(0))
+		}
+	default:
+		return nginxIgnored[d.name]
+	}
+	return true
+}
+
+// server converts the server locations to routes matching the server names
+func (n *nginxConverter) server(server nginxDirective) {
+	var hosts []string
+	var settings nginxSettings
+	var locations []nginxDirective
+	for _, d := range server.block {
+		switch d.name {
+		case "listen":
+			n.setListen(d)
+		case "server_name":
+			hosts = append(hosts, n.serverNames(d)...)
+		case "ssl_certificate":
+			n.setTLS(d, &n.config.GatewayConfig.TLS.CertFile)
+		case "ssl_certificate_key":
+			n.setTLS(d, &n.config.GatewayConfig.TLS.KeyFile)
+		case "location":
+			locations = append(locations, d)
+		default:
+			if !n.setting(&settings, d) {
+				n.warnf("line %d: %s is not translated", d.line, d.name)
+			}
+		}
+	}
+	if len(locations) == 0 || settings.ret != nil {
+		// The server return applies to all locations
+		n.route(nginxDirective{name: "location", args: []string{"/"}, line: server.line}, hosts, settings)
+		return
+	}
+	for _, location := range locations {
+		n.route(location, hosts, settings)
+	}
+}
+
+// setListen sets the gateway listen addresses from the first HTTP and HTTPS listen directives
+func (n *nginxConverter) setListen(d nginxDirective) {
+	if len(d.args) == 0 {
+		return
+	}
+	address := d.args[0]
+	if strings.HasPrefix(address, "unix:") {
+		n.warnf("line %d: listen %s is not translated", d.line, address)
+		return
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if _, err := strconv.Atoi(address); err == nil {
+			host, port = "", address
+		} else {
+			host, port = address, "80"
+		}
+	}
+	if host == "" || host == "*" || host == "[::]" || host == "::" {
+		host = "0.0.0.0"
+	}
+	address = net.JoinHostPort(host, port)
+	ssl := false
+	for _, param := range d.args[1:] {
+		ssl = ssl || param == "ssl"
+	}
+	current := &n.listen
+	if ssl {
+		current = &n.tlsListen
+	}
+	switch *current {
+	case "":
+		*current = address
+		if ssl {
+			n.config.GatewayConfig.TLS.ListenAddr = address
+		} else {
+			n.config.GatewayConfig.ListenAddr = address
+		}
+	case address:
+	default:
+		n.warnf("line %d: listen %s is not translated, goma listens on %s", d.line, address, *current)
+	}
+}
+
+// setTLS sets the gateway certificate or key, goma uses a single certificate
+func (n *nginxConverter) setTLS(d nginxDirective, file *string) {
+	if len(d.args) == 0 {
+		return
+	}
+	if *file != "" && *file != d.args[0] {
+		n.warnf("line %d: %s %s is not translated, goma uses %s", d.line, d.name, d.args[0], *file)
+		return
+	}
+	*file = d.args[0]
+}
+
+// serverNames returns the route hosts, nginx .example.com names match the domain and its subdomains
+func (n *nginxConverter) serverNames(d nginxDirective) []string {
+	var hosts []string
+	for _, name := range d.args {
+		switch {
+		case name == "_" || name == "" || name == "localhost":
+		case strings.HasPrefix(name, "~"):
+			n.warnf("line %d: server_name %s, regular expressions are not translated", d.line, name)
+		case strings.HasPrefix(name, "."):
+			hosts = append(hosts, name[1:], "*"+name)
+		case strings.HasSuffix(name, ".*"):
+			n.warnf("line %d: server_name %s, wildcard suffixes are not translated", d.line, name)
+		default:
+			hosts = append(hosts, name)
+		}
+	}
+	return hosts
+}
+
+// route converts a location to a proxy, redirect, static or mock route
+func (n *nginxConverter) route(location nginxDirective, hosts []string, server nginxSettings) {
+	if len(location.args) == 0 || len(location.args) > 2 {
+		n.warnf("line %d: location %s is not translated", location.line, strings.Join(location.args, " "))
+		return
+	}
+	path := location.args[len(location.args)-1]
+	if len(location.args) == 2 {
+		switch location.args[0] {
+		case "=":
+			n.warnf("line %d: location = %s, exact match is converted to a prefix", location.line, path)
+		case "^~":
+		default:
+			n.warnf("line %d: location %s %s, regular expressions are not translated", location.line, location.args[0], path)
+			return
+		}
+	}
+	if strings.HasPrefix(path, "@") {
+		n.warnf("line %d: named location %s is not translated", location.line, path)
+		return
+	}
+	settings := server
+	// Access directives of a location replace the server ones
+	settings.allow, settings.deny, settings.denyAll = nil, nil, false
+	access := false
+	for _, d := range location.block {
+		if d.name == "allow" || d.name == "deny" {
+			access = true
+		}
+		if d.name == "location" || d.name == "if" {
+			n.warnf("line %d: %s in location %s is not translated", d.line, d.name, path)
+			continue
+		}
+		if !n.setting(&settings, d) {
+			n.warnf("line %d: %s is not translated", d.line, d.name)
+		}
+	}
+	if !access {
+		settings.allow, settings.deny, settings.denyAll = server.allow, server.deny, server.denyAll
+	}
+
+	name := path
+	if len(hosts) != 0 {
+		name = hosts[0] + path
+	}
+	route := Route{Name: routeName(name), Path: path, Hosts: hosts}
+	switch {
+	case settings.ret != nil:
+		if !n.returnRoute(&route, settings.ret, location.line) {
+			return
+		}
+	case settings.proxyPass != "":
+		if !n.proxyRoute(&route, settings, location.line) {
+			return
+		}
+	case settings.root != "" || settings.alias != "":
+		if !n.staticRoute(&route, settings, location.line) {
+			return
+		}
+	default:
+		if !n.rewriteRedirect(&route, settings, location.line) {
+			n.warnf("line %d: location %s has no proxy_pass, root or return, it is not translated", location.line, path)
+			return
+		}
+	}
+	if denied := n.routeMiddlewares(&route, settings, location.line); denied != "" {
+		n.denyRoute(&route, denied)
+	}
+	n.addRoute(route)
+}
+
+var nginxVariable = regexp.MustCompile(`\$[a-zA-Z_{]`)
+
+// proxyRoute sets the route backends, the proxy_pass URI replaces the location prefix
+func (n *nginxConverter) proxyRoute(route *Route, settings nginxSettings, line int) bool {
+	if nginxVariable.MatchString(settings.proxyPass) {
+		n.warnf("line %d: proxy_pass %s, variables are not translated", line, settings.proxyPass)
+		return false
+	}
+	u, err := url.Parse(settings.proxyPass)
+	if err != nil || u.Host == "" {
+		n.warnf("line %d: proxy_pass %s is not translated", line, settings.proxyPass)
+		return false
+	}
+	if backends, ok := n.upstreams[u.Host]; ok {
+		for _, backend := range backends {
+			backend.Destination = u.Scheme + "://" + backend.Destination
+			route.Backends = append(route.Backends, backend)
+		}
+		if len(route.Backends) == 1 && route.Backends[0].Weight == 1 {
+			route.Destination = route.Backends[0].Destination
+			route.Backends = nil
+		}
+	} else {
+		route.Destination = u.Scheme + "://" + u.Host
+	}
+	if u.Path != "" && !(u.Path == "/" && route.Path == "/") {
+		route.Rewrite = u.Path
+	}
+	for _, rewrite := range settings.rewrites {
+		rule, redirect, ok := n.rewriteRule(rewrite, line)
+		if redirect {
+			n.warnf("line %d: rewrite %s is a redirect in a proxied location, it is not translated", line, rewrite[0])
+			continue
+		}
+		if ok {
+			route.RewriteRules = append(route.RewriteRules, rule)
+		}
+	}
+	return true
+}
+
+// rewriteRule converts a rewrite directive, it returns true for redirect flags and absolute URLs
+func (n *nginxConverter) rewriteRule(args []string, line int) (RewriteRule, bool, bool) {
+	if len(args) < 2 {
+		return RewriteRule{}, false, false
+	}
+	replacement := strings.TrimSuffix(args[1], "?")
+	// Capture groups use the same syntax, other variables are not translated
+	if nginxVariable.MatchString(replacement) {
+		n.warnf("line %d: rewrite %s %s, variables are not translated", line, args[0], args[1])
+		return RewriteRule{}, false, false
+	}
+	flag := ""
+	if len(args) > 2 {
+		flag = args[2]
+	}
+	redirect := flag == "redirect" || flag == "permanent" ||
+		strings.HasPrefix(replacement, "http://") || strings.HasPrefix(replacement, "https://")
+	return RewriteRule{Pattern: args[0], Replacement: replacement}, redirect, true
+}
+
+// rewriteRedirect converts a location with a redirect rewrite to a redirect route
+func (n *nginxConverter) rewriteRedirect(route *Route, settings nginxSettings, line int) bool {
+	for i, rewrite := range settings.rewrites {
+		rule, redirect, ok := n.rewriteRule(rewrite, line)
+		if !ok || !redirect {
+			continue
+		}
+		if len(settings.rewrites) > 1 {
+			n.warnf("line %d: location %s, only the rewrite %s is translated", line, route.Path, settings.rewrites[i][0])
+		}
+		code := 302
+		if len(rewrite) > 2 && rewrite[2] == "permanent" {
+			code = 301
+		}
+		redirectRoute := Redirect{Pattern: rule.Pattern, Path: rule.Replacement, Code: code}
+		if u, err := url.Parse(rule.Replacement); err == nil && u.Host != "" {
+			redirectRoute.Scheme, redirectRoute.Host, redirectRoute.Path = u.Scheme, u.Host, u.Path
+		}
+		route.Type = RouteTypeRedirect
+		route.Redirect = redirectRoute
+		return true
+	}
+	return false
+}
+
+// returnRoute converts a return directive to a redirect or mock route
+func (n *nginxConverter) returnRoute(route *Route, args []string, line int) bool {
+	code, err := strconv.Atoi(args[0])
+	if err != nil {
+		// return URL is a 302 redirect
+		code, args = 302, []string{"302", args[0]}
+	}
+	text := ""
+	if len(args) > 1 {
+		text = args[1]
+	}
+	if code < 301 || code > 308 || code == 304 || code == 305 || code == 306 {
+		if nginxVariable.MatchString(text) {
+			n.warnf("line %d: return %d %s, variables are not translated", line, code, text)
+			return false
+		}
+		route.Type = RouteTypeMock
+		route.Mock = Mock{Responses: []MockResponse{{Status: code, Body: text}}}
+		return true
+	}
+	// Keep the request path, e.g. https://$host$request_uri
+	target, keepPath := strings.CutSuffix(text, "$request_uri")
+	for _, variable := range []string{"$host", "$server_name", "$http_host"} {
+		target = strings.Replace(target, variable, "", 1)
+	}
+	if nginxVariable.MatchString(target) {
+		n.warnf("line %d: return %d %s, variables are not translated", line, code, text)
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		n.warnf("line %d: return %d %s is not translated", line, code, text)
+		return false
+	}
+	redirect := Redirect{Scheme: u.Scheme, Host: u.Host, Code: code}
+	switch {
+	case !keepPath:
+		// Redirect all paths to the URL
+		redirect.Pattern = "^.*$"
+		redirect.Path = u.Path
+		if u.Path == "" {
+			redirect.Path = "/"
+		}
+		redirect.DropQuery = true
+	case u.Path != "":
+		n.warnf("line %d: return %d %s, the path prefix is not translated", line, code, text)
+	case u.Scheme == "https" && u.Host == "":
+		redirect = Redirect{HTTPS: true, Code: code}
+	}
+	route.Type = RouteTypeRedirect
+	route.Redirect = redirect
+	return true
+}
+
+// staticRoute converts root and alias directives to a static route, nginx root contains the location path
+func (n *nginxConverter) staticRoute(route *Route, settings nginxSettings, line int) bool {
+	dir := settings.alias
+	if dir == "" {
+		dir = path.Join(settings.root, route.Path)
+	}
+	if nginxVariable.MatchString(dir) {
+		n.warnf("line %d: root %s, variables are not translated", line, dir)
+		return false
+	}
+	route.Type = RouteTypeStatic
+	route.Static = Static{Dir: dir}
+	if settings.index != "" && settings.index != "index.html" {
+		route.Static.Index = settings.index
+	}
+	if len(settings.tryFiles) > 1 {
+		// e.g. try_files $uri $uri/ /index.html
+		fallback := settings.tryFiles[len(settings.tryFiles)-1]
+		switch {
+		case strings.HasPrefix(fallback, "="):
+		case !nginxVariable.MatchString(fallback):
+			route.Static.SPA = true
+			if index := path.Base(fallback); index != "index.html" {
+				route.Static.Index = index
+			}
+		default:
+			n.warnf("line %d: try_files %s is not translated", line, strings.Join(settings.tryFiles, " "))
+		}
+	}
+	return true
+}
+
+// routeMiddlewares converts the basic authentication, access and rate limit settings,
+// it returns the reason when the location denies all requests or its authentication is not translated
+func (n *nginxConverter) routeMiddlewares(route *Route, settings nginxSettings, line int) string {
+	basicAuth := settings.realm != "" && settings.realm != "off"
+	if basicAuth && settings.userFile == "" {
+		return fmt.Sprintf("line %d: location %s, auth_basic without auth_basic_user_file is not translated", line, route.Path)
+	}
+	if settings.denyAll {
+		return fmt.Sprintf("line %d: location %s denies all requests", line, route.Path)
+	}
+	var rules []string
+	if len(settings.allow) != 0 || len(settings.deny) != 0 {
+		rules = append(rules, n.addMiddleware(Middleware{Name: "access", Type: "access",
+			Rule: AccessRule{Allow: settings.allow, Deny: settings.deny}}))
+	}
+	if basicAuth {
+		rules = append(rules, n.addMiddleware(Middleware{Name: "basic-auth", Type: "basic",
+			Rule: BasicRule{Realm: settings.realm, HtpasswdFile: settings.userFile}}))
+	}
+	if len(rules) != 0 {
+		route.Middlewares = []RouteMiddleware{{Path: "/", Rules: rules}}
+	}
+	for _, zone := range settings.limitZones {
+		if z, ok := n.zones[zone]; ok {
+			n.setRateLimit(z.rate, fmt.Sprintf("line %d: limit_req zone=%s", line, zone))
+		} else {
+			n.warnf("line %d: limit_req zone %s not found", line, zone)
+		}
+	}
+	return ""
+}
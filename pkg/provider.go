@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"context"
+	"github.com/jkaninda/goma/internal/logger"
+	"net/http"
+	"sort"
+)
+
+// Provider discovers routes and middlewares from a dynamic source
+type Provider interface {
+	// Name returns the provider name, the configuration of a provider is replaced as a whole
+	Name() string
+	// Provide sends the discovered configuration on start and on each change, until the context is canceled
+	Provide(ctx context.Context, configs chan<- ProviderConfig)
+}
+
+// ProviderConfig contains the routes and middlewares discovered by a provider
+type ProviderConfig struct {
+	Provider    string
+	Routes      []Route
+	Middlewares []Middleware
+}
+
+// providers returns the enabled providers of the gateway configuration
+func (gatewayServer *GatewayServer) providers() []Provider {
+	var providers []Provider
+	if k := gatewayServer.gateway.Providers.Kubernetes; k.Enabled {
+		providers = append(providers, NewKubernetesProvider(k))
+	}
+	if d := gatewayServer.gateway.Providers.Docker; d.Enabled {
+		providers = append(providers, NewDockerProvider(d))
+	}
+	return providers
+}
+
+// startProviders applies the configurations of the providers until the context is canceled
+func (rt *gatewayRuntime) startProviders(ctx context.Context, providers []Provider) {
+	if len(providers) == 0 {
+		return
+	}
+	configs := make(chan ProviderConfig)
+	for _, provider := range providers {
+		logger.Info("Starting %s provider", provider.Name())
+		go provider.Provide(ctx, configs)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case config := <-configs:
+				rt.updateProvider(config)
+			}
+		}
+	}()
+}
+
+// updateProvider replaces the configuration of a provider and the running routes
+func (rt *gatewayRuntime) updateProvider(config ProviderConfig) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.providers == nil {
+		rt.providers = map[string]ProviderConfig{}
+	}
+	rt.providers[config.Provider] = config
+	gatewayServer := rt.apply(rt.base)
+	logger.Info("Provider %s updated, %d routes, %d running routes", config.Provider, len(config.Routes), len(gatewayServer.gateway.Routes))
+}
+
+// apply serves the configuration file routes followed by the providers routes, in provider name order.
+//
+// Provider routes and middlewares with a name already defined are ignored, the caller holds rt.mu
+func (rt *gatewayRuntime) apply(base *GatewayServer) *GatewayServer {
+	gatewayServer := *base
+	gatewayServer.gateway.Routes = append([]Route{}, base.gateway.Routes...)
+	gatewayServer.middlewares = append([]Middleware{}, base.middlewares...)
+	routeNames := map[string]bool{}
+	for _, route := range gatewayServer.gateway.Routes {
+		routeNames[route.Name] = true
+	}
+	middlewareNames := map[string]bool{}
+	for _, m := range gatewayServer.middlewares {
+		middlewareNames[m.Name] = true
+	}
+	names := make([]string, 0, len(rt.providers))
+	for name := range rt.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config := rt.providers[name]
+		for _, m := range config.Middlewares {
+			if middlewareNames[m.Name] {
+				logger.Error("Provider %s: middleware %s is already defined, ignoring", name, m.Name)
+				continue
+			}
+			middlewareNames[m.Name] = true
+			gatewayServer.middlewares = append(gatewayServer.middlewares, m)
+		}
+		for _, route := range config.Routes {
+			if routeNames[route.Name] {
+				logger.Error("Provider %s: route %s is already defined, ignoring", name, route.Name)
+				continue
+			}
+			routeNames[route.Name] = true
+			gatewayServer.gateway.Routes = append(gatewayServer.gateway.Routes, route)
+		}
+	}
+	if rt.instances == nil {
+		rt.instances = newMiddlewareInstances()
+	}
+	gatewayServer.instances = rt.instances
+	var handler http.Handler = gatewayServer.Initialize()
+	rt.base = base
+	rt.handler.Store(&handler)
+	rt.gateway.Store(&gatewayServer)
+	// The previous routes are replaced, their middlewares, discoveries and transports no longer used are stopped
+	rt.instances.release()
+	stopDiscoveries(gatewayServer.gateway.Routes)
+	releaseUpstreamTransports(gatewayServer.gateway.Routes)
+	return &gatewayServer
+}
+
+// sortRoutes sorts the discovered routes, routes with hosts come first, then the longest paths,
+// as the first matching route handles the request
+func sortRoutes(routes []Route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if (len(routes[i].Hosts) == 0) != (len(routes[j].Hosts) == 0) {
+			return len(routes[i].Hosts) != 0
+		}
+		return len(routes[i].Path) > len(routes[j].Path)
+	})
+}
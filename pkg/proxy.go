@@ -2,26 +2,106 @@ package pkg
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
 )
 
 type ProxyRoute struct {
 	path            string
 	rewrite         string
+	rewriteRules    []RewriteRule
 	destination     string
 	cors            Cors
 	disableXForward bool
+	name            string
+	mirror          Mirror
+	backends        []Backend
+	discovery       Discovery
+	sticky          Sticky
+	upstreamTLS     UpstreamTLS
 }
 
 // ProxyHandler proxies requests to the backend
 func (proxyRoute ProxyRoute) ProxyHandler() http.HandlerFunc {
+	rewriter, err := NewRewriter(proxyRoute.path, proxyRoute.rewrite, proxyRoute.rewriteRules)
+	if err != nil {
+		logger.Error("Error creating route %s rewriter: %v", proxyRoute.path, err)
+		// Never proxy requests the route can't rewrite
+		return unavailableMiddleware(nil).ServeHTTP
+	}
+	var trafficMirror *TrafficMirror
+	if proxyRoute.mirror.URL != "" {
+		trafficMirror, err = NewTrafficMirror(proxyRoute.name, proxyRoute.mirror)
+		if err != nil {
+			logger.Error("Error creating route %s mirror: %v", proxyRoute.path, err)
+		}
+	}
+	var splitter *TrafficSplitter
+	if len(proxyRoute.backends) != 0 {
+		splitter, err = NewTrafficSplitter(proxyRoute.path, proxyRoute.backends, proxyRoute.sticky)
+		if err != nil {
+			logger.Error("Error creating route %s backends: %v", proxyRoute.path, err)
+			return unavailableMiddleware(nil).ServeHTTP
+		}
+	}
+	var discovered *discoveredSplitter
+	if proxyRoute.discovery.Type != "" {
+		discovery, err := proxyRoute.discovery.start()
+		if err != nil {
+			logger.Error("Error creating route %s discovery: %v", proxyRoute.path, err)
+			return unavailableMiddleware(nil).ServeHTTP
+		}
+		discovered = &discoveredSplitter{discovery: discovery, path: proxyRoute.path, sticky: proxyRoute.sticky}
+	}
+	if proxyRoute.upstreamTLS.InsecureSkipVerify {
+		logger.Warn("Route %s: upstream TLS certificate verification is DISABLED with insecureSkipVerify, do not use it in production", proxyRoute.path)
+	}
+	transport, err := proxyRoute.upstreamTLS.transport()
+	if err != nil {
+		logger.Error("Error creating route %s upstream transport: %v", proxyRoute.path, err)
+		// Never connect to the backend without the configured CA and client certificate
+		return func(w http.ResponseWriter, r *http.Request) {
+			RespondWithError(w, http.StatusServiceUnavailable, "Service unavailable")
+		}
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
+		// Choose the backend group of the request
+		var targetURL *url.URL
+		splitter := splitter
+		if discovered != nil {
+			// Wait for the first resolution on start
+			select {
+			case <-discovered.discovery.ready:
+			case <-r.Context().Done():
+				return
+			}
+			splitter = discovered.get()
+			if splitter == nil {
+				logger.Error("Route %s: no backend discovered", proxyRoute.path)
+				RespondWithError(w, http.StatusServiceUnavailable, "Service unavailable")
+				return
+			}
+		}
+		if splitter != nil {
+			var backend string
+			backend, targetURL = splitter.Choose(w, r)
+			logger.Info("%s %s %s %s backend=%s", r.Method, r.RemoteAddr, r.URL, r.UserAgent(), backend)
+			if targetURL == nil {
+				logger.Error("Route %s: all backends are drained", proxyRoute.path)
+				RespondWithError(w, http.StatusServiceUnavailable, "Service unavailable")
+				return
+			}
+		} else {
+			logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
+			if IsBackendDrained(proxyRoute.destination) {
+				logger.Error("Route %s: backend %s is drained", proxyRoute.path, proxyRoute.destination)
+				RespondWithError(w, http.StatusServiceUnavailable, "Service unavailable")
+				return
+			}
+		}
 		// Set CORS headers from the cors config
 		//Update Cors Headers
 		for k, v := range proxyRoute.cors.Headers {
@@ -41,38 +121,43 @@ func (proxyRoute ProxyRoute) ProxyHandler() http.HandlerFunc {
 			return
 		}
 		// Parse the target backend URL
-		targetURL, err := url.Parse(proxyRoute.destination)
-		if err != nil {
-			logger.Error("Error parsing backend URL: %s", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			err := json.NewEncoder(w).Encode(ErrorResponse{
-				Message: "Internal server error",
-				Code:    http.StatusInternalServerError,
-				Success: false,
-			})
+		if targetURL == nil {
+			var err error
+			targetURL, err = url.Parse(proxyRoute.destination)
 			if err != nil {
+				logger.Error("Error parsing backend URL: %s", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				err := json.NewEncoder(w).Encode(ErrorResponse{
+					Message: "Internal server error",
+					Code:    http.StatusInternalServerError,
+					Success: false,
+				})
+				if err != nil {
+					return
+				}
 				return
 			}
-			return
 		}
 		// Update the headers to allow for SSL redirection
 		if !proxyRoute.disableXForward {
 			r.URL.Host = targetURL.Host
 			r.URL.Scheme = targetURL.Scheme
 			r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
-			r.Header.Set("X-Forwarded-For", r.RemoteAddr)
-			r.Header.Set("X-Real-IP", r.RemoteAddr)
+			// The reverse proxy appends the peer IP to the X-Forwarded-For chain of the trusted proxies
+			r.Header.Set("X-Real-IP", middleware.ClientIP(r))
 			r.Host = targetURL.Host
 		}
 		// Create proxy
 		proxy := httputil.NewSingleHostReverseProxy(targetURL)
+		proxy.Transport = transport
 		// Rewrite
-		if proxyRoute.path != "" && proxyRoute.rewrite != "" {
-			// Rewrite the path
-			if strings.HasPrefix(r.URL.Path, fmt.Sprintf("%s/", proxyRoute.path)) {
-				r.URL.Path = strings.Replace(r.URL.Path, fmt.Sprintf("%s/", proxyRoute.path), proxyRoute.rewrite, 1)
-			}
+		if rewriter != nil {
+			rewriter.Rewrite(r)
+		}
+		// Send a copy of the request to the mirror backend
+		if trafficMirror != nil {
+			trafficMirror.Mirror(r)
 		}
 		proxy.ModifyResponse = func(response *http.Response) error {
 			if response.StatusCode < 200 || response.StatusCode >= 300 {
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+type RedirectRoute struct {
+	path     string
+	redirect Redirect
+}
+
+// RedirectHandler redirects requests without calling a backend
+func (redirectRoute RedirectRoute) RedirectHandler() http.HandlerFunc {
+	redirect := redirectRoute.redirect
+	rewriter, err := redirect.rewriter(redirectRoute.path)
+	if err != nil {
+		logger.Error("Error creating route %s redirect: %v", redirectRoute.path, err)
+		return unavailableMiddleware(nil).ServeHTTP
+	}
+	code := redirect.statusCode()
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
+		target := r.Clone(r.Context())
+		if redirect.DropQuery {
+			target.URL.RawQuery = ""
+		}
+		// The request would be redirected to itself when the pattern doesn't match
+		if !rewriter.Rewrite(target) && redirect.Pattern != "" {
+			RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Not found: %s", r.URL.Path))
+			return
+		}
+		u := &url.URL{
+			Scheme:   requestScheme(r),
+			Host:     r.Host,
+			Path:     target.URL.Path,
+			RawPath:  target.URL.RawPath,
+			RawQuery: target.URL.RawQuery,
+		}
+		if redirect.HTTPS {
+			u.Scheme = "https"
+			// Default HTTP port must not be kept
+			if host, port, err := net.SplitHostPort(u.Host); err == nil && port == "80" {
+				u.Host = host
+			}
+		}
+		if redirect.Scheme != "" {
+			u.Scheme = redirect.Scheme
+		}
+		if redirect.Host != "" {
+			u.Host = redirect.Host
+		}
+		http.Redirect(w, r, u.String(), code)
+	}
+}
+
+// rewriter returns the redirect path rewriter, Path is the Pattern replacement or replaces the route path prefix
+func (redirect Redirect) rewriter(path string) (*Rewriter, error) {
+	if redirect.Pattern != "" {
+		return NewRewriter(path, "", []RewriteRule{{Pattern: redirect.Pattern, Replacement: redirect.Path}})
+	}
+	return NewRewriter(path, redirect.Path, nil)
+}
+
+// validate checks the redirect settings
+func (redirect Redirect) validate(path string) error {
+	if !redirect.HTTPS && redirect.Scheme == "" && redirect.Host == "" && redirect.Path == "" {
+		return errors.New("redirect requires https, scheme, host or path")
+	}
+	if redirect.Pattern != "" && redirect.Path == "" {
+		return errors.New("redirect pattern requires a path")
+	}
+	switch redirect.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("invalid redirect scheme %s", redirect.Scheme)
+	}
+	switch redirect.Code {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("invalid redirect code %d", redirect.Code)
+	}
+	_, err := redirect.rewriter(path)
+	return err
+}
+
+// statusCode returns the redirect status code
+func (redirect Redirect) statusCode() int {
+	switch redirect.Code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return redirect.Code
+	case 0:
+		if redirect.HTTPS {
+			return http.StatusMovedPermanently
+		}
+		return http.StatusFound
+	default:
+		logger.Error("Invalid redirect code %d, using %d", redirect.Code, http.StatusFound)
+		return http.StatusFound
+	}
+}
+
+// target returns a short description of the redirect target
+func (redirect Redirect) target() string {
+	if redirect.HTTPS {
+		return "https"
+	}
+	target := redirect.Host + redirect.Path
+	if redirect.Scheme != "" {
+		target = fmt.Sprintf("%s://%s", redirect.Scheme, target)
+	}
+	return target
+}
+
+// requestScheme returns the scheme used by the client
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	return "http"
+}
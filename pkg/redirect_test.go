@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectRoute(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     Redirect
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "host", redirect: Redirect{Host: "new.example.com", Code: 308},
+			target: "http://old.example.com/old/docs?page=1", wantCode: 308, wantLocation: "http://new.example.com/old/docs?page=1"},
+		{name: "path prefix", redirect: Redirect{Path: "/new"},
+			target: "http://example.com/old/docs?page=1", wantCode: 302, wantLocation: "http://example.com/new/docs?page=1"},
+		{name: "drop query", redirect: Redirect{Path: "/new", DropQuery: true, Code: 307},
+			target: "http://example.com/old/docs?page=1", wantCode: 307, wantLocation: "http://example.com/new/docs"},
+		{name: "regex", redirect: Redirect{Pattern: `^/old/posts/(\d+)$`, Path: "/blog/$1", Scheme: "https", Code: 301},
+			target: "http://example.com/old/posts/12", wantCode: 301, wantLocation: "https://example.com/blog/12"},
+		{name: "regex without match", redirect: Redirect{Pattern: `^/old/posts/(\d+)$`, Path: "/blog/$1"},
+			target: "http://example.com/old/docs", wantCode: 404},
+		{name: "https", redirect: Redirect{HTTPS: true},
+			target: "http://example.com:80/old/docs?page=1", wantCode: 301, wantLocation: "https://example.com/old/docs?page=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirectRoute := RedirectRoute{path: "/old", redirect: tt.redirect}
+			rec := httptest.NewRecorder()
+			redirectRoute.RedirectHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("expected location %q, got %q", tt.wantLocation, location)
+			}
+		})
+	}
+}
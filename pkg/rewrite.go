@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathVarRegex matches route path variables in rewrite templates, e.g. {id}
+var pathVarRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?::[^}]*)?}`)
+
+// Rewriter rewrites request paths before they are sent to the backend
+type Rewriter struct {
+	// prefix is the route path to strip
+	prefix string
+	// rewrite replaces the stripped prefix
+	rewrite string
+	rules   []regexRewrite
+}
+type regexRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NewRewriter creates a Rewriter from the route path, the prefix rewrite and regex rules
+func NewRewriter(path, rewrite string, rules []RewriteRule) (*Rewriter, error) {
+	rw := &Rewriter{
+		prefix:  strings.TrimSuffix(path, "/"),
+		rewrite: rewrite,
+	}
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern %q: %w", rule.Pattern, err)
+		}
+		rw.rules = append(rw.rules, regexRewrite{pattern: pattern, replacement: rule.Replacement})
+	}
+	return rw, nil
+}
+
+// Rewrite rewrites the request URL in place, it returns false when no rule applied.
+//
+// Regex rules are tried first, the first matching rule wins. Otherwise, the route prefix is replaced by the rewrite path.
+// The raw encoded path is preserved.
+func (rw Rewriter) Rewrite(r *http.Request) bool {
+	vars := mux.Vars(r)
+	escapedPath := r.URL.EscapedPath()
+	for _, rule := range rw.rules {
+		match := rule.pattern.FindStringSubmatchIndex(escapedPath)
+		if match == nil {
+			continue
+		}
+		template := expandPathVars(rule.replacement, vars, true)
+		newPath := string(rule.pattern.ExpandString(nil, template, escapedPath, match))
+		setURLPath(r.URL, newPath)
+		return true
+	}
+	if rw.rewrite == "" {
+		return false
+	}
+	prefix := expandPathVars(rw.prefix, vars, false)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return false
+	}
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	// Only rewrite on a path segment boundary, /store must not match /storefront
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return false
+	}
+	// Keep the escaped form of the remaining path
+	escapedRest := rest
+	if escaped := r.URL.EscapedPath(); strings.HasPrefix(escaped, prefix) {
+		escapedRest = strings.TrimPrefix(escaped, prefix)
+	}
+	setURLPath(r.URL, joinPath(expandPathVars(rw.rewrite, vars, false), escapedRest))
+	return true
+}
+
+// joinPath joins the rewrite path and the remaining path with a single slash
+func joinPath(base, rest string) string {
+	if rest == "" || rest == "/" {
+		if base == "" {
+			return "/"
+		}
+		if rest == "/" && !strings.HasSuffix(base, "/") {
+			return base + "/"
+		}
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + rest
+}
+
+// setURLPath sets an escaped path, with an optional query string, to the URL
+func setURLPath(u *url.URL, escapedPath string) {
+	escapedPath, rawQuery, hasQuery := strings.Cut(escapedPath, "?")
+	if !strings.HasPrefix(escapedPath, "/") {
+		escapedPath = "/" + escapedPath
+	}
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		path = escapedPath
+	}
+	u.Path = path
+	u.RawPath = ""
+	if path != escapedPath {
+		u.RawPath = escapedPath
+	}
+	if hasQuery && rawQuery != "" {
+		if u.RawQuery == "" {
+			u.RawQuery = rawQuery
+		} else {
+			u.RawQuery = rawQuery + "&" + u.RawQuery
+		}
+	}
+}
+
+// expandPathVars replaces {name} with the route path variable value.
+//
+// When skipRegexVars is true, ${name} is left for the regex expansion.
+func expandPathVars(template string, vars map[string]string, skipRegexVars bool) string {
+	if len(vars) == 0 || !strings.Contains(template, "{") {
+		return template
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range pathVarRegex.FindAllStringSubmatchIndex(template, -1) {
+		start, end := loc[0], loc[1]
+		name := template[loc[2]:loc[3]]
+		value, ok := vars[name]
+		if !ok || (skipRegexVars && start > 0 && template[start-1] == '$') {
+			continue
+		}
+		b.WriteString(template[last:start])
+		b.WriteString(url.PathEscape(value))
+		last = end
+	}
+	b.WriteString(template[last:])
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"github.com/gorilla/mux"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		rewrite   string
+		rules     []RewriteRule
+		vars      map[string]string
+		target    string
+		wantPath  string
+		wantRaw   string
+		wantQuery string
+	}{
+		{name: "route root", path: "/store", rewrite: "/", target: "/store", wantPath: "/"},
+		{name: "prefix replace", path: "/store", rewrite: "/v1", target: "/store/items", wantPath: "/v1/items"},
+		{name: "prefix strip", path: "/store", rewrite: "/", target: "/store/items/", wantPath: "/items/"},
+		{name: "segment boundary", path: "/store", rewrite: "/v1", target: "/storefront", wantPath: "/storefront"},
+		{name: "encoded path", path: "/store", rewrite: "/v1", target: "/store/a%2Fb", wantPath: "/v1/a/b", wantRaw: "/v1/a%2Fb"},
+		{name: "path variables", path: "/customers/{id}", rewrite: "/v1/customers/{id}", vars: map[string]string{"id": "42"},
+			target: "/customers/42/orders", wantPath: "/v1/customers/42/orders"},
+		{name: "regex capture", path: "/users", rules: []RewriteRule{{Pattern: `^/users/(\d+)/orders`, Replacement: "/orders?user=$1"}},
+			target: "/users/12/orders?page=2", wantPath: "/orders", wantQuery: "user=12&page=2"},
+		{name: "regex no match falls back to rewrite", path: "/users", rewrite: "/", rules: []RewriteRule{{Pattern: `^/users/(\d+)/orders`, Replacement: "/orders?user=$1"}},
+			target: "/users/me", wantPath: "/me"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := NewRewriter(tt.path, tt.rewrite, tt.rules)
+			if err != nil {
+				t.Fatalf("Error creating rewriter: %v", err)
+			}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.vars != nil {
+				r = mux.SetURLVars(r, tt.vars)
+			}
+			rw.Rewrite(r)
+			if r.URL.Path != tt.wantPath {
+				t.Errorf("expected path %q, got %q", tt.wantPath, r.URL.Path)
+			}
+			if r.URL.RawPath != tt.wantRaw {
+				t.Errorf("expected raw path %q, got %q", tt.wantRaw, r.URL.RawPath)
+			}
+			if tt.wantQuery != "" && r.URL.RawQuery != tt.wantQuery {
+				t.Errorf("expected query %q, got %q", tt.wantQuery, r.URL.RawQuery)
+			}
+		})
+	}
+}
+
+func TestRewriterInvalidPattern(t *testing.T) {
+	_, err := NewRewriter("/store", "", []RewriteRule{{Pattern: "(", Replacement: "/"}})
+	if err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
@@ -7,12 +7,16 @@ import (
 	"github.com/jkaninda/goma/internal/logger"
 	"github.com/jkaninda/goma/pkg/middleware"
 	"github.com/jkaninda/goma/util"
-	"time"
+	"net"
+	"net/http"
+	"strings"
 )
 
-func (gatewayServer GatewayServer) Initialize() *mux.Router {
+func (gatewayServer *GatewayServer) Initialize() *mux.Router {
 	gateway := gatewayServer.gateway
 	middlewares := gatewayServer.middlewares
+	// Consul agent of the consul discovery
+	consulSettings.Store(&gateway.Providers.Consul)
 	r := mux.NewRouter()
 	heath := HealthCheckRoute{
 		DisableRouteHealthCheckError: gateway.DisableRouteHealthCheckError,
@@ -20,11 +24,22 @@ func (gatewayServer GatewayServer) Initialize() *mux.Router {
 	}
 	// Define the health check route
 	r.HandleFunc("/health", heath.HealthCheckHandler).Methods("GET")
+	trustedProxies, err := middleware.ParseCIDRs(gateway.TrustedProxies)
+	if err != nil {
+		logger.Error("Error parsing trusted proxies: %v", err)
+	}
+	// Resolve the client IP
+	r.Use(middleware.ClientIPMiddleware(trustedProxies))
+	// Require and forward client certificates
+	if clientAuth := gateway.TLS.ClientAuth; clientAuth.CAFile != "" || clientAuth.Required || len(clientAuth.Hosts) != 0 {
+		r.Use(clientCertMiddleware(clientAuth))
+	}
 	// Apply global Cors middlewares
 	r.Use(CORSHandler(gateway.Cors)) // Apply CORS middleware
 	if gateway.RateLimiter != 0 {
 		//rateLimiter := middleware.NewRateLimiter(gateway.RateLimiter, time.Minute)
-		limiter := middleware.NewRateLimiterWindow(gateway.RateLimiter, time.Minute) //  requests per minute
+		limiter := gatewayServer.instances.globalRateLimiter(gateway.RateLimiter) //  requests per minute
+		gatewayServer.rateLimiter = limiter
 		// Add rate limit middleware to all routes, if defined
 		r.Use(limiter.RateLimitMiddleware())
 	}
@@ -35,84 +50,168 @@ func (gatewayServer GatewayServer) Initialize() *mux.Router {
 		}
 		// Add block access middleware to all route, if defined
 		r.Use(blM.BlocklistMiddleware)
-		//if route.Middlewares != nil {
+		handler := routeHandler(route)
+		hostRouter := r
+		if len(route.Hosts) != 0 {
+			hostRouter = r.MatcherFunc(hostMatcher(route.Hosts)).Subrouter()
+		}
 		for _, mid := range route.Middlewares {
-			secureRouter := r.PathPrefix(util.ParseURLPath(route.Path + mid.Path)).Subrouter()
-			proxyRoute := ProxyRoute{
-				path:            route.Path,
-				rewrite:         route.Rewrite,
-				destination:     route.Destination,
-				disableXForward: route.DisableHeaderXForward,
-				cors:            route.Cors,
-			}
-			rMiddleware, err := searchMiddleware(mid.Rules, middlewares)
+			secureRouter := hostRouter.PathPrefix(util.ParseURLPath(route.Path + mid.Path)).Subrouter()
+			chain, err := middlewareChain(route, mid, middlewares, gatewayServer.instances)
 			if err != nil {
-				logger.Error("MiddlewareName not found")
-			} else {
-				switch rMiddleware.Type {
-				case "basic":
-					basicAuth, err := ToBasicAuth(rMiddleware.Rule)
-					if err != nil {
+				logger.Error("Route %s, path %s: %v", route.Name, mid.Path, err)
+				// Never expose a path protected by a misconfigured middleware
+				chain = []mux.MiddlewareFunc{unavailableMiddleware}
+			}
+			// Apply the middlewares chain in declared order
+			secureRouter.Use(chain...)
+			secureRouter.Use(CORSHandler(route.Cors))
+			secureRouter.PathPrefix("/").Handler(handler) // Route handler
+			secureRouter.PathPrefix("").Handler(handler)  // Route handler
+		}
+		router := hostRouter.PathPrefix(route.Path).Subrouter()
+		router.Use(CORSHandler(route.Cors))
+		router.PathPrefix("/").Handler(handler)
+	}
+	return r
 
-						logger.Error("Error: %s", err.Error())
-					} else {
-						amw := middleware.AuthBasic{
-							Username: basicAuth.Username,
-							Password: basicAuth.Password,
-							Headers:  nil,
-							Params:   nil,
-						}
-						// Apply JWT authentication middleware
-						secureRouter.Use(amw.AuthMiddleware)
-						secureRouter.Use(CORSHandler(route.Cors))
-						secureRouter.PathPrefix("/").Handler(proxyRoute.ProxyHandler()) // Proxy handler
-						secureRouter.PathPrefix("").Handler(proxyRoute.ProxyHandler())  // Proxy handler
-					}
-				case "jwt":
-					jwt, err := ToJWTRuler(rMiddleware.Rule)
-					if err != nil {
+}
 
-					} else {
-						amw := middleware.AuthJWT{
-							AuthURL:         jwt.URL,
-							RequiredHeaders: jwt.RequiredHeaders,
-							Headers:         jwt.Headers,
-							Params:          jwt.Params,
-						}
-						// Apply JWT authentication middleware
-						secureRouter.Use(amw.AuthMiddleware)
-						secureRouter.Use(CORSHandler(route.Cors))
-						secureRouter.PathPrefix("/").Handler(proxyRoute.ProxyHandler()) // Proxy handler
-						secureRouter.PathPrefix("").Handler(proxyRoute.ProxyHandler())  // Proxy handler
-					}
-				default:
-					logger.Error("Unknown middleware type %s", rMiddleware.Type)
+// hostMatcher matches the requests of the route hosts
+func hostMatcher(hosts []string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		return matchHost(hosts, host)
+	}
+}
 
-				}
+// routeHandler returns the route handler depending on the route type
+func routeHandler(route Route) http.Handler {
+	switch route.Type {
+	case RouteTypeRedirect:
+		redirectRoute := RedirectRoute{
+			path:     route.Path,
+			redirect: route.Redirect,
+		}
+		return redirectRoute.RedirectHandler()
+	case RouteTypeStatic:
+		staticRoute := StaticRoute{
+			path:   route.Path,
+			static: route.Static,
+		}
+		return staticRoute.StaticHandler()
+	case RouteTypeMock:
+		mockRoute := MockRoute{
+			path: route.Path,
+			mock: route.Mock,
+		}
+		return mockRoute.MockHandler()
+	case "", RouteTypeProxy:
+	default:
+		logger.Error("Route %s: unknown route type %s, using %s", route.Name, route.Type, RouteTypeProxy)
+	}
+	proxyRoute := ProxyRoute{
+		path:            route.Path,
+		rewrite:         route.Rewrite,
+		rewriteRules:    route.RewriteRules,
+		destination:     route.Destination,
+		disableXForward: route.DisableHeaderXForward,
+		cors:            route.Cors,
+		name:            route.Name,
+		mirror:          route.Mirror,
+		backends:        route.Backends,
+		discovery:       route.Discovery,
+		sticky:          route.Sticky,
+		upstreamTLS:     route.UpstreamTLS,
+	}
+	return proxyRoute.ProxyHandler()
+}
 
+// validateRoutes checks the route settings, invalid routes are rejected when the configuration is loaded
+func validateRoutes(routes []Route) error {
+	for _, route := range routes {
+		switch route.Type {
+		case RouteTypeRedirect:
+			if err := route.Redirect.validate(route.Path); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+			continue
+		case RouteTypeStatic:
+			if route.Static.Dir == "" {
+				return fmt.Errorf("route %s: static dir is required", route.Name)
 			}
+			continue
+		case RouteTypeMock:
+			if err := route.Mock.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+			continue
+		case "", RouteTypeProxy:
+		default:
+			continue
 		}
-		proxyRoute := ProxyRoute{
-			path:            route.Path,
-			rewrite:         route.Rewrite,
-			destination:     route.Destination,
-			disableXForward: route.DisableHeaderXForward,
-			cors:            route.Cors,
+		if _, err := NewRewriter(route.Path, route.Rewrite, route.RewriteRules); err != nil {
+			return fmt.Errorf("route %s: %w", route.Name, err)
+		}
+		if len(route.Backends) != 0 {
+			if _, err := NewTrafficSplitter(route.Path, route.Backends, route.Sticky); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+		}
+		if route.Discovery.Type != "" {
+			if err := route.Discovery.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+		}
+		if route.Mirror.URL != "" {
+			if err := route.Mirror.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
 		}
-
-		router := r.PathPrefix(route.Path).Subrouter()
-		router.Use(CORSHandler(route.Cors))
-		router.PathPrefix("/").Handler(proxyRoute.ProxyHandler())
 	}
-	return r
-
+	return nil
 }
 
-func printRoute(routes []Route) {
+func printRoute(routes []Route, middlewares []Middleware) {
 	t := table.NewWriter()
-	t.AppendHeader(table.Row{"Name", "Route", "Rewrite", "Destination"})
+	t.AppendHeader(table.Row{"Name", "Route", "Rewrite", "Destination", "Middlewares"})
 	for _, route := range routes {
-		t.AppendRow(table.Row{route.Name, route.Path, route.Rewrite, route.Destination})
+		var chains []string
+		for _, mid := range route.Middlewares {
+			chains = append(chains, fmt.Sprintf("%s: %s", util.ParseURLPath(route.Path+mid.Path), describeChain(mid, middlewares)))
+		}
+		path := route.Path
+		if len(route.Hosts) != 0 {
+			path = strings.Join(route.Hosts, ",") + route.Path
+		}
+		t.AppendRow(table.Row{route.Name, path, route.Rewrite, routeDestination(route), strings.Join(chains, "\n")})
 	}
 	fmt.Println(t.Render())
 }
+
+// routeDestination returns the route destination displayed on start
+func routeDestination(route Route) string {
+	switch route.Type {
+	case RouteTypeRedirect:
+		return fmt.Sprintf("redirect: %s", route.Redirect.target())
+	case RouteTypeStatic:
+		return fmt.Sprintf("static: %s", route.Static.Dir)
+	case RouteTypeMock:
+		return fmt.Sprintf("mock: %d responses", len(route.Mock.Responses))
+	default:
+		if route.Discovery.Type != "" {
+			return fmt.Sprintf("%s: %s", route.Discovery.Type, route.Discovery.target())
+		}
+		if len(route.Backends) != 0 {
+			var backends []string
+			for _, backend := range route.Backends {
+				backends = append(backends, fmt.Sprintf("%s (%d%%)", backend.Destination, backend.Weight))
+			}
+			return strings.Join(backends, ", ")
+		}
+		return route.Destination
+	}
+}
@@ -0,0 +1,405 @@
+package pkg
+
+import (
+	_ "embed"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// configSource is the configuration structs source, their comments are the schema descriptions
+//
+//go:embed config.go
+var configSource []byte
+
+// middlewareRules contains the rule of each middleware type
+var middlewareRules = map[string]interface{}{
+	"basic":            BasicRule{},
+	"jwt":              JWTRuler{},
+	"forwardAuth":      JWTRuler{},
+	"access":           AccessRule{},
+	"apiKey":           APIKeyRule{},
+	"oauth2Introspect": OAuth2IntrospectRule{},
+	"oidc":             OIDCRule{},
+	"signature":        SignatureRule{},
+	"mtls":             MTLSRule{},
+}
+
+// enumFields contains the allowed values of the configuration fields, by Type.Field, empty values are the defaults
+var enumFields = map[string][]string{
+	"Route.Type":           {"", RouteTypeProxy, RouteTypeRedirect, RouteTypeStatic, RouteTypeMock},
+	"RouteMiddleware.Mode": {"", MiddlewareModeAllOf, MiddlewareModeAnyOf},
+	"Discovery.Type":       {"", DiscoveryTypeDNS, DiscoveryTypeSRV, DiscoveryTypeFile, DiscoveryTypeConsul},
+}
+
+// requiredFields contains the required fields of the configuration structs
+var requiredFields = map[string][]string{
+	"Route":      {"name", "path"},
+	"Middleware": {"name", "type"},
+	"Backend":    {"destination"},
+}
+
+// ConfigSchema returns the JSON Schema of the configuration file, generated from GatewayConfig.
+//
+// Middleware rules are discriminated by the middleware type, unknown fields are not allowed
+func ConfigSchema() map[string]interface{} {
+	g := &schemaGenerator{defs: map[string]interface{}{}, descriptions: configDescriptions()}
+	schema := g.structSchema(reflect.TypeOf(GatewayConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Goma Gateway configuration"
+	var types []string
+	for name, rule := range middlewareRules {
+		types = append(types, name)
+		g.schema(reflect.TypeOf(rule))
+	}
+	sort.Strings(types)
+	middleware := g.defs["Middleware"].(map[string]interface{})
+	properties := middleware["properties"].(map[string]interface{})
+	properties["type"].(map[string]interface{})["enum"] = types
+	var rules []interface{}
+	for _, name := range types {
+		rules = append(rules, map[string]interface{}{
+			"if": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"type": map[string]interface{}{"const": name}},
+				"required":   []string{"type"},
+			},
+			"then": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"rule": map[string]interface{}{"$ref": "#/$defs/" + reflect.TypeOf(middlewareRules[name]).Name()}},
+			},
+		})
+	}
+	middleware["allOf"] = rules
+	// Schema of the extra configuration directory files
+	g.schema(reflect.TypeOf(ExtraRouteConfig{}))
+	schema["$defs"] = g.defs
+	return schema
+}
+
+type schemaGenerator struct {
+	defs         map[string]interface{}
+	descriptions map[string]string
+}
+
+// schema returns the schema of a type, named structs are references to $defs
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			// Reserve the name first, structs can be recursive
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	default:
+		// interface{}, e.g. the middleware rule
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the schema of a struct, with the yaml field names and the field comments as descriptions
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := yamlFieldName(field)
+		if name == "-" {
+			continue
+		}
+		property := g.schema(field.Type)
+		if enum := enumFields[t.Name()+"."+field.Name]; len(enum) != 0 {
+			property["enum"] = enum
+		}
+		if description := g.descriptions[t.Name()+"."+field.Name]; description != "" {
+			if _, ok := property["$ref"]; ok {
+				// Keywords next to $ref are allowed since draft 2019-09
+				property = map[string]interface{}{"$ref": property["$ref"], "description": description}
+			} else {
+				property["description"] = description
+			}
+		}
+		properties[name] = property
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if description := g.descriptions[t.Name()]; description != "" {
+		schema["description"] = description
+	}
+	if required := requiredFields[t.Name()]; len(required) != 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// yamlFieldName returns the yaml name of a field, the lower case field name by default
+func yamlFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// configDescriptions returns the comments of the configuration structs and fields, by Type and Type.Field
+func configDescriptions() map[string]string {
+	descriptions := map[string]string{}
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", configSource, parser.ParseComments)
+	if err != nil {
+		return descriptions
+	}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			doc := typeSpec.Doc
+			if doc == nil {
+				doc = gen.Doc
+			}
+			descriptions[typeSpec.Name.Name] = commentText(doc)
+			for _, field := range structType.Fields.List {
+				doc := field.Doc
+				if doc == nil {
+					doc = field.Comment
+				}
+				for _, name := range field.Names {
+					descriptions[typeSpec.Name.Name+"."+name.Name] = commentText(doc)
+				}
+			}
+		}
+	}
+	return descriptions
+}
+
+// commentText returns the text of a comment, paragraphs are separated by a new line
+func commentText(comment *ast.CommentGroup) string {
+	if comment == nil {
+		return ""
+	}
+	paragraphs := strings.Split(strings.TrimSpace(comment.Text()), "\n\n")
+	for i, paragraph := range paragraphs {
+		paragraphs[i] = strings.Join(strings.Fields(paragraph), " ")
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+// ValidateConfig validates the configuration file and the extra configuration directory files against the configuration schema,
+// then loads the configuration and checks the middlewares references.
+//
+// It returns the errors with the file and the path of the invalid values, null values are allowed
+func ValidateConfig(configFile string) ([]string, error) {
+	schema := ConfigSchema()
+	defs := schema["$defs"].(map[string]interface{})
+	validateFile := func(file string, schema map[string]interface{}, typ reflect.Type) ([]string, []byte, error) {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		node, err := interpolatedNode(buf, typ)
+		if err != nil {
+			return nil, nil, fmt.Errorf("in file %q: %w", file, err)
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf("in file %q: %w", file, err)
+		}
+		v := &schemaValidator{defs: defs}
+		v.validate(schema, value, "")
+		for i := range v.errors {
+			v.errors[i] = file + ": " + v.errors[i]
+		}
+		return v.errors, buf, nil
+	}
+	errors, buf, err := validateFile(configFile, schema, reflect.TypeOf(GatewayConfig{}))
+	if err != nil {
+		return nil, err
+	}
+	// Only the extra configuration is decoded, invalid values are already reported
+	var main struct {
+		Gateway struct {
+			ExtraConfig ExtraConfig `yaml:"extraConfig"`
+		} `yaml:"gateway"`
+	}
+	_ = decodeConfig(buf, &main)
+	files, err := extraConfigFiles(configFile, main.Gateway.ExtraConfig.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		fileErrors, _, err := validateFile(file, map[string]interface{}{"$ref": "#/$defs/ExtraRouteConfig"}, reflect.TypeOf(ExtraRouteConfig{}))
+		if err != nil {
+			return nil, err
+		}
+		errors = append(errors, fileErrors...)
+	}
+	c, err := loadConfig(configFile)
+	if err != nil {
+		return append(errors, err.Error()), nil
+	}
+	middlewares := map[string]bool{}
+	for _, m := range c.Middlewares {
+		middlewares[m.Name] = true
+	}
+	for _, route := range c.GatewayConfig.Routes {
+		for _, mid := range route.Middlewares {
+			for _, rule := range mid.Rules {
+				if !middlewares[rule] {
+					errors = append(errors, fmt.Sprintf("route %s: %s: middleware %s not found", route.Name, mid.Path, rule))
+				}
+			}
+		}
+	}
+	return errors, nil
+}
+
+type schemaValidator struct {
+	defs   map[string]interface{}
+	errors []string
+}
+
+func (v *schemaValidator) errorf(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.errors = append(v.errors, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// validate validates the subset of JSON Schema generated by ConfigSchema
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		v.validate(v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{}), value, path)
+	}
+	if value == nil {
+		return
+	}
+	if constValue, ok := schema["const"]; ok && fmt.Sprint(value) != fmt.Sprint(constValue) {
+		v.errorf(path, "expected %v", constValue)
+		return
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		found := false
+		for _, e := range enum {
+			found = found || fmt.Sprint(value) == e
+		}
+		if !found {
+			v.errorf(path, "unknown value %q, expected one of %q", fmt.Sprint(value), enum)
+		}
+	}
+	switch schema["type"] {
+	case "string":
+		// YAML scalars are decoded as strings
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			v.errorf(path, "expected a string, got %v", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.errorf(path, "expected a boolean, got %v", value)
+		}
+	case "integer":
+		switch value.(type) {
+		case int, int64, uint64:
+		default:
+			v.errorf(path, "expected an integer, got %v", value)
+		}
+	case "number":
+		switch value.(type) {
+		case int, int64, uint64, float64:
+		default:
+			v.errorf(path, "expected a number, got %v", value)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.errorf(path, "expected a list, got %v", value)
+			return
+		}
+		for i, item := range items {
+			v.validate(schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected an object, got %v", value)
+			return
+		}
+		v.validateObject(schema, object, path)
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			s := s.(map[string]interface{})
+			matcher := &schemaValidator{defs: v.defs}
+			matcher.validate(s["if"].(map[string]interface{}), value, path)
+			if len(matcher.errors) == 0 {
+				v.validate(s["then"].(map[string]interface{}), value, path)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, object map[string]interface{}, path string) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property := key
+		if path != "" {
+			property = path + "." + key
+		}
+		if s, ok := properties[key].(map[string]interface{}); ok {
+			v.validate(s, object[key], property)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional && properties != nil {
+				v.errorf(property, "unknown field")
+			}
+		case map[string]interface{}:
+			v.validate(additional, object[key], property)
+		}
+	}
+	if required, ok := schema["required"].([]string); ok {
+		for _, name := range required {
+			if _, ok := object[name]; !ok {
+				v.errorf(path, "missing required field %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	defs := schema["$defs"].(map[string]interface{})
+	route := defs["Route"].(map[string]interface{})
+	path := route["properties"].(map[string]interface{})["path"].(map[string]interface{})
+	if path["description"] != "Path defines route path" {
+		t.Errorf("expected the field comment as description, got %q", path["description"])
+	}
+	middleware := defs["Middleware"].(map[string]interface{})
+	found := false
+	for _, s := range middleware["allOf"].([]interface{}) {
+		s := s.(map[string]interface{})
+		condition := s["if"].(map[string]interface{})["properties"].(map[string]interface{})["type"].(map[string]interface{})
+		rule := s["then"].(map[string]interface{})["properties"].(map[string]interface{})["rule"].(map[string]interface{})
+		if condition["const"] == "basic" {
+			found = rule["$ref"] == "#/$defs/BasicRule"
+		}
+	}
+	if !found {
+		t.Error("expected the basic middleware rule schema")
+	}
+
+	// The repository schema is generated by goma config schema
+	buf, err := os.ReadFile("../goma.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, _ := json.Marshal(schema)
+	var got, want interface{}
+	_ = json.Unmarshal(buf, &got)
+	_ = json.Unmarshal(generated, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("goma.schema.json is outdated, run goma config schema -o goma.schema.json")
+	}
+
+	errors, err := ValidateConfig("../goma.yml")
+	if err != nil {
+		t.Fatalf("Error validating config: %v", err)
+	}
+	if len(errors) != 0 {
+		t.Errorf("expected a valid configuration, got %v", errors)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "goma.yml")
+	writeConfigFile(t, configFile, `
+gateway:
+  listenAddr: 0.0.0.0:8080
+  writeTimout: 15
+  extraConfig:
+    directory: conf.d
+  routes:
+    - name: main
+      path: /
+      destination: http://main:8080
+      middlewares:
+        - path: /admin
+          rules:
+            - admin-auth
+    - name: missing-path
+      destination: http://main:8080
+middlewares:
+  - name: basic-auth
+    type: basic
+    rule:
+      username: admin
+      pasword: admin
+  - name: rate-limit
+    type: rateLimit
+`)
+	writeConfigFile(t, filepath.Join(dir, "conf.d", "users.yml"), `
+routes:
+  - name: users
+    path: /users
+    backends:
+      - destination: http://users:8080
+        wieght: 2
+`)
+	errors, err := ValidateConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error validating config: %v", err)
+	}
+	expected := []string{
+		"gateway.writeTimout: unknown field",
+		"gateway.routes[1]: missing required field path",
+		"middlewares[0].rule.pasword: unknown field",
+		`middlewares[1].type: unknown value "rateLimit"`,
+		"users.yml: routes[0].backends[0].wieght: unknown field",
+		"route main: /admin: middleware admin-auth not found",
+	}
+	for _, e := range expected {
+		found := false
+		for _, got := range errors {
+			found = found || strings.Contains(got, e)
+		}
+		if !found {
+			t.Errorf("expected error %q, got %v", e, errors)
+		}
+	}
+	if len(errors) != len(expected) {
+		t.Errorf("expected %d errors, got %d: %v", len(expected), len(errors), errors)
+	}
+
+	// Invalid values are reported with the loading error
+	writeConfigFile(t, configFile, `
+gateway:
+  routes:
+    - name: main
+      path: /
+      disableHeaderXForward: nope
+`)
+	errors, err = ValidateConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error validating config: %v", err)
+	}
+	if len(errors) != 2 || !strings.Contains(errors[0], "gateway.routes[0].disableHeaderXForward: expected a boolean") {
+		t.Errorf("expected the invalid boolean, got %v", errors)
+	}
+
+	// Invalid discoveries are refused
+	writeConfigFile(t, configFile, `
+gateway:
+  routes:
+    - name: main
+      path: /
+      discovery:
+        type: consul
+`)
+	errors, err = ValidateConfig(configFile)
+	if err != nil {
+		t.Fatalf("Error validating config: %v", err)
+	}
+	if len(errors) != 1 || errors[0] != "route main: consul discovery requires a service" {
+		t.Errorf("expected the invalid discovery, got %v", errors)
+	}
+}
@@ -1,14 +1,19 @@
 package pkg
 
 import (
+	"context"
+	"fmt"
 	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/util"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func (gatewayServer GatewayServer) Start() {
+func (gatewayServer *GatewayServer) Start() {
 	logger.Info("Initializing routes...")
-	route := gatewayServer.Initialize()
+	route := newGatewayRuntime(gatewayServer)
 	logger.Info("Initializing routes...done")
 	srv := &http.Server{
 		Addr:         gatewayServer.gateway.ListenAddr,
@@ -18,7 +23,48 @@ func (gatewayServer GatewayServer) Start() {
 		Handler:      route, // Pass our instance of gorilla/mux in.
 	}
 	if !gatewayServer.gateway.DisableDisplayRouteOnStart {
-		printRoute(gatewayServer.gateway.Routes)
+		printRoute(gatewayServer.gateway.Routes, gatewayServer.middlewares)
+	}
+	if tlsConfig := gatewayServer.gateway.TLS; tlsConfig.enabled() {
+		config, err := tlsConfig.serverConfig()
+		if err != nil {
+			logger.Fatal("Error loading TLS config: %v", err)
+		}
+		tlsSrv := &http.Server{
+			Addr:         tlsConfig.listenAddr(),
+			WriteTimeout: srv.WriteTimeout,
+			ReadTimeout:  srv.ReadTimeout,
+			IdleTimeout:  srv.IdleTimeout,
+			Handler:      route,
+			TLSConfig:    config,
+		}
+		go func() {
+			logger.Info("Started Goma Gateway TLS server on %v", tlsSrv.Addr)
+			if err := tlsSrv.ListenAndServeTLS("", ""); err != nil {
+				logger.Fatal("Error starting Goma Gateway TLS server: %v", err)
+			}
+		}()
+	}
+	if gatewayServer.gateway.ExtraConfig.Watch {
+		go route.watchConfig()
+	}
+	route.startProviders(context.Background(), gatewayServer.providers())
+	if gatewayServer.gateway.Providers.Consul.Register.Enabled {
+		if err := registerConsul(gatewayServer.gateway); err != nil {
+			logger.Error("Error registering the gateway in Consul: %v", err)
+		}
+	}
+	if gatewayServer.gateway.Admin.ListenAddr != "" {
+		admin, err := NewAdminServer(route)
+		if err != nil {
+			logger.Fatal("Error creating admin API: %v", err)
+		}
+		go func() {
+			logger.Info("Started Goma Gateway admin API on %v", gatewayServer.gateway.Admin.ListenAddr)
+			if err := admin.ListenAndServe(); err != nil {
+				logger.Fatal("Error starting Goma Gateway admin API: %v", err)
+			}
+		}()
 	}
 	logger.Info("Started Goma Gateway server on %v", gatewayServer.gateway.ListenAddr)
 	if err := srv.ListenAndServe(); err != nil {
@@ -26,6 +72,58 @@ func (gatewayServer GatewayServer) Start() {
 	}
 
 }
+
+// gatewayRuntime serves the current routes, they are replaced on configuration reload
+type gatewayRuntime struct {
+	handler atomic.Value
+	// gateway is the running gateway server, with the providers routes
+	gateway atomic.Pointer[GatewayServer]
+	// mu serializes configuration reloads and providers updates
+	mu sync.Mutex
+	// base is the configuration files gateway server
+	base *GatewayServer
+	// providers contains the last configuration of each provider
+	providers map[string]ProviderConfig
+	// instances keeps the stateful middlewares and the rate limiter across reloads
+	instances *middlewareInstances
+}
+
+// newGatewayRuntime initializes the gateway server routes
+func newGatewayRuntime(gatewayServer *GatewayServer) *gatewayRuntime {
+	rt := &gatewayRuntime{}
+	rt.apply(gatewayServer)
+	return rt
+}
+
+func (rt *gatewayRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*rt.handler.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// current returns the running gateway server
+func (rt *gatewayRuntime) current() *GatewayServer {
+	return rt.gateway.Load()
+}
+
+// Reload reads the configuration files and replaces the routes, the running routes are kept on error.
+//
+// Listen addresses, TLS and admin settings require a restart.
+func (rt *gatewayRuntime) Reload() error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	current := rt.current()
+	if !util.FileExists(current.configFile) {
+		return fmt.Errorf("configuration file not found: %s", current.configFile)
+	}
+	gatewayServer, err := GatewayServer{}.New(current.configFile)
+	if err != nil {
+		return err
+	}
+	logger.Info("Reloading configuration from %s", current.configFile)
+	rt.apply(gatewayServer)
+	logger.Info("Configuration reloaded, %d routes", len(gatewayServer.gateway.Routes))
+	return nil
+}
+
 func Stop() {
 
 }
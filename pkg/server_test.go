@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -50,3 +51,72 @@ func TestStart(t *testing.T) {
 	})
 
 }
+
+func TestGatewayRuntimeInstances(t *testing.T) {
+	gatewayServer := func(consumer string) *GatewayServer {
+		return &GatewayServer{
+			gateway: Gateway{RateLimiter: 10, Routes: []Route{{Name: "store", Path: "/store", Destination: "http://store:8080",
+				Middlewares: []RouteMiddleware{{Path: "/", Rules: []string{"api-key"}}}}}},
+			middlewares: []Middleware{{Name: "api-key", Type: "apiKey", Rule: APIKeyRule{Keys: []APIKey{
+				{Consumer: consumer, Hash: "sha256:" + strings.Repeat("0", 64)}}}}},
+		}
+	}
+	rt := newGatewayRuntime(gatewayServer("partner"))
+	limiter := rt.current().rateLimiter
+	instance := func() *middlewareInstance {
+		if len(rt.instances.instances) != 1 {
+			t.Fatalf("expected 1 middleware instance, got %d", len(rt.instances.instances))
+		}
+		for _, instance := range rt.instances.instances {
+			return instance
+		}
+		return nil
+	}
+	first := instance()
+
+	// Unchanged middlewares and rate limit are kept
+	rt.apply(gatewayServer("partner"))
+	if instance() != first || rt.current().rateLimiter != limiter {
+		t.Error("expected the middleware and the rate limiter to be kept")
+	}
+	// Changed middlewares are replaced, the previous instance is released
+	rt.apply(gatewayServer("other"))
+	if instance() == first {
+		t.Error("expected the changed middleware to be replaced")
+	}
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Forwarded-For") + "|" + r.Header.Get("X-Real-IP")))
+	}))
+	defer backend.Close()
+	rt := newGatewayRuntime(&GatewayServer{gateway: Gateway{TrustedProxies: []string{"10.0.0.0/8"},
+		Routes: []Route{{Name: "store", Path: "/store", Destination: backend.URL}}}})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:4000", want: "203.0.113.7|203.0.113.7"},
+		{name: "spoofed headers", remoteAddr: "203.0.113.7:4000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1"},
+			want: "203.0.113.7|203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:4000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3"},
+			want: "198.51.100.1, 10.0.0.3, 10.0.0.2|198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/store/items", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, r)
+			if rec.Body.String() != tt.want {
+				t.Errorf("expected the forwarded headers %q, got %q", tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+)
+
+// TrafficSplitter splits the route traffic between weighted backends
+type TrafficSplitter struct {
+	path        string
+	backends    []weightedBackend
+	totalWeight int
+	sticky      Sticky
+}
+type weightedBackend struct {
+	Backend
+	target *url.URL
+}
+
+// NewTrafficSplitter creates a TrafficSplitter from the route backends
+func NewTrafficSplitter(path string, backends []Backend, sticky Sticky) (*TrafficSplitter, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backend defined")
+	}
+	ts := &TrafficSplitter{path: path, sticky: sticky}
+	names := map[string]bool{}
+	for i, backend := range backends {
+		if backend.Name == "" {
+			backend.Name = fmt.Sprintf("backend-%d", i+1)
+		}
+		if names[backend.Name] {
+			return nil, fmt.Errorf("duplicate backend name %q", backend.Name)
+		}
+		names[backend.Name] = true
+		if backend.Weight < 0 {
+			return nil, fmt.Errorf("backend %s: invalid weight %d", backend.Name, backend.Weight)
+		}
+		target, err := url.Parse(backend.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: error parsing destination: %w", backend.Name, err)
+		}
+		ts.backends = append(ts.backends, weightedBackend{Backend: backend, target: target})
+		ts.totalWeight += backend.Weight
+	}
+	return ts, nil
+}
+
+// Choose returns the backend of the request.
+//
+// Match rules come first, then the sticky assignment, then a weighted random choice.
+// Drained backends are skipped, it returns a nil URL when all backends are drained.
+func (ts *TrafficSplitter) Choose(w http.ResponseWriter, r *http.Request) (string, *url.URL) {
+	backends, totalWeight := ts.available()
+	if len(backends) == 0 {
+		return "", nil
+	}
+	for _, backend := range backends {
+		if backend.matches(r) {
+			return backend.Name, backend.target
+		}
+	}
+	if ts.sticky.Header != "" {
+		if value := r.Header.Get(ts.sticky.Header); value != "" {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(value))
+			backend := byWeight(backends, int(h.Sum32()%uint32(max(totalWeight, 1))))
+			return backend.Name, backend.target
+		}
+	}
+	if ts.sticky.Cookie != "" {
+		if cookie, err := r.Cookie(ts.sticky.Cookie); err == nil {
+			for _, backend := range backends {
+				if backend.Name == cookie.Value && backend.Weight > 0 {
+					return backend.Name, backend.target
+				}
+			}
+		}
+	}
+	backend := byWeight(backends, rand.IntN(max(totalWeight, 1)))
+	if ts.sticky.Cookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     ts.sticky.Cookie,
+			Value:    backend.Name,
+			Path:     ts.path,
+			HttpOnly: true,
+		})
+	}
+	return backend.Name, backend.target
+}
+
+// available returns the backends not drained and their total weight
+func (ts *TrafficSplitter) available() ([]weightedBackend, int) {
+	var backends []weightedBackend
+	totalWeight := 0
+	for _, backend := range ts.backends {
+		if IsBackendDrained(backend.Destination) {
+			continue
+		}
+		backends = append(backends, backend)
+		totalWeight += backend.Weight
+	}
+	if len(backends) == len(ts.backends) {
+		return ts.backends, ts.totalWeight
+	}
+	return backends, totalWeight
+}
+
+// byWeight returns the backend covering n, 0 <= n < total weight
+func byWeight(backends []weightedBackend, n int) weightedBackend {
+	for _, backend := range backends {
+		if n < backend.Weight {
+			return backend
+		}
+		n -= backend.Weight
+	}
+	return backends[0]
+}
+
+// matches checks if the request matches the backend match rules
+func (backend weightedBackend) matches(r *http.Request) bool {
+	if len(backend.Match.Headers) == 0 && len(backend.Match.Cookies) == 0 {
+		return false
+	}
+	for k, v := range backend.Match.Headers {
+		if r.Header.Get(k) != v {
+			return false
+		}
+	}
+	for k, v := range backend.Match.Cookies {
+		cookie, err := r.Cookie(k)
+		if err != nil || cookie.Value != v {
+			return false
+		}
+	}
+	return true
+}
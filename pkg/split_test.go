@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrafficSplitter(t *testing.T) {
+	splitter, err := NewTrafficSplitter("/store", []Backend{
+		{Name: "stable", Destination: "http://store-v1:8080", Weight: 90},
+		{Name: "canary", Destination: "http://store-v2:8080", Weight: 10, Match: BackendMatch{Headers: map[string]string{"X-Canary": "true"}}},
+	}, Sticky{Cookie: "goma-backend", Header: "X-User-Id"})
+	if err != nil {
+		t.Fatalf("Error creating traffic splitter: %v", err)
+	}
+	choose := func(headers map[string]string, cookie *http.Cookie) (string, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest(http.MethodGet, "/store/items", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		name, _ := splitter.Choose(rec, r)
+		return name, rec
+	}
+	t.Run("match override", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			if name, _ := choose(map[string]string{"X-Canary": "true"}, nil); name != "canary" {
+				t.Fatalf("expected canary backend, got %s", name)
+			}
+		}
+	})
+	t.Run("sticky header", func(t *testing.T) {
+		first, _ := choose(map[string]string{"X-User-Id": "42"}, nil)
+		for i := 0; i < 20; i++ {
+			if name, _ := choose(map[string]string{"X-User-Id": "42"}, nil); name != first {
+				t.Fatalf("expected sticky backend %s, got %s", first, name)
+			}
+		}
+	})
+	t.Run("sticky cookie", func(t *testing.T) {
+		_, rec := choose(nil, nil)
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "goma-backend" {
+			t.Fatalf("expected the sticky cookie to be set")
+		}
+		for i := 0; i < 20; i++ {
+			if name, _ := choose(nil, &http.Cookie{Name: "goma-backend", Value: "canary"}); name != "canary" {
+				t.Fatalf("expected canary backend, got %s", name)
+			}
+		}
+	})
+	t.Run("weights", func(t *testing.T) {
+		splitter, err := NewTrafficSplitter("/store", []Backend{
+			{Name: "stable", Destination: "http://store-v1:8080", Weight: 0},
+			{Name: "canary", Destination: "http://store-v2:8080", Weight: 100},
+		}, Sticky{})
+		if err != nil {
+			t.Fatalf("Error creating traffic splitter: %v", err)
+		}
+		for i := 0; i < 20; i++ {
+			if name, _ := splitter.Choose(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/store", nil)); name != "canary" {
+				t.Fatalf("expected canary backend, got %s", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/goma/internal/logger"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type StaticRoute struct {
+	path   string
+	static Static
+}
+
+// precompressedEncodings defines the precompressed file extensions, by order of preference
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{encoding: "br", extension: ".br"},
+	{encoding: "gzip", extension: ".gz"},
+}
+
+// StaticHandler serves files from the route directory
+func (staticRoute StaticRoute) StaticHandler() http.HandlerFunc {
+	static := staticRoute.static
+	if static.Index == "" {
+		static.Index = "index.html"
+	}
+	if info, err := os.Stat(static.Dir); err != nil || !info.IsDir() {
+		logger.Error("Route %s: static directory %s not found", staticRoute.path, static.Dir)
+	}
+	prefix := strings.TrimSuffix(staticRoute.path, "/")
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("%s %s %s %s", r.Method, r.RemoteAddr, r.URL, r.UserAgent())
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		// The prefix matches path segments, /app doesn't serve /application
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+			RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Not found: %s", r.URL.Path))
+			return
+		}
+		// Clean the path to prevent directory traversal
+		name := path.Clean("/" + rest)
+		filePath := filepath.Join(static.Dir, filepath.FromSlash(name))
+		info, err := os.Stat(filePath)
+		if err == nil && info.IsDir() {
+			indexPath := filepath.Join(filePath, static.Index)
+			if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+				staticRoute.serveFile(w, r, indexPath, indexInfo)
+				return
+			}
+			if static.Browse {
+				// Directory links are relative, the directory path must end with a slash
+				if !strings.HasSuffix(r.URL.Path, "/") {
+					http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+					return
+				}
+				listDirectory(w, filePath)
+				return
+			}
+		} else if err == nil {
+			staticRoute.serveFile(w, r, filePath, info)
+			return
+		}
+		if static.SPA {
+			indexPath := filepath.Join(static.Dir, static.Index)
+			if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+				staticRoute.serveFile(w, r, indexPath, indexInfo)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Not found: %s", r.URL.Path))
+	}
+}
+
+// serveFile serves a file with ETag, Last-Modified and range requests support
+func (staticRoute StaticRoute) serveFile(w http.ResponseWriter, r *http.Request, filePath string, info os.FileInfo) {
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if staticRoute.static.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, p := range precompressedEncodings {
+			if !acceptsEncoding(r, p.encoding) {
+				continue
+			}
+			compressedInfo, err := os.Stat(filePath + p.extension)
+			if err != nil || compressedInfo.IsDir() {
+				continue
+			}
+			filePath, info = filePath+p.extension, compressedInfo
+			w.Header().Set("Content-Encoding", p.encoding)
+			break
+		}
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.Error("Error opening file %s: %v", filePath, err)
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+		}
+	}(file)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// acceptsEncoding checks if the client accepts the content encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		value, params, _ := strings.Cut(strings.TrimSpace(accepted), ";")
+		if strings.TrimSpace(value) != encoding {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+		return true
+	}
+	return false
+}
+
+// listDirectory writes an HTML directory listing
+func listDirectory(w http.ResponseWriter, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("Error reading directory %s: %v", dir, err)
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "<!doctype html>\n<pre>")
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		_, _ = fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	_, _ = fmt.Fprintln(w, "</pre>")
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticRoute(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":      "<html>app</html>",
+		"app.js":          "console.log('goma')",
+		"app.js.gz":       "gzipped",
+		"docs/readme.txt": "readme",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := StaticRoute{path: "/app", static: Static{Dir: dir, SPA: true, Precompressed: true}}.StaticHandler()
+	serve := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	t.Run("file", func(t *testing.T) {
+		rec := serve("/app/app.js", nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != files["app.js"] {
+			t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("ETag") == "" || rec.Header().Get("Last-Modified") == "" {
+			t.Error("expected ETag and Last-Modified headers")
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "text/javascript; charset=utf-8" {
+			t.Errorf("unexpected content type %q", contentType)
+		}
+		rec = serve("/app/app.js", map[string]string{"If-None-Match": rec.Header().Get("ETag")})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("expected status code %d, got %d", http.StatusNotModified, rec.Code)
+		}
+	})
+	t.Run("precompressed", func(t *testing.T) {
+		rec := serve("/app/app.js", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+		if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.String() != files["app.js.gz"] {
+			t.Fatalf("expected gzip content, got %q", rec.Body.String())
+		}
+	})
+	t.Run("range", func(t *testing.T) {
+		rec := serve("/app/docs/readme.txt", map[string]string{"Range": "bytes=0-3"})
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != "read" {
+			t.Fatalf("unexpected range response %d %q", rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("spa fallback", func(t *testing.T) {
+		rec := serve("/app/users/12", nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != files["index.html"] {
+			t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("prefix boundary", func(t *testing.T) {
+		for _, target := range []string{"/appdocs/readme.txt", "/application"} {
+			if rec := serve(target, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("%s: expected status code %d, got %d %q", target, http.StatusNotFound, rec.Code, rec.Body.String())
+			}
+		}
+		if rec := serve("/app", nil); rec.Code != http.StatusOK || rec.Body.String() != files["index.html"] {
+			t.Errorf("expected the index for the prefix, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("traversal", func(t *testing.T) {
+		rec := serve("/app/../../etc/passwd", nil)
+		if rec.Body.String() != files["index.html"] {
+			t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jkaninda/goma/internal/logger"
+	"github.com/jkaninda/goma/pkg/middleware"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultTLSListenAddr is the default HTTPS listen address
+const defaultTLSListenAddr = "0.0.0.0:443"
+
+// enabled returns true when the HTTPS listener is configured
+func (t TLS) enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// listenAddr returns the HTTPS listen address
+func (t TLS) listenAddr() string {
+	if t.ListenAddr == "" {
+		return defaultTLSListenAddr
+	}
+	return t.ListenAddr
+}
+
+// serverConfig creates the HTTPS listener TLS config
+func (t TLS) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	clientAuth := t.ClientAuth
+	if clientAuth.CAFile == "" {
+		if clientAuth.Required || len(clientAuth.Hosts) != 0 || clientAuth.CRLFile != "" {
+			return nil, errors.New("client certificates authentication requires a CA file")
+		}
+		return config, nil
+	}
+	caPEM, err := os.ReadFile(clientAuth.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in %s", clientAuth.CAFile)
+	}
+	config.ClientCAs = clientCAs
+	// Client certificates are optional and verified when sent, routes can require them with the mtls middleware
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if clientAuth.Required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if clientAuth.CRLFile != "" {
+		crl, err := loadCRL(clientAuth.CRLFile, caPEM)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, c := range chain {
+					if crl.revoked(c) {
+						return fmt.Errorf("client certificate %s is revoked", c.Subject)
+					}
+				}
+			}
+			return nil
+		}
+	}
+	if len(clientAuth.Hosts) != 0 && !clientAuth.Required {
+		required := config.Clone()
+		required.ClientAuth = tls.RequireAndVerifyClientCert
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if matchHost(clientAuth.Hosts, hello.ServerName) {
+				return required, nil
+			}
+			return nil, nil
+		}
+	}
+	return config, nil
+}
+
+// revocationList contains the revoked certificates of a CA
+type revocationList struct {
+	issuer  []byte
+	serials map[string]bool
+}
+
+// loadCRL reads a PEM or DER encoded CRL and checks its signature against the CA bundle
+func loadCRL(file string, caPEM []byte) (*revocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CRL file: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CRL file: %w", err)
+	}
+	signed := false
+	for block, rest := pem.Decode(caPEM); block != nil; block, rest = pem.Decode(rest) {
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err == nil && crl.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, fmt.Errorf("CRL %s is not signed by a client CA", file)
+	}
+	list := &revocationList{issuer: crl.RawIssuer, serials: map[string]bool{}}
+	for _, entry := range crl.RevokedCertificateEntries {
+		list.serials[entry.SerialNumber.String()] = true
+	}
+	return list, nil
+}
+
+// revoked returns true when the certificate is in the revocation list
+func (crl *revocationList) revoked(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, crl.issuer) && crl.serials[cert.SerialNumber.String()]
+}
+
+// matchHost checks the host against host names, *.example.com matches subdomains
+func matchHost(hosts []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if h == host || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCertMiddleware requires a verified client certificate on all hosts or on the client auth hosts,
+// the verified certificate is forwarded to the backends in the default mtls middleware headers.
+//
+// The TLS server name and the Host header can differ, and requests can use the HTTP listener, the Host header is checked as well.
+func clientCertMiddleware(clientAuth ClientAuth) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			cert := middleware.SetClientCertHeaders(r, nil)
+			if (clientAuth.Required || matchHost(clientAuth.Hosts, host)) && cert == nil {
+				logger.Error("Proxy error, missing client certificate for host %s", host)
+				RespondWithError(w, http.StatusUnauthorized, "Client certificate required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by the parent, self-signed when the parent is nil
+func newTestCert(t *testing.T, serial int64, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func writePEM(t *testing.T, file, blockType string, data []byte) string {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, &x509.Certificate{Subject: pkix.Name{CommonName: "Goma CA"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign}, nil)
+	server := newTestCert(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost", "partner.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca)
+	client := newTestCert(t, 3, &x509.Certificate{Subject: pkix.Name{CommonName: "partner-a"}, DNSNames: []string{"partner-a.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	revoked := newTestCert(t, 4, &x509.Certificate{Subject: pkix.Name{CommonName: "partner-b"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(4), RevocationTime: time.Now()}},
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := TLS{
+		CertFile: writePEM(t, filepath.Join(dir, "server.pem"), "CERTIFICATE", server.der),
+		KeyFile:  writePEM(t, filepath.Join(dir, "server-key.pem"), "EC PRIVATE KEY", keyDER),
+		ClientAuth: ClientAuth{
+			CAFile:  writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.der),
+			CRLFile: writePEM(t, filepath.Join(dir, "crl.pem"), "X509 CRL", crl),
+			Hosts:   []string{"partner.example.com"},
+		},
+	}
+	config, err := tlsConf.serverConfig()
+	if err != nil {
+		t.Fatalf("Error creating TLS config: %v", err)
+	}
+	s := httptest.NewUnstartedServer(clientCertMiddleware(tlsConf.ClientAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-Cert-Subject")))
+	})))
+	s.TLS = config
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name       string
+		serverName string
+		cert       *testCert
+		wantCode   int
+		wantErr    bool
+		// wantSubject is the forwarded certificate subject, the client sends a forged one
+		wantSubject string
+	}{
+		{name: "optional without certificate", wantCode: http.StatusOK},
+		{name: "optional with certificate", cert: client, wantCode: http.StatusOK, wantSubject: "CN=partner-a"},
+		{name: "required host with certificate", serverName: "partner.example.com", cert: client, wantCode: http.StatusOK, wantSubject: "CN=partner-a"},
+		{name: "required host without certificate", serverName: "partner.example.com", wantErr: true},
+		{name: "revoked certificate", cert: revoked, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := &tls.Config{RootCAs: roots, ServerName: tt.serverName}
+			if tt.cert != nil {
+				clientConfig.Certificates = []tls.Certificate{tt.cert.tlsCertificate()}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+			req.Header.Set("X-Client-Cert-Subject", "CN=forged")
+			resp, err := c.Do(req)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected a TLS handshake error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error sending request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, resp.StatusCode)
+			}
+			if body, _ := io.ReadAll(resp.Body); string(body) != tt.wantSubject {
+				t.Errorf("expected the certificate subject %q, got %q", tt.wantSubject, body)
+			}
+		})
+	}
+	// The Host header is checked when the TLS server name differs
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	req.Host = "partner.example.com"
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	hosts := []string{"partner.example.com", "*.b2b.example.com"}
+	for host, want := range map[string]bool{"partner.example.com": true, "PARTNER.example.com.": true, "a.b2b.example.com": true,
+		"b2b.example.com": false, "www.example.com": false} {
+		if got := matchHost(hosts, host); got != want {
+			t.Errorf("matchHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package pkg
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traefikConfig is a Traefik dynamic configuration, only the HTTP routers, services and middlewares are converted
+type traefikConfig struct {
+	HTTP struct {
+		Routers     map[string]yaml.Node            `yaml:"routers"`
+		Services    map[string]yaml.Node            `yaml:"services"`
+		Middlewares map[string]map[string]yaml.Node `yaml:"middlewares"`
+	} `yaml:"http"`
+	TCP *yaml.Node `yaml:"tcp"`
+	UDP *yaml.Node `yaml:"udp"`
+	TLS *yaml.Node `yaml:"tls"`
+}
+
+type traefikRouter struct {
+	Rule        string     `yaml:"rule"`
+	Service     string     `yaml:"service"`
+	Middlewares []string   `yaml:"middlewares"`
+	EntryPoints []string   `yaml:"entryPoints"`
+	Priority    int        `yaml:"priority"`
+	TLS         *yaml.Node `yaml:"tls"`
+}
+
+type traefikServer struct {
+	URL    string `yaml:"url"`
+	Weight *int   `yaml:"weight"`
+}
+
+type traefikService struct {
+	LoadBalancer *struct {
+		Servers []traefikServer `yaml:"servers"`
+		Sticky  *struct {
+			Cookie *struct {
+				Name string `yaml:"name"`
+			} `yaml:"cookie"`
+		} `yaml:"sticky"`
+		HealthCheck *struct {
+			Path string `yaml:"path"`
+		} `yaml:"healthCheck"`
+	} `yaml:"loadBalancer"`
+	Weighted *struct {
+		Services []struct {
+			Name   string `yaml:"name"`
+			Weight int    `yaml:"weight"`
+		} `yaml:"services"`
+	} `yaml:"weighted"`
+	Mirroring *struct {
+		Service string `yaml:"service"`
+		Mirrors []struct {
+			Name    string `yaml:"name"`
+			Percent int    `yaml:"percent"`
+		} `yaml:"mirrors"`
+	} `yaml:"mirroring"`
+}
+
+type traefikBasicAuth struct {
+	Users        []string `yaml:"users"`
+	UsersFile    string   `yaml:"usersFile"`
+	Realm        string   `yaml:"realm"`
+	HeaderField  string   `yaml:"headerField"`
+	RemoveHeader bool     `yaml:"removeHeader"`
+}
+
+type traefikIPAllowList struct {
+	SourceRange []string `yaml:"sourceRange"`
+}
+
+type traefikForwardAuth struct {
+	Address             string   `yaml:"address"`
+	AuthResponseHeaders []string `yaml:"authResponseHeaders"`
+	AuthRequestHeaders  []string `yaml:"authRequestHeaders"`
+	TrustForwardHeader  bool     `yaml:"trustForwardHeader"`
+}
+
+type traefikRateLimit struct {
+	Average int    `yaml:"average"`
+	Period  string `yaml:"period"`
+	Burst   int    `yaml:"burst"`
+}
+
+type traefikRedirectScheme struct {
+	Scheme    string `yaml:"scheme"`
+	Permanent bool   `yaml:"permanent"`
+	Port      string `yaml:"port"`
+}
+
+type traefikStripPrefix struct {
+	Prefixes []string `yaml:"prefixes"`
+}
+
+type traefikAddPrefix struct {
+	Prefix string `yaml:"prefix"`
+}
+
+type traefikReplacePath struct {
+	Path string `yaml:"path"`
+}
+
+type traefikReplacePathRegex struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+type traefikChain struct {
+	Middlewares []string `yaml:"middlewares"`
+}
+
+// traefikIgnoredMiddlewares contains the middleware kinds that don't restrict access, the routes using them are converted with a warning.
+//
+// Routes using other untranslated middlewares, e.g. digestAuth, return 403
+var traefikIgnoredMiddlewares = map[string]bool{
+	"headers": true, "compress": true, "retry": true, "buffering": true, "circuitBreaker": true, "errors": true,
+	"inFlightReq": true, "contentType": true, "grpcWeb": true, "passTLSClientCert": true,
+}
+
+type traefikConverter struct {
+	*converter
+	config traefikConfig
+}
+
+// traefik converts the Traefik HTTP routers, in name order, to routes
+func (c *converter) traefik(buf []byte) error {
+	t := &traefikConverter{converter: c}
+	if err := yaml.Unmarshal(buf, &t.config); err != nil {
+		return fmt.Errorf("error parsing Traefik configuration: %w", err)
+	}
+	for name, node := range map[string]*yaml.Node{"tcp": t.config.TCP, "udp": t.config.UDP, "tls": t.config.TLS} {
+		if node != nil {
+			t.warnf("%s configuration is not translated", name)
+		}
+	}
+	names := make([]string, 0, len(t.config.HTTP.Routers))
+	for name := range t.config.HTTP.Routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.router(name)
+	}
+	return nil
+}
+
+// decode decodes a Traefik object, the fields not translated are warned
+func (t *traefikConverter) decode(node yaml.Node, out interface{}, context string) bool {
+	if err := node.Decode(out); err != nil {
+		t.warnf("%s: %v", context, err)
+		return false
+	}
+	var fields map[string]interface{}
+	_ = node.Decode(&fields)
+	known := map[string]bool{}
+	typ := reflect.TypeOf(out).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		known[yamlFieldName(typ.Field(i))] = true
+	}
+	for field := range fields {
+		if !known[field] {
+			t.warnf("%s: %s is not translated", context, field)
+		}
+	}
+	return true
+}
+
+// providerName removes the provider suffix of a name, only the file provider is converted
+func (t *traefikConverter) providerName(name, context string) (string, bool) {
+	name, provider, found := strings.Cut(name, "@")
+	if found && provider != "file" {
+		t.warnf("%s: %s@%s, provider %s is not translated", context, name, provider, provider)
+		return "", false
+	}
+	return name, true
+}
+
+// router converts a router, its service and middlewares to a route
+func (t *traefikConverter) router(name string) {
+	context := "router " + name
+	var router traefikRouter
+	if !t.decode(t.config.HTTP.Routers[name], &router, context) {
+		return
+	}
+	hosts, path, exact, err := parseTraefikRule(router.Rule)
+	if err != nil {
+		t.warnf("%s: %v, the router is not translated", context, err)
+		return
+	}
+	if exact {
+		t.warnf("%s: Path(`%s`), exact match is converted to a prefix", context, path)
+	}
+	if router.Priority != 0 {
+		t.warnf("%s: priority is not translated, goma matches the routes with hosts first, then the longest paths", context)
+	}
+	if router.TLS != nil {
+		t.warnf("%s: tls is not translated, configure the gateway tls certificate", context)
+	}
+	route := Route{Name: routeName(name), Path: path, Hosts: hosts}
+	service, ok := t.providerName(router.Service, context)
+	if !ok || !t.service(&route, service, context) {
+		return
+	}
+	var rules []string
+	stripped := false
+	middlewares, translated := t.expand(router.Middlewares, context, 0)
+	for _, m := range middlewares {
+		rule, ok := t.middleware(&route, m, &stripped)
+		if !ok {
+			translated = false
+		}
+		if rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	if !translated {
+		t.denyRoute(&route, context+": the middlewares are not translated")
+		t.addRoute(route)
+		return
+	}
+	if len(rules) != 0 {
+		route.Middlewares = []RouteMiddleware{{Path: "/", Rules: rules}}
+	}
+	t.addRoute(route)
+}
+
+// expand returns the middleware names, chains are replaced by their middlewares.
+// It returns false when a middleware is not found or defined by another provider
+func (t *traefikConverter) expand(names []string, context string, depth int) ([]string, bool) {
+	var result []string
+	translated := true
+	for _, n := range names {
+		name, ok := t.providerName(n, context)
+		if !ok {
+			translated = false
+			continue
+		}
+		definition, ok := t.config.HTTP.Middlewares[name]
+		if !ok {
+			t.warnf("%s: middleware %s not found", context, name)
+			translated = false
+			continue
+		}
+		node, ok := definition["chain"]
+		if !ok {
+			result = append(result, name)
+			continue
+		}
+		var chain traefikChain
+		if depth >= 10 || !t.decode(node, &chain, "middleware "+name) {
+			translated = false
+			continue
+		}
+		middlewares, ok := t.expand(chain.Middlewares, "middleware "+name, depth+1)
+		result = append(result, middlewares...)
+		translated = translated && ok
+	}
+	return result, translated
+}
+
+// middleware applies a path or redirect middleware to the route, or returns the name of the converted goma middleware.
+// It returns false when an authentication, access or unknown middleware is not translated
+func (t *traefikConverter) middleware(route *Route, name string, stripped *bool) (string, bool) {
+	context := "middleware " + name
+	for kind, node := range t.config.HTTP.Middlewares[name] {
+		switch kind {
+		case "basicAuth":
+			var basicAuth traefikBasicAuth
+			if !t.decode(node, &basicAuth, context) {
+				return "", false
+			}
+			if basicAuth.RemoveHeader {
+				t.warnf("%s: removeHeader is not translated", context)
+			}
+			return t.addMiddleware(Middleware{Name: name, Type: "basic", Rule: BasicRule{Users: basicAuth.Users,
+				HtpasswdFile: basicAuth.UsersFile, Realm: basicAuth.Realm, UserHeader: basicAuth.HeaderField}}), true
+		case "ipAllowList", "ipWhiteList":
+			var allowList traefikIPAllowList
+			if !t.decode(node, &allowList, context) {
+				return "", false
+			}
+			return t.addMiddleware(Middleware{Name: name, Type: "access", Rule: AccessRule{Allow: allowList.SourceRange}}), true
+		case "forwardAuth":
+			var forwardAuth traefikForwardAuth
+			if !t.decode(node, &forwardAuth, context) {
+				return "", false
+			}
+			headers := map[string]string{}
+			for _, header := range forwardAuth.AuthResponseHeaders {
+				headers[header] = header
+			}
+			return t.addMiddleware(Middleware{Name: name, Type: "forwardAuth", Rule: JWTRuler{URL: forwardAuth.Address,
+				Headers: headers, ForwardHeaders: forwardAuth.AuthRequestHeaders}}), true
+		case "rateLimit":
+			var rateLimit traefikRateLimit
+			if !t.decode(node, &rateLimit, context) {
+				return "", true
+			}
+			period := time.Second
+			if rateLimit.Period != "" {
+				if seconds, err := strconv.Atoi(rateLimit.Period); err == nil {
+					period = time.Duration(seconds) * time.Second
+				} else if period, err = time.ParseDuration(rateLimit.Period); err != nil {
+					t.warnf("%s: invalid period %s", context, rateLimit.Period)
+					return "", true
+				}
+			}
+			if rateLimit.Burst != 0 {
+				t.warnf("%s: burst is not translated", context)
+			}
+			if period > 0 {
+				t.setRateLimit(int(float64(rateLimit.Average)*float64(time.Minute)/float64(period)), context)
+			}
+		case "redirectScheme":
+			var redirect traefikRedirectScheme
+			if !t.decode(node, &redirect, context) {
+				return "", true
+			}
+			if redirect.Port != "" {
+				t.warnf("%s: port is not translated", context)
+			}
+			code := 302
+			if redirect.Permanent {
+				code = 301
+			}
+			route.Type = RouteTypeRedirect
+			route.Destination, route.Backends, route.Sticky, route.HealthCheck = "", nil, Sticky{}, ""
+			route.Redirect = Redirect{Scheme: redirect.Scheme, Code: code}
+			if redirect.Scheme == "https" {
+				route.Redirect = Redirect{HTTPS: true, Code: code}
+			}
+		case "stripPrefix":
+			var stripPrefix traefikStripPrefix
+			if !t.decode(node, &stripPrefix, context) {
+				return "", true
+			}
+			for _, prefix := range stripPrefix.Prefixes {
+				if strings.TrimSuffix(prefix, "/") == strings.TrimSuffix(route.Path, "/") {
+					*stripped = true
+					route.Rewrite = "/"
+				} else {
+					t.warnf("%s: prefix %s is not the route path %s, it is not translated", context, prefix, route.Path)
+				}
+			}
+		case "addPrefix":
+			var addPrefix traefikAddPrefix
+			if !t.decode(node, &addPrefix, context) {
+				return "", true
+			}
+			route.Rewrite = addPrefix.Prefix
+			if !*stripped {
+				route.Rewrite = strings.TrimSuffix(addPrefix.Prefix, "/") + route.Path
+			}
+		case "replacePath":
+			var replacePath traefikReplacePath
+			if !t.decode(node, &replacePath, context) {
+				return "", true
+			}
+			route.RewriteRules = append(route.RewriteRules, RewriteRule{Pattern: "^.*$", Replacement: replacePath.Path})
+		case "replacePathRegex":
+			var replacePathRegex traefikReplacePathRegex
+			if !t.decode(node, &replacePathRegex, context) {
+				return "", true
+			}
+			route.RewriteRules = append(route.RewriteRules, RewriteRule{Pattern: replacePathRegex.Regex, Replacement: replacePathRegex.Replacement})
+		default:
+			t.warnf("%s: %s is not translated", context, kind)
+			if !traefikIgnoredMiddlewares[kind] {
+				return "", false
+			}
+		}
+	}
+	return "", true
+}
+
+// service sets the route backends, weighted services are flattened and mirrors use the first mirror server
+func (t *traefikConverter) service(route *Route, name, context string) bool {
+	if name == "" {
+		t.warnf("%s: service is required, the router is not translated", context)
+		return false
+	}
+	backends, ok := t.backends(name, 1, 0)
+	if !ok {
+		return false
+	}
+	var service traefikService
+	node := t.config.HTTP.Services[name]
+	_ = node.Decode(&service)
+	if lb := service.LoadBalancer; lb != nil {
+		if lb.Sticky != nil && lb.Sticky.Cookie != nil {
+			route.Sticky.Cookie = lb.Sticky.Cookie.Name
+			if route.Sticky.Cookie == "" {
+				route.Sticky.Cookie = "goma_backend"
+			}
+		}
+		if lb.HealthCheck != nil {
+			route.HealthCheck = lb.HealthCheck.Path
+		}
+	}
+	if mirroring := service.Mirroring; mirroring != nil {
+		for i, mirror := range mirroring.Mirrors {
+			mirrorBackends, ok := t.backends(mirror.Name, 1, 0)
+			if i > 0 || !ok || len(mirrorBackends) == 0 {
+				t.warnf("service %s: mirror %s is not translated, goma uses a single mirror", name, mirror.Name)
+				continue
+			}
+			route.Mirror = Mirror{URL: mirrorBackends[0].Destination, Percentage: mirror.Percent}
+		}
+	}
+	if len(backends) == 1 {
+		route.Destination = backends[0].Destination
+		return true
+	}
+	route.Backends = backends
+	return true
+}
+
+// backends returns the servers of a service, the weights of weighted services are multiplied
+func (t *traefikConverter) backends(name string, weight, depth int) ([]Backend, bool) {
+	context := "service " + name
+	node, ok := t.config.HTTP.Services[name]
+	if !ok || depth > 10 {
+		t.warnf("%s not found", context)
+		return nil, false
+	}
+	var service traefikService
+	if !t.decode(node, &service, context) {
+		return nil, false
+	}
+	switch {
+	case service.LoadBalancer != nil:
+		var backends []Backend
+		for _, server := range service.LoadBalancer.Servers {
+			u, err := url.Parse(server.URL)
+			if err != nil || u.Host == "" {
+				t.warnf("%s: server %s is not translated", context, server.URL)
+				continue
+			}
+			if u.Path != "" && u.Path != "/" {
+				t.warnf("%s: server %s, the path is not translated", context, server.URL)
+			}
+			backendWeight := 1
+			if server.Weight != nil {
+				backendWeight = *server.Weight
+			}
+			backends = append(backends, Backend{Name: u.Host, Destination: u.Scheme + "://" + u.Host, Weight: backendWeight * weight})
+		}
+		return backends, len(backends) != 0
+	case service.Weighted != nil:
+		var backends []Backend
+		for _, s := range service.Weighted.Services {
+			serviceName, ok := t.providerName(s.Name, context)
+			if !ok {
+				continue
+			}
+			serviceBackends, ok := t.backends(serviceName, s.Weight*weight, depth+1)
+			if ok {
+				backends = append(backends, serviceBackends...)
+			}
+		}
+		return backends, len(backends) != 0
+	case service.Mirroring != nil:
+		return t.backends(service.Mirroring.Service, weight, depth+1)
+	}
+	t.warnf("%s is not translated", context)
+	return nil, false
+}
+
+var traefikMatcher = regexp.MustCompile(`(\w+)\(([^)]*)\)`)
+
+// parseTraefikRule returns the hosts and the path of a router rule, exact is true for a Path matcher.
+//
+// Host, Path and PathPrefix matchers combined with && are supported, alternative hosts can be combined with ||
+func parseTraefikRule(rule string) (hosts []string, path string, exact bool, err error) {
+	matches := traefikMatcher.FindAllStringSubmatch(rule, -1)
+	operators := traefikMatcher.ReplaceAllString(rule, "")
+	if strings.Trim(operators, " \t\n&|()") != "" {
+		return nil, "", false, fmt.Errorf("rule %q is not supported", rule)
+	}
+	alternatives := strings.Contains(operators, "||")
+	for _, match := range matches {
+		var args []string
+		for _, arg := range strings.Split(match[2], ",") {
+			args = append(args, strings.Trim(strings.TrimSpace(arg), "`\"'"))
+		}
+		switch {
+		case match[1] == "Host":
+			hosts = append(hosts, args...)
+		case alternatives:
+			return nil, "", false, fmt.Errorf("rule %q, only hosts can be combined with ||", rule)
+		case (match[1] == "PathPrefix" || match[1] == "Path") && path == "" && len(args) == 1:
+			path, exact = args[0], match[1] == "Path"
+		default:
+			return nil, "", false, fmt.Errorf("rule %q, matcher %s is not supported", rule, match[0])
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	return hosts, path, exact, nil
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// upstreamTransports caches the backend transports by TLS settings, routes with the same settings share connections
+var (
+	upstreamTransports   = map[UpstreamTLS]*upstreamTransport{}
+	upstreamTransportsMu sync.Mutex
+)
+
+type upstreamTransport struct {
+	transport *http.Transport
+	// files identifies the CA and client certificate files content, by modification time and size
+	files string
+}
+
+// transport returns the backend transport, the default transport when no TLS setting is defined.
+//
+// The transport is rebuilt when the CA or client certificate files change, e.g. rotated certificates
+func (u UpstreamTLS) transport() (http.RoundTripper, error) {
+	if u == (UpstreamTLS{}) {
+		return http.DefaultTransport, nil
+	}
+	upstreamTransportsMu.Lock()
+	defer upstreamTransportsMu.Unlock()
+	files := u.files()
+	cached, ok := upstreamTransports[u]
+	if ok && cached.files == files {
+		return cached.transport, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         u.ServerName,
+		InsecureSkipVerify: u.InsecureSkipVerify,
+	}
+	if u.CAFile != "" {
+		caPEM, err := os.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading upstream CA file: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", u.CAFile)
+		}
+		config.RootCAs = rootCAs
+	}
+	if u.CertFile != "" || u.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(u.CertFile, u.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading upstream client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	if ok {
+		// Requests in flight complete with the previous certificates
+		cached.transport.CloseIdleConnections()
+	}
+	upstreamTransports[u] = &upstreamTransport{transport: transport, files: files}
+	return transport, nil
+}
+
+// files returns the modification time and size of the CA and client certificate files
+func (u UpstreamTLS) files() string {
+	var b strings.Builder
+	for _, file := range []string{u.CAFile, u.CertFile, u.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			_, _ = fmt.Fprintf(&b, "%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return b.String()
+}
+
+// releaseUpstreamTransports closes the transports the routes don't use, e.g. after a reload
+func releaseUpstreamTransports(routes []Route) {
+	used := map[UpstreamTLS]bool{}
+	for _, route := range routes {
+		used[route.UpstreamTLS] = true
+	}
+	upstreamTransportsMu.Lock()
+	defer upstreamTransportsMu.Unlock()
+	for u, cached := range upstreamTransports {
+		if !used[u] {
+			cached.transport.CloseIdleConnections()
+			delete(upstreamTransports, u)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, &x509.Certificate{Subject: pkix.Name{CommonName: "Internal CA"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}, nil)
+	// The backend certificate is only valid for backend.internal, the SNI override is required
+	backendCert := newTestCert(t, 2, &x509.Certificate{Subject: pkix.Name{CommonName: "backend.internal"}, DNSNames: []string{"backend.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca)
+	gatewayCert := newTestCert(t, 3, &x509.Certificate{Subject: pkix.Name{CommonName: "goma"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	keyDER, err := x509.MarshalECPrivateKey(gatewayCert.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.der)
+	certFile := writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", gatewayCert.der)
+	keyFile := writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", keyDER)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{backendCert.tlsCertificate()},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	tests := []struct {
+		name     string
+		tls      UpstreamTLS
+		wantCode int
+		wantBody string
+	}{
+		{name: "mutual TLS", tls: UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "backend.internal"},
+			wantCode: http.StatusOK, wantBody: "goma"},
+		{name: "missing client certificate", tls: UpstreamTLS{CAFile: caFile, ServerName: "backend.internal"}, wantCode: http.StatusBadGateway},
+		{name: "server name mismatch", tls: UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, wantCode: http.StatusBadGateway},
+		{name: "unknown CA", tls: UpstreamTLS{CertFile: certFile, KeyFile: keyFile, ServerName: "backend.internal"}, wantCode: http.StatusBadGateway},
+		{name: "insecure skip verify", tls: UpstreamTLS{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true},
+			wantCode: http.StatusOK, wantBody: "goma"},
+		{name: "missing CA file", tls: UpstreamTLS{CAFile: filepath.Join(dir, "missing.pem"), CertFile: certFile, KeyFile: keyFile},
+			wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyRoute := ProxyRoute{path: "/", destination: backend.URL, upstreamTLS: tt.tls}
+			rec := httptest.NewRecorder()
+			proxyRoute.ProxyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("expected body %q, got %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+
+	// Rotated CA files are read again, the previous transport is replaced
+	other := newTestCert(t, 4, &x509.Certificate{Subject: pkix.Name{CommonName: "Other CA"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}, nil)
+	rotatedCA := writePEM(t, filepath.Join(dir, "rotated-ca.pem"), "CERTIFICATE", other.der)
+	upstreamTLS := UpstreamTLS{CAFile: rotatedCA, CertFile: certFile, KeyFile: keyFile, ServerName: "backend.internal"}
+	serve := func() int {
+		proxyRoute := ProxyRoute{path: "/", destination: backend.URL, upstreamTLS: upstreamTLS}
+		rec := httptest.NewRecorder()
+		proxyRoute.ProxyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if code := serve(); code != http.StatusBadGateway {
+		t.Errorf("expected status code %d with the other CA, got %d", http.StatusBadGateway, code)
+	}
+	writePEM(t, rotatedCA, "CERTIFICATE", ca.der)
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(rotatedCA, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected status code %d with the rotated CA, got %d", http.StatusOK, code)
+	}
+
+	// Transports no route uses are released
+	releaseUpstreamTransports([]Route{{Name: "rotated", UpstreamTLS: upstreamTLS}})
+	upstreamTransportsMu.Lock()
+	defer upstreamTransportsMu.Unlock()
+	if len(upstreamTransports) != 1 || upstreamTransports[upstreamTLS] == nil {
+		t.Errorf("expected only the rotated CA transport, got %d transports", len(upstreamTransports))
+	}
+}
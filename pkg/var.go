@@ -1,3 +1,25 @@
 package pkg
 
 const ConfigFile = "/config/goma.yml"
+
+// Route types
+const (
+	RouteTypeProxy    = "proxy"
+	RouteTypeRedirect = "redirect"
+	RouteTypeStatic   = "static"
+	RouteTypeMock     = "mock"
+)
+
+// Route path middlewares modes
+const (
+	MiddlewareModeAllOf = "allOf"
+	MiddlewareModeAnyOf = "anyOf"
+)
+
+// Route backends discovery types
+const (
+	DiscoveryTypeDNS    = "dns"
+	DiscoveryTypeSRV    = "srv"
+	DiscoveryTypeFile   = "file"
+	DiscoveryTypeConsul = "consul"
+)